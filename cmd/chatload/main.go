@@ -0,0 +1,317 @@
+// chatload 聊天 WebSocket 压测工具：建立大量连接到同一个房间，其中一部分连接按固定速率发消息，
+// 统计广播送达率和端到端延迟（发送到每个连接收到广播）。
+//
+// 示例（单节点 1 万连接，100 个发送者每秒各发 1 条）：
+//
+//	ulimit -n 65535
+//	go run ./cmd/chatload -url ws://localhost:8080/api/v1/chat/1/ws -token <JWT> \
+//		-clients 10000 -senders 100 -rate 1 -duration 1m
+//
+// 所有连接可以使用同一个用户的令牌；房间需关闭慢速模式。
+// 压测进程和服务端最好不在同一台机器上，否则两者争抢 CPU，结果偏悲观。
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const contentPrefix = "chatload:" // 压测消息内容前缀，后跟发送时间（UnixNano）
+
+type options struct {
+	url      string
+	tokens   []string
+	clients  int
+	senders  int
+	rate     float64
+	ramp     int
+	duration time.Duration
+	compress bool
+	maxP99   time.Duration
+}
+
+// stats 各连接并发累加，全部使用原子操作
+type stats struct {
+	connected    atomic.Int64
+	connectFails atomic.Int64
+	disconnects  atomic.Int64
+	sent         atomic.Int64
+	acked        atomic.Int64
+	errors       atomic.Int64
+	received     atomic.Int64 // 收到的压测消息广播
+	delivery     *histogram   // 发送到收到广播的延迟
+	ack          *histogram   // 发送到收到 ack 的延迟
+}
+
+func main() {
+	opts := parseFlags()
+	st := &stats{delivery: newHistogram(), ack: newHistogram()}
+	dialer := &websocket.Dialer{
+		HandshakeTimeout:  10 * time.Second,
+		Subprotocols:      []string{"chat.v1"},
+		EnableCompression: opts.compress,
+		ReadBufferSize:    1024,
+		WriteBufferSize:   1024,
+		WriteBufferPool:   &sync.Pool{},
+	}
+
+	// 发送者在所有连接建立后才开始发消息
+	start, stop := make(chan struct{}), make(chan struct{})
+	sendInterval := time.Duration(float64(time.Second) / opts.rate)
+
+	// 按 ramp 速率建立连接，前 senders 个连接负责发消息
+	log.Printf("Connecting %d clients at %d/s", opts.clients, opts.ramp)
+	interval := time.Second / time.Duration(max(opts.ramp, 1))
+	rampStart := time.Now()
+	for i := 0; i < opts.clients; i++ {
+		header := http.Header{}
+		header.Set("Authorization", "Bearer "+opts.tokens[i%len(opts.tokens)])
+		conn, _, err := dialer.Dial(opts.url, header)
+		if err != nil {
+			st.connectFails.Add(1)
+			if st.connectFails.Load() <= 10 {
+				log.Printf("Connect failed: %v", err)
+			}
+		} else {
+			st.connected.Add(1)
+			go readLoop(conn, st)
+			if i < opts.senders {
+				// 错开各发送者的起始时间，避免所有消息集中在同一时刻
+				offset := sendInterval * time.Duration(i) / time.Duration(opts.senders)
+				go sendLoop(conn, offset, sendInterval, st, start, stop)
+			}
+		}
+		if i%1000 == 999 {
+			log.Printf("%d connected, %d failed", st.connected.Load(), st.connectFails.Load())
+		}
+		time.Sleep(time.Until(rampStart.Add(time.Duration(i+1) * interval)))
+	}
+	log.Printf("Ramp finished in %s: %d connected, %d failed",
+		time.Since(rampStart).Round(time.Millisecond), st.connected.Load(), st.connectFails.Load())
+
+	// 压测阶段：定期输出进度
+	close(start)
+	ticker := time.NewTicker(5 * time.Second)
+	deadline := time.After(opts.duration)
+	began := time.Now()
+loop:
+	for {
+		select {
+		case <-ticker.C:
+			log.Printf("[%s] open=%d sent=%d acked=%d received=%d p99=%s",
+				time.Since(began).Round(time.Second), st.connected.Load()-st.disconnects.Load(),
+				st.sent.Load(), st.acked.Load(), st.received.Load(), st.delivery.percentile(0.99))
+		case <-deadline:
+			break loop
+		}
+	}
+	ticker.Stop()
+	close(stop)
+	// 等待最后一批广播送达
+	time.Sleep(2 * time.Second)
+
+	if !report(opts, st, time.Since(began)) {
+		os.Exit(1)
+	}
+}
+
+func parseFlags() *options {
+	opts := &options{}
+	var token, tokenFile string
+	flag.StringVar(&opts.url, "url", "", "WebSocket URL, e.g. ws://localhost:8080/api/v1/chat/1/ws")
+	flag.StringVar(&token, "token", "", "access token used by all connections")
+	flag.StringVar(&tokenFile, "tokens", "", "file with one access token per line, used round-robin")
+	flag.IntVar(&opts.clients, "clients", 10000, "number of connections")
+	flag.IntVar(&opts.senders, "senders", 100, "number of connections that send messages")
+	flag.Float64Var(&opts.rate, "rate", 1, "messages per second per sender")
+	flag.IntVar(&opts.ramp, "ramp", 500, "new connections per second")
+	flag.DurationVar(&opts.duration, "duration", time.Minute, "test duration after all clients are connected")
+	flag.BoolVar(&opts.compress, "compress", false, "negotiate permessage-deflate")
+	flag.DurationVar(&opts.maxP99, "max-p99", 500*time.Millisecond, "fail if p99 delivery latency exceeds this")
+	flag.Parse()
+
+	if opts.url == "" {
+		log.Fatal("-url is required")
+	}
+	if token != "" {
+		opts.tokens = append(opts.tokens, token)
+	}
+	if tokenFile != "" {
+		f, err := os.Open(tokenFile)
+		if err != nil {
+			log.Fatalf("Failed to open token file: %v", err)
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" {
+				opts.tokens = append(opts.tokens, line)
+			}
+		}
+		f.Close()
+	}
+	if len(opts.tokens) == 0 {
+		log.Fatal("-token or -tokens is required")
+	}
+	if opts.rate <= 0 {
+		log.Fatal("-rate must be positive")
+	}
+	if opts.senders > opts.clients {
+		opts.senders = opts.clients
+	}
+	return opts
+}
+
+// inboundFrame 只解析统计需要的字段
+type inboundFrame struct {
+	Type    string `json:"type"`
+	ID      string `json:"id"`
+	Payload struct {
+		Content string `json:"content"`
+	} `json:"payload"`
+}
+
+func readLoop(conn *websocket.Conn, st *stats) {
+	defer func() {
+		conn.Close()
+		st.disconnects.Add(1)
+	}()
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		now := time.Now()
+		var frame inboundFrame
+		if err := json.Unmarshal(data, &frame); err != nil {
+			continue
+		}
+		switch frame.Type {
+		case "message":
+			if sentAt, ok := parseSentAt(frame.Payload.Content); ok {
+				st.received.Add(1)
+				st.delivery.observe(now.Sub(sentAt))
+			}
+		case "ack":
+			if sentAt, ok := parseSentAt(frame.ID); ok {
+				st.acked.Add(1)
+				st.ack.observe(now.Sub(sentAt))
+			}
+		case "error":
+			st.errors.Add(1)
+		}
+	}
+}
+
+func sendLoop(conn *websocket.Conn, offset, interval time.Duration, st *stats, start, stop <-chan struct{}) {
+	select {
+	case <-start:
+	case <-stop:
+		return
+	}
+	time.Sleep(offset)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			stamp := contentPrefix + strconv.FormatInt(time.Now().UnixNano(), 10)
+			frame := map[string]interface{}{
+				"type":    "message",
+				"id":      stamp,
+				"payload": map[string]interface{}{"content": stamp},
+			}
+			conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if err := conn.WriteJSON(frame); err != nil {
+				return
+			}
+			st.sent.Add(1)
+		}
+	}
+}
+
+func parseSentAt(s string) (time.Time, bool) {
+	if !strings.HasPrefix(s, contentPrefix) {
+		return time.Time{}, false
+	}
+	nanos, err := strconv.ParseInt(strings.TrimPrefix(s, contentPrefix), 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, nanos), true
+}
+
+// report 输出结果，送达率和 p99 延迟都达标时返回 true
+func report(opts *options, st *stats, elapsed time.Duration) bool {
+	open := st.connected.Load() - st.disconnects.Load()
+	sent := st.sent.Load()
+	expected := sent * st.connected.Load()
+	received := st.received.Load()
+	ratio := 1.0
+	if expected > 0 {
+		ratio = float64(received) / float64(expected)
+	}
+	p99 := st.delivery.percentile(0.99)
+
+	fmt.Println()
+	fmt.Printf("connections   %d connected, %d failed, %d still open\n", st.connected.Load(), st.connectFails.Load(), open)
+	fmt.Printf("messages      %d sent, %d acked, %d errors (%.1f msg/s)\n", sent, st.acked.Load(), st.errors.Load(), float64(sent)/elapsed.Seconds())
+	fmt.Printf("deliveries    %d of %d expected (%.3f%%, %.0f frames/s)\n", received, expected, ratio*100, float64(received)/elapsed.Seconds())
+	fmt.Printf("delivery      p50=%s p95=%s p99=%s max=%s\n",
+		st.delivery.percentile(0.5), st.delivery.percentile(0.95), p99, st.delivery.percentile(1))
+	fmt.Printf("ack           p50=%s p99=%s\n", st.ack.percentile(0.5), st.ack.percentile(0.99))
+
+	passed := st.connectFails.Load() == 0 && open == int64(opts.clients) && ratio >= 0.999 && p99 <= opts.maxP99
+	if passed {
+		fmt.Println("PASS")
+	} else {
+		fmt.Println("FAIL")
+	}
+	return passed
+}
+
+// histogram 毫秒精度的延迟直方图，超过上限的计入最后一个桶
+type histogram struct {
+	buckets [10001]atomic.Int64
+}
+
+func newHistogram() *histogram {
+	return &histogram{}
+}
+
+func (h *histogram) observe(d time.Duration) {
+	ms := min(max(d.Milliseconds(), 0), int64(len(h.buckets)-1))
+	h.buckets[ms].Add(1)
+}
+
+func (h *histogram) percentile(p float64) time.Duration {
+	var total int64
+	for i := range h.buckets {
+		total += h.buckets[i].Load()
+	}
+	if total == 0 {
+		return 0
+	}
+	target := int64(float64(total)*p + 0.5)
+	var seen int64
+	for i := range h.buckets {
+		seen += h.buckets[i].Load()
+		if seen >= max(target, 1) {
+			return time.Duration(i) * time.Millisecond
+		}
+	}
+	return time.Duration(len(h.buckets)-1) * time.Millisecond
+}
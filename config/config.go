@@ -7,10 +7,55 @@ import (
 )
 
 type Config struct {
-	Database DatabaseConfig `json:"database"`
-	Auth     AuthConfig     `json:"auth"`
-	KafkaConfig KafkaConfig `json:"kafka"`
-	RedisConfig RedisConfig `json:"redis"`
+	Database    DatabaseConfig `json:"database"`
+	Auth        AuthConfig     `json:"auth"`
+	KafkaConfig KafkaConfig    `json:"kafka"`
+	RedisConfig RedisConfig    `json:"redis"`
+	Mail        MailConfig     `json:"mail"`
+	Storage     StorageConfig  `json:"storage"`
+	Support     SupportConfig  `json:"support"`
+}
+
+// SupportConfig 客服会话分配，routing 为 least_load（默认，优先分配给接待数最少的客服）或 round_robin
+type SupportConfig struct {
+	Routing       string `json:"routing"`
+	MaxConcurrent int    `json:"max_concurrent"` // 客服未设置时的默认接待上限
+	// SLA：超过首次响应目标仍无客服回复时升级；超过解决目标仍未关闭时记为超时；无活动超过 auto_close_hours 后自动关闭
+	FirstResponseMinutes int `json:"first_response_minutes"`
+	ResolutionHours      int `json:"resolution_hours"`
+	AutoCloseHours       int `json:"auto_close_hours"`
+}
+
+// StorageConfig 聊天附件存储，driver 为 local（默认）或 s3（兼容 MinIO 等 S3 协议的服务）
+type StorageConfig struct {
+	Driver        string `json:"driver"`
+	LocalDir      string `json:"local_dir"`
+	MaxUploadSize int    `json:"max_upload_size"` // in MB
+	// 下载链接签名密钥和有效期，public_base_url 为拼接在下载路径前的站点地址（可为空）
+	URLSecret     string   `json:"url_secret"`
+	URLExpiry     int      `json:"url_expiry"` // in minutes
+	PublicBaseURL string   `json:"public_base_url"`
+	S3            S3Config `json:"s3"`
+}
+
+type S3Config struct {
+	Endpoint  string `json:"endpoint"` // 例如 https://s3.amazonaws.com 或 http://localhost:9000
+	Region    string `json:"region"`
+	Bucket    string `json:"bucket"`
+	AccessKey string `json:"access_key"`
+	SecretKey string `json:"secret_key"`
+	PathStyle bool   `json:"path_style"` // MinIO 等需要路径风格 endpoint/bucket/key
+}
+
+// MailConfig 发信配置，未配置 host 时邮件只写入日志
+type MailConfig struct {
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	From     string `json:"from"`
+	// 邮箱变更确认页地址，邮件中的链接为 <email_confirm_url>?token=xxx
+	EmailConfirmURL string `json:"email_confirm_url"`
 }
 
 type KafkaConfig struct {
@@ -43,13 +88,24 @@ type OAuthProvider struct {
 	AuthURL      string   `json:"auth_url"`           // For custom OAuth providers
 	TokenURL     string   `json:"token_url"`          // For custom OAuth providers
 	AgentID      string   `json:"agent_id,omitempty"` // 企业微信必填
+	// 自定义 OIDC provider：配置 issuer 后通过 discovery 获取端点，忽略 auth_url/token_url
+	Issuer       string            `json:"issuer,omitempty"`
+	ClaimMapping map[string]string `json:"claim_mapping,omitempty"` // id/email/email_verified/name/avatar -> claim 名
 }
 
 type AuthConfig struct {
-	JWTSecret     string `json:"jwt_secret"`
-	TokenExpiry   int    `json:"token_expiry"`   // in hours
-	RefreshExpiry int    `json:"refresh_expiry"` // in hours
-	OAuth         struct {
+	JWTSecret     string   `json:"jwt_secret"`
+	TokenExpiry   int      `json:"token_expiry"`   // in hours
+	RefreshExpiry int      `json:"refresh_expiry"` // in hours
+	DeviceExpiry  int      `json:"device_expiry"`  // in hours, 记住我设备令牌有效期
+	SignupTypes   []string `json:"signup_types"`   // 第三方登录允许自助注册的用户类型，默认只允许 client
+	// JWT 签名：RS256 或 EdDSA，密钥保存在数据库中按 key_rotation 小时轮换
+	SigningAlgorithm string `json:"signing_algorithm"`
+	KeyRotation      int    `json:"key_rotation"` // in hours
+	// 切换到非对称签名的时间（RFC3339）。设置后，该时间前用 jwt_secret 签发的 HS256 旧令牌
+	// 在 cutover + refresh_expiry 之前仍可校验；为空时不接受任何 HS256 令牌
+	LegacyHS256Cutover string `json:"legacy_hs256_cutover"`
+	OAuth            struct {
 		Google           OAuthProvider            `json:"google"`
 		GitHub           OAuthProvider            `json:"github"`
 		Facebook         OAuthProvider            `json:"facebook"`
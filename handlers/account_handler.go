@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"LiteAdmin/models"
+	"LiteAdmin/services"
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+type AccountHandler struct {
+	accounts    *services.AccountService
+	authService *services.AuthService
+	audit       *services.AuditService
+}
+
+func NewAccountHandler(accounts *services.AccountService, authService *services.AuthService, audit *services.AuditService) *AccountHandler {
+	return &AccountHandler{accounts: accounts, authService: authService, audit: audit}
+}
+
+// UpdateProfile 修改用户名、头像
+func (h *AccountHandler) UpdateProfile(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+	var req struct {
+		Username *string `json:"username"`
+		Avatar   *string `json:"avatar"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "invalid request",
+		})
+	}
+	err := h.accounts.UpdateProfile(user, services.ProfileUpdate{Username: req.Username, Avatar: req.Avatar})
+	if err != nil {
+		return accountError(c, err, "failed to update profile")
+	}
+	return c.JSON(http.StatusOK, user)
+}
+
+// ChangePassword 修改密码，其他设备上的登录全部失效，返回当前会话的新令牌
+func (h *AccountHandler) ChangePassword(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+	var req struct {
+		CurrentPassword string `json:"currentPassword" validate:"required"`
+		NewPassword     string `json:"newPassword" validate:"required,min=8"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "invalid request",
+		})
+	}
+	if err := h.accounts.ChangePassword(user, req.CurrentPassword, req.NewPassword); err != nil {
+		return accountError(c, err, "failed to change password")
+	}
+	h.audit.Record(c.Request().Context(), services.AuditEntry{
+		Action:     services.AuditPasswordChange,
+		TargetType: "user",
+		TargetID:   user.ID,
+	})
+	clearCookie(c, deviceCookieName, deviceCookiePath)
+	authResponse, err := h.authService.GenerateTokens(user)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "failed to generate tokens",
+		})
+	}
+	return c.JSON(http.StatusOK, authResponse)
+}
+
+// RequestEmailChange 申请修改邮箱，确认链接发送到新邮箱
+func (h *AccountHandler) RequestEmailChange(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+	var req struct {
+		Email    string `json:"email" validate:"required,email"`
+		Password string `json:"password"` // 本地账号必填
+	}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "invalid request",
+		})
+	}
+	if err := h.accounts.RequestEmailChange(user, req.Email, req.Password); err != nil {
+		return accountError(c, err, "failed to request email change")
+	}
+	return c.JSON(http.StatusAccepted, map[string]string{
+		"message": "confirmation email sent",
+	})
+}
+
+// ConfirmEmailChange 通过邮件中的令牌确认新邮箱
+func (h *AccountHandler) ConfirmEmailChange(c echo.Context) error {
+	var req struct {
+		Token string `json:"token" validate:"required"`
+	}
+	if err := c.Bind(&req); err != nil || req.Token == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "invalid request",
+		})
+	}
+	before, user, err := h.accounts.ConfirmEmailChange(req.Token)
+	if err != nil {
+		return accountError(c, err, "failed to confirm email change")
+	}
+	h.audit.Record(c.Request().Context(), services.AuditEntry{
+		Actor:      user,
+		Action:     services.AuditEmailChange,
+		TargetType: "user",
+		TargetID:   user.ID,
+		Before:     map[string]string{"email": before},
+		After:      map[string]string{"email": user.Email},
+	})
+	return c.JSON(http.StatusOK, user)
+}
+
+// DeleteAccount 注销当前账号
+func (h *AccountHandler) DeleteAccount(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+	var req struct {
+		Password string `json:"password"` // 本地账号
+		Confirm  string `json:"confirm"`  // 第三方登录账号填写用户名
+	}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "invalid request",
+		})
+	}
+	if err := h.accounts.DeleteAccount(c.Request().Context(), user, req.Password, req.Confirm); err != nil {
+		return accountError(c, err, "failed to delete account")
+	}
+	h.audit.Record(c.Request().Context(), services.AuditEntry{
+		Action:     services.AuditAccountDelete,
+		TargetType: "user",
+		TargetID:   user.ID,
+	})
+	clearCookie(c, deviceCookieName, deviceCookiePath)
+	return c.NoContent(http.StatusNoContent)
+}
+
+func accountError(c echo.Context, err error, message string) error {
+	switch {
+	case errors.Is(err, services.ErrUsernameTaken), errors.Is(err, services.ErrEmailTaken):
+		return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+	case errors.Is(err, services.ErrInvalidPassword), errors.Is(err, services.ErrDeleteConfirmation):
+		return c.JSON(http.StatusForbidden, map[string]string{"error": err.Error()})
+	case errors.Is(err, services.ErrInvalidEmail),
+		errors.Is(err, services.ErrWeakPassword),
+		errors.Is(err, services.ErrNoLocalPassword),
+		errors.Is(err, services.ErrEmailChangeInvalid),
+		errors.Is(err, services.ErrMerchantAccount):
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	c.Logger().Errorf("%s: %v", message, err)
+	return c.JSON(http.StatusInternalServerError, map[string]string{"error": message})
+}
@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"LiteAdmin/models"
+	"LiteAdmin/services"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+type APIKeyHandler struct {
+	apiKeys *services.APIKeyService
+	audit   *services.AuditService
+}
+
+func NewAPIKeyHandler(apiKeys *services.APIKeyService, audit *services.AuditService) *APIKeyHandler {
+	return &APIKeyHandler{apiKeys: apiKeys, audit: audit}
+}
+
+// CreateAPIKey 创建 API 密钥，明文密钥只在响应中返回一次
+func (h *APIKeyHandler) CreateAPIKey(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+	var req struct {
+		Name          string   `json:"name" validate:"required"`
+		Scopes        []string `json:"scopes" validate:"required"`
+		ExpiresInDays int      `json:"expiresInDays"` // 0 表示不过期
+	}
+	if err := c.Bind(&req); err != nil || req.Name == "" || req.ExpiresInDays < 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "invalid request body",
+		})
+	}
+	var expiresAt *time.Time
+	if req.ExpiresInDays > 0 {
+		t := time.Now().AddDate(0, 0, req.ExpiresInDays)
+		expiresAt = &t
+	}
+	raw, key, err := h.apiKeys.CreateAPIKey(c.Request().Context(), user, req.Name, req.Scopes, expiresAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrAPIKeyScopesNone):
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		case errors.Is(err, services.ErrScopeNotAllowed):
+			return c.JSON(http.StatusForbidden, map[string]string{"error": err.Error()})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "failed to create api key",
+		})
+	}
+	h.audit.Record(c.Request().Context(), services.AuditEntry{
+		Action:     services.AuditAPIKeyCreate,
+		TargetType: "api_key",
+		TargetID:   key.ID,
+		After:      key,
+	})
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"key":     raw,
+		"api_key": key,
+	})
+}
+
+// ListAPIKeys 我的 API 密钥
+func (h *APIKeyHandler) ListAPIKeys(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+	keys, err := h.apiKeys.ListAPIKeys(user.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "failed to fetch api keys",
+		})
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"api_keys": keys,
+	})
+}
+
+// RevokeAPIKey 吊销我的某个 API 密钥
+func (h *APIKeyHandler) RevokeAPIKey(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+	keyID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid api key ID"})
+	}
+	if err := h.apiKeys.RevokeAPIKey(user.ID, uint(keyID)); err != nil {
+		if errors.Is(err, services.ErrAPIKeyNotFound) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to revoke api key"})
+	}
+	h.audit.Record(c.Request().Context(), services.AuditEntry{
+		Action:     services.AuditAPIKeyRevoke,
+		TargetType: "api_key",
+		TargetID:   uint(keyID),
+	})
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": "api key revoked",
+	})
+}
@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"LiteAdmin/models"
+	"LiteAdmin/services"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+type AttachmentHandler struct {
+	db          *gorm.DB
+	attachments *services.AttachmentService
+	rooms       *services.RoomService
+}
+
+func NewAttachmentHandler(db *gorm.DB, attachments *services.AttachmentService, rooms *services.RoomService) *AttachmentHandler {
+	return &AttachmentHandler{db: db, attachments: attachments, rooms: rooms}
+}
+
+// UploadAttachment 上传聊天附件（multipart 字段 file），之后通过 WebSocket 消息的 attachment_id 发送
+func (h *AttachmentHandler) UploadAttachment(c echo.Context) error {
+	roomID := c.Param("roomId")
+	user := c.Get("user").(*models.User)
+	access, err := h.rooms.AuthorizeConnection(c.Request().Context(), roomID, user)
+	if err != nil {
+		return roomAccessError(c, err)
+	}
+	if access.Muted && (access.MutedUntil == nil || access.MutedUntil.After(time.Now())) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": services.ErrMutedInRoom.Error()})
+	}
+
+	// multipart 额外开销留 1MB 余量
+	c.Request().Body = http.MaxBytesReader(c.Response(), c.Request().Body, h.attachments.MaxUploadSize()+1<<20)
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			return c.JSON(http.StatusRequestEntityTooLarge, map[string]string{"error": services.ErrFileTooLarge.Error()})
+		}
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "file is required"})
+	}
+	if fileHeader.Size > h.attachments.MaxUploadSize() {
+		return c.JSON(http.StatusRequestEntityTooLarge, map[string]string{"error": services.ErrFileTooLarge.Error()})
+	}
+	file, err := fileHeader.Open()
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid file"})
+	}
+	defer file.Close()
+
+	attachment, err := h.attachments.Upload(c.Request().Context(), roomID, user.ID,
+		fileHeader.Filename, fileHeader.Header.Get("Content-Type"), file)
+	if err != nil {
+		switch err {
+		case services.ErrFileTooLarge:
+			return c.JSON(http.StatusRequestEntityTooLarge, map[string]string{"error": err.Error()})
+		case services.ErrUnsupportedFileType:
+			return c.JSON(http.StatusUnsupportedMediaType, map[string]string{"error": err.Error()})
+		default:
+			log.Printf("Failed to upload attachment: %v", err)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to upload file"})
+		}
+	}
+	return c.JSON(http.StatusCreated, h.attachments.View(attachment, user.ID))
+}
+
+// GetAttachment 附件信息和当前用户的签名下载链接
+func (h *AttachmentHandler) GetAttachment(c echo.Context) error {
+	roomID := c.Param("roomId")
+	user := c.Get("user").(*models.User)
+	if _, err := h.rooms.AuthorizeConnection(c.Request().Context(), roomID, user); err != nil {
+		return roomAccessError(c, err)
+	}
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid attachment ID"})
+	}
+	attachment, err := h.attachments.GetAttachment(roomID, uint(id))
+	if err == nil && !h.visible(attachment, user.ID) {
+		err = services.ErrAttachmentNotFound
+	}
+	if err != nil {
+		if err == services.ErrAttachmentNotFound {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to fetch attachment"})
+	}
+	return c.JSON(http.StatusOK, h.attachments.View(attachment, user.ID))
+}
+
+// DownloadAttachment 通过签名链接下载附件（不需要 Authorization 头，可直接用于 <img src>）；
+// 除校验签名外，每次下载都重新检查链接所属用户是否仍能进入该房间
+func (h *AttachmentHandler) DownloadAttachment(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid attachment ID"})
+	}
+	userID, _ := strconv.ParseUint(c.QueryParam("uid"), 10, 64)
+	expires, _ := strconv.ParseInt(c.QueryParam("exp"), 10, 64)
+	variant := c.QueryParam("variant")
+	attachment, err := h.attachments.VerifyDownload(uint(id), variant, uint(userID), expires, c.QueryParam("sig"))
+	if err != nil {
+		switch err {
+		case services.ErrInvalidDownloadURL:
+			return c.JSON(http.StatusForbidden, map[string]string{"error": err.Error()})
+		case services.ErrAttachmentNotFound:
+			return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+		default:
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to fetch attachment"})
+		}
+	}
+
+	var user models.User
+	if err := h.db.First(&user, userID).Error; err != nil || user.BannedAt != nil {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": services.ErrInvalidDownloadURL.Error()})
+	}
+	if _, err := h.rooms.AuthorizeConnection(c.Request().Context(), attachment.RoomID, &user); err != nil {
+		return roomAccessError(c, err)
+	}
+	if !h.visible(attachment, user.ID) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": services.ErrAttachmentNotFound.Error()})
+	}
+
+	body, contentType, err := h.attachments.Open(c.Request().Context(), attachment, variant)
+	if err != nil {
+		if err == services.ErrAttachmentNotFound {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to fetch attachment"})
+	}
+	defer body.Close()
+
+	header := c.Response().Header()
+	header.Set("Cache-Control", "private, max-age=3600")
+	header.Set("X-Content-Type-Options", "nosniff")
+	disposition := "attachment"
+	if attachment.Kind != models.AttachmentFile {
+		disposition = "inline"
+	}
+	header.Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": attachment.Filename}))
+	if variant == services.AttachmentOriginal {
+		header.Set(echo.HeaderContentLength, strconv.FormatInt(attachment.Size, 10))
+	}
+	c.Response().Header().Set(echo.HeaderContentType, contentType)
+	c.Response().WriteHeader(http.StatusOK)
+	_, err = io.Copy(c.Response(), body)
+	return err
+}
+
+// visible 未发送的附件只有上传者可见，所属消息被删除后附件不再可见
+func (h *AttachmentHandler) visible(attachment *models.Attachment, userID uint) bool {
+	if attachment.MessageID == nil {
+		return attachment.UserID == userID
+	}
+	var message models.Message
+	if err := h.db.Select("id", "deleted_at").First(&message, *attachment.MessageID).Error; err != nil {
+		return false
+	}
+	return message.DeletedAt == nil
+}
@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"LiteAdmin/models"
+	"LiteAdmin/services"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+type AuditHandler struct {
+	audit *services.AuditService
+}
+
+func NewAuditHandler(audit *services.AuditService) *AuditHandler {
+	return &AuditHandler{audit: audit}
+}
+
+// ListAuditLogs 查询审计日志（管理员）
+// GET /admin/audit-logs?actor_id=&action=&target_type=&target_id=&from=&to=&page=&page_size=
+func (h *AuditHandler) ListAuditLogs(c echo.Context) error {
+	filter, ok := parseAuditFilter(c)
+	if !ok {
+		return nil
+	}
+	logs, total, err := h.audit.Query(filter)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"code":    500,
+			"message": "获取审计日志失败",
+			"error":   err.Error(),
+		})
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"code":    200,
+		"message": "success",
+		"data": map[string]interface{}{
+			"items": logs,
+			"total": total,
+		},
+	})
+}
+
+// ExportAuditLogs 按相同的筛选条件导出 CSV（管理员）
+func (h *AuditHandler) ExportAuditLogs(c echo.Context) error {
+	filter, ok := parseAuditFilter(c)
+	if !ok {
+		return nil
+	}
+	resp := c.Response()
+	filename := "audit-logs-" + time.Now().Format("20060102-150405") + ".csv"
+	resp.Header().Set(echo.HeaderContentType, "text/csv; charset=utf-8")
+	resp.Header().Set(echo.HeaderContentDisposition, `attachment; filename="`+filename+`"`)
+	resp.WriteHeader(http.StatusOK)
+
+	w := csv.NewWriter(resp)
+	w.Write([]string{"id", "created_at", "actor_id", "actor_name", "action", "target_type", "target_id", "before", "after", "ip", "user_agent"})
+	err := h.audit.Export(filter, func(logs []models.AuditLog) error {
+		for _, entry := range logs {
+			w.Write([]string{
+				strconv.FormatUint(uint64(entry.ID), 10),
+				entry.CreatedAt.Format(time.RFC3339),
+				strconv.FormatUint(uint64(entry.ActorID), 10),
+				csvSafe(entry.ActorName),
+				entry.Action,
+				entry.TargetType,
+				csvSafe(entry.TargetID),
+				csvSafe(auditJSON(entry.Before)),
+				csvSafe(auditJSON(entry.After)),
+				entry.IP,
+				csvSafe(entry.UserAgent),
+			})
+		}
+		w.Flush()
+		resp.Flush()
+		return w.Error()
+	})
+	w.Flush()
+	if err != nil {
+		// 响应头已发送，只能记录错误
+		c.Logger().Errorf("Audit log export failed: %v", err)
+	}
+	return nil
+}
+
+func parseAuditFilter(c echo.Context) (services.AuditFilter, bool) {
+	filter := services.AuditFilter{
+		Action:     c.QueryParam("action"),
+		TargetType: c.QueryParam("target_type"),
+		TargetID:   c.QueryParam("target_id"),
+	}
+	filter.Page, _ = strconv.Atoi(c.QueryParam("page"))
+	filter.PageSize, _ = strconv.Atoi(c.QueryParam("page_size"))
+	if actor := c.QueryParam("actor_id"); actor != "" {
+		id, err := strconv.ParseUint(actor, 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, map[string]interface{}{
+				"code":    400,
+				"message": "actor_id 参数错误",
+			})
+			return filter, false
+		}
+		filter.ActorID = uint(id)
+	}
+	for name, dst := range map[string]**time.Time{"from": &filter.From, "to": &filter.To} {
+		value := c.QueryParam(name)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, map[string]interface{}{
+				"code":    400,
+				"message": name + " 需要 RFC3339 格式的时间",
+			})
+			return filter, false
+		}
+		*dst = &t
+	}
+	return filter, true
+}
+
+func auditJSON(fields map[string]interface{}) string {
+	if len(fields) == 0 {
+		return ""
+	}
+	data, _ := json.Marshal(fields)
+	return string(data)
+}
+
+// csvSafe 防止 CSV 注入：以公式字符开头的单元格在 Excel 中会被执行
+func csvSafe(value string) string {
+	if value != "" && (value[0] == '=' || value[0] == '+' || value[0] == '-' || value[0] == '@') {
+		return "'" + value
+	}
+	return value
+}
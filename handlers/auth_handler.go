@@ -3,9 +3,9 @@ package handlers
 import (
 	"LiteAdmin/models"
 	"LiteAdmin/services"
-	"fmt"
+	"errors"
 	"net/http"
-	"strings"
+	"strconv"
 	"time"
 
 	"github.com/labstack/echo/v4"
@@ -14,15 +14,27 @@ import (
 type AuthHandler struct {
 	authService  *services.AuthService
 	oauthService *services.OAuthService
+	keyManager   *services.KeyManager
+	audit        *services.AuditService
 }
 
-func NewAuthHandler(authService *services.AuthService, oauthService *services.OAuthService) *AuthHandler {
+func NewAuthHandler(authService *services.AuthService, oauthService *services.OAuthService, keyManager *services.KeyManager, audit *services.AuditService) *AuthHandler {
 	return &AuthHandler{
 		authService:  authService,
 		oauthService: oauthService,
+		keyManager:   keyManager,
+		audit:        audit,
 	}
 }
 
+// JWKS 公开令牌验签公钥，其他服务可据此独立校验 ShopHub 令牌
+func (h *AuthHandler) JWKS(c echo.Context) error {
+	c.Response().Header().Set("Cache-Control", "public, max-age=300")
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"keys": h.keyManager.JWKS(),
+	})
+}
+
 // Get available OAuth providers
 
 func (h *AuthHandler) GetProviders(c echo.Context) error {
@@ -33,20 +45,28 @@ func (h *AuthHandler) GetProviders(c echo.Context) error {
 }
 
 // Start OAuth flow
+// state 与 PKCE verifier 由服务端生成并保存，同时写入 HttpOnly Cookie 绑定当前浏览器
 
 func (h *AuthHandler) OAuthLogin(c echo.Context) error {
 	provider := c.Param("provider")
-	state := c.QueryParam("state") // Should be generated by frontend for CSRF protection
-	userType := c.QueryParam("type")
-	combinedState := fmt.Sprintf("%s|%s", state, userType)
-	authURL, err := h.oauthService.GetAuthURL(provider, combinedState)
+	userType, err := h.oauthService.ResolveSignupType(c.QueryParam("type"))
 	if err != nil {
 		return c.JSON(http.StatusBadRequest, map[string]string{
 			"error": err.Error(),
 		})
 	}
+	authReq, err := h.oauthService.BeginAuth(c.Request().Context(), provider, services.OAuthState{
+		Purpose:  services.OAuthPurposeLogin,
+		UserType: userType,
+	})
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	}
+	h.setOAuthStateCookie(c, authReq.State)
 	return c.JSON(http.StatusOK, map[string]string{
-		"auth_url": authURL,
+		"auth_url": authReq.URL,
 	})
 }
 
@@ -55,10 +75,30 @@ func (h *AuthHandler) OAuthLogin(c echo.Context) error {
 func (h *AuthHandler) OAuthCallback(c echo.Context) error {
 	provider := c.Param("provider")
 	code := c.QueryParam("code")
-	state := c.QueryParam("state")
-	_, userType, _ := strings.Cut(state, "|")
+	stateID := c.QueryParam("state")
+	// 防止登录 CSRF：回调中的 state 必须与发起授权的浏览器 Cookie 一致
+	cookie, err := c.Cookie(oauthStateCookieName)
+	if err != nil || cookie.Value != stateID {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": services.ErrInvalidOAuthState.Error(),
+		})
+	}
+	clearCookie(c, oauthStateCookieName, oauthStateCookiePath)
+	state, err := h.oauthService.ConsumeState(c.Request().Context(), provider, stateID)
+	if err != nil || state.Purpose != services.OAuthPurposeLogin {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": services.ErrInvalidOAuthState.Error(),
+		})
+	}
+	// 注册策略可能在授权期间被修改，这里再校验一次
+	userType, err := h.oauthService.ResolveSignupType(state.UserType)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	}
 	// Exchange code for token
-	token, err := h.oauthService.ExchangeCode(provider, code)
+	token, err := h.oauthService.ExchangeCode(provider, code, state.Verifier)
 	if err != nil {
 		return c.JSON(http.StatusBadRequest, map[string]string{
 			"error": "failed to exchange code",
@@ -66,7 +106,7 @@ func (h *AuthHandler) OAuthCallback(c echo.Context) error {
 	}
 
 	// Get user info
-	userInfo, err := h.oauthService.GetUserInfo(provider, token)
+	userInfo, err := h.oauthService.GetUserInfo(provider, token, state.Nonce)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{
 			"error": "failed to get user info",
@@ -76,10 +116,27 @@ func (h *AuthHandler) OAuthCallback(c echo.Context) error {
 	// Find or create user
 	user, err := h.authService.FindOrCreateOAuthUser(userInfo, userType)
 	if err != nil {
+		if errors.Is(err, services.ErrEmailAlreadyRegistered) {
+			return c.JSON(http.StatusConflict, map[string]string{
+				"error": err.Error(),
+			})
+		}
 		return c.JSON(http.StatusInternalServerError, map[string]string{
 			"error": "failed to create user",
 		})
 	}
+	if user.BannedAt != nil {
+		return c.JSON(http.StatusForbidden, map[string]string{
+			"error": services.ErrUserBanned.Error(),
+		})
+	}
+	h.audit.Record(c.Request().Context(), services.AuditEntry{
+		Actor:      user,
+		Action:     services.AuditOAuthLogin,
+		TargetType: "user",
+		TargetID:   user.ID,
+		After:      map[string]string{"provider": provider},
+	})
 
 	// Generate JWT tokens
 	authResponse, err := h.authService.GenerateTokens(user)
@@ -92,6 +149,118 @@ func (h *AuthHandler) OAuthCallback(c echo.Context) error {
 	return c.JSON(http.StatusOK, authResponse)
 }
 
+// ListIdentities 当前用户已绑定的第三方账号
+func (h *AuthHandler) ListIdentities(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+	identities, err := h.authService.ListIdentities(user.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "failed to fetch identities",
+		})
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"identities": identities,
+	})
+}
+
+// LinkAuthorize 发起绑定第三方账号的授权流程
+func (h *AuthHandler) LinkAuthorize(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+	authReq, err := h.oauthService.BeginAuth(c.Request().Context(), c.Param("provider"), services.OAuthState{
+		Purpose: services.OAuthPurposeLink,
+		UserID:  user.ID,
+	})
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	}
+	return c.JSON(http.StatusOK, map[string]string{
+		"auth_url": authReq.URL,
+	})
+}
+
+// LinkIdentity 登录状态下绑定第三方账号（前端在回调页拿到 code 和 state 后调用）
+func (h *AuthHandler) LinkIdentity(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+	provider := c.Param("provider")
+	var req struct {
+		Code  string `json:"code" validate:"required"`
+		State string `json:"state" validate:"required"`
+	}
+	if err := c.Bind(&req); err != nil || req.Code == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "invalid request",
+		})
+	}
+	// state 必须由当前用户发起的绑定流程生成
+	state, err := h.oauthService.ConsumeState(c.Request().Context(), provider, req.State)
+	if err != nil || state.Purpose != services.OAuthPurposeLink || state.UserID != user.ID {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": services.ErrInvalidOAuthState.Error(),
+		})
+	}
+
+	token, err := h.oauthService.ExchangeCode(provider, req.Code, state.Verifier)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "failed to exchange code",
+		})
+	}
+	userInfo, err := h.oauthService.GetUserInfo(provider, token, state.Nonce)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "failed to get user info",
+		})
+	}
+
+	identity, err := h.authService.LinkIdentity(user.ID, userInfo)
+	if err != nil {
+		if errors.Is(err, services.ErrIdentityLinkedElsewhere) {
+			return c.JSON(http.StatusConflict, map[string]string{
+				"error": err.Error(),
+			})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "failed to link identity",
+		})
+	}
+	h.audit.Record(c.Request().Context(), services.AuditEntry{
+		Action:     services.AuditIdentityLink,
+		TargetType: "user_identity",
+		TargetID:   identity.ID,
+		After:      identity,
+	})
+	return c.JSON(http.StatusOK, identity)
+}
+
+// UnlinkIdentity 解绑第三方账号
+func (h *AuthHandler) UnlinkIdentity(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+	identityID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid identity ID"})
+	}
+	if err := h.authService.UnlinkIdentity(user, uint(identityID)); err != nil {
+		switch {
+		case errors.Is(err, services.ErrIdentityNotFound):
+			return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+		case errors.Is(err, services.ErrLastLoginMethod):
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		default:
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to unlink identity"})
+		}
+	}
+	h.audit.Record(c.Request().Context(), services.AuditEntry{
+		Action:     services.AuditIdentityUnlink,
+		TargetType: "user_identity",
+		TargetID:   uint(identityID),
+	})
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": "identity unlinked",
+	})
+}
+
 // Local registration
 func (h *AuthHandler) Register(c echo.Context) error {
 	var req struct {
@@ -129,6 +298,7 @@ func (h *AuthHandler) Login(c echo.Context) error {
 		Email      string `json:"email" validate:"required,email"`
 		Password   string `json:"password" validate:"required"`
 		RememberMe bool   `json:"rememberMe"`
+		DeviceName string `json:"deviceName"`
 	}
 
 	if err := c.Bind(&req); err != nil {
@@ -139,10 +309,21 @@ func (h *AuthHandler) Login(c echo.Context) error {
 
 	user, err := h.authService.LoginLocal(req.Email, req.Password)
 	if err != nil {
+		h.audit.Record(c.Request().Context(), services.AuditEntry{
+			Action:     services.AuditLoginFailed,
+			TargetType: "user",
+			After:      map[string]string{"email": req.Email, "reason": err.Error()},
+		})
 		return c.JSON(http.StatusUnauthorized, map[string]string{
 			"error": err.Error(),
 		})
 	}
+	h.audit.Record(c.Request().Context(), services.AuditEntry{
+		Actor:      user,
+		Action:     services.AuditLogin,
+		TargetType: "user",
+		TargetID:   user.ID,
+	})
 
 	authResponse, err := h.authService.GenerateTokens(user)
 	if err != nil {
@@ -150,8 +331,17 @@ func (h *AuthHandler) Login(c echo.Context) error {
 			"error": "failed to generate tokens",
 		})
 	}
-	// 处理记住我功能
+	// 旧版本把明文密码写进了 Cookie，这里统一清除
+	clearCookie(c, legacyPasswordCookie, "/")
+	// 处理记住我功能：签发长期设备令牌，只保存在 HttpOnly Cookie 中
 	if req.RememberMe {
+		deviceToken, _, err := h.authService.IssueDeviceToken(user, deviceMeta(c, req.DeviceName))
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{
+				"error": "failed to issue device token",
+			})
+		}
+		h.setDeviceCookie(c, deviceToken)
 		emailCookie := &http.Cookie{
 			Name:     "remembered_email",
 			Value:    req.Email,
@@ -161,45 +351,151 @@ func (h *AuthHandler) Login(c echo.Context) error {
 			Secure:   true,
 			SameSite: http.SameSiteLaxMode,
 		}
-		passwordCookie := &http.Cookie{
-			Name:     "remembered_password",
-			Value:    req.Password,
-			Path:     "/",
-			Expires:  time.Now().Add(3 * 24 * time.Hour), // 3天
-			HttpOnly: false,
-			Secure:   true,
-			SameSite: http.SameSiteLaxMode,
-		}
-		c.SetCookie(passwordCookie)
 		c.SetCookie(emailCookie)
 	} else {
 		// 如果不勾选记住我，清除之前的 Cookie
-		emailCookie := &http.Cookie{
-			Name:     "remembered_email",
-			Value:    "",
-			Path:     "/",
-			Expires:  time.Now().Add(-1 * time.Hour), // 设置过期时间为过去
-			MaxAge:   -1,
-			HttpOnly: false,
-			Secure:   true,
-			SameSite: http.SameSiteLaxMode,
+		clearCookie(c, "remembered_email", "/")
+	}
+	return c.JSON(http.StatusOK, authResponse)
+}
+
+// DeviceLogin 使用记住我设备令牌换取新的访问令牌（设备令牌同时轮换）
+func (h *AuthHandler) DeviceLogin(c echo.Context) error {
+	cookie, err := c.Cookie(deviceCookieName)
+	if err != nil || cookie.Value == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{
+			"error": "missing device token",
+		})
+	}
+
+	user, deviceToken, err := h.authService.ExchangeDeviceToken(cookie.Value, deviceMeta(c, ""))
+	if err != nil {
+		clearCookie(c, deviceCookieName, deviceCookiePath)
+		if errors.Is(err, services.ErrDeviceTokenInvalid) {
+			return c.JSON(http.StatusUnauthorized, map[string]string{
+				"error": err.Error(),
+			})
 		}
-		c.SetCookie(emailCookie)
-		passwordCookie := &http.Cookie{
-			Name:     "remembered_password",
-			Value:    "",
-			Path:     "/",
-			Expires:  time.Now().Add(-1 * time.Hour), // 设置过期时间为过去
-			MaxAge:   -1,
-			HttpOnly: false,
-			Secure:   true,
-			SameSite: http.SameSiteLaxMode,
+		if errors.Is(err, services.ErrUserBanned) {
+			return c.JSON(http.StatusForbidden, map[string]string{
+				"error": err.Error(),
+			})
 		}
-		c.SetCookie(passwordCookie)
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "failed to verify device token",
+		})
+	}
+	h.setDeviceCookie(c, deviceToken)
+	h.audit.Record(c.Request().Context(), services.AuditEntry{
+		Actor:      user,
+		Action:     services.AuditDeviceLogin,
+		TargetType: "user",
+		TargetID:   user.ID,
+	})
+
+	authResponse, err := h.authService.GenerateTokens(user)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "failed to generate tokens",
+		})
 	}
 	return c.JSON(http.StatusOK, authResponse)
 }
 
+// ListDevices 我的设备列表
+func (h *AuthHandler) ListDevices(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+	devices, err := h.authService.ListDevices(user.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "failed to fetch devices",
+		})
+	}
+	if cookie, err := c.Cookie(deviceCookieName); err == nil && cookie.Value != "" {
+		currentHash := services.HashDeviceToken(cookie.Value)
+		for i := range devices {
+			devices[i].Current = devices[i].TokenHash == currentHash
+		}
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"devices": devices,
+	})
+}
+
+// RevokeDevice 吊销我的某个设备
+func (h *AuthHandler) RevokeDevice(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+	deviceID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid device ID"})
+	}
+	if err := h.authService.RevokeDevice(user.ID, uint(deviceID)); err != nil {
+		if errors.Is(err, services.ErrDeviceNotFound) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to revoke device"})
+	}
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": "device revoked",
+	})
+}
+
+const (
+	deviceCookieName     = "device_token"
+	deviceCookiePath     = "/api/v1"
+	legacyPasswordCookie = "remembered_password"
+	oauthStateCookieName = "oauth_state"
+	oauthStateCookiePath = "/api/v1/auth/oauth"
+)
+
+func (h *AuthHandler) setOAuthStateCookie(c echo.Context, state string) {
+	ttl := h.oauthService.StateTTL()
+	c.SetCookie(&http.Cookie{
+		Name:     oauthStateCookieName,
+		Value:    state,
+		Path:     oauthStateCookiePath,
+		Expires:  time.Now().Add(ttl),
+		MaxAge:   int(ttl.Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (h *AuthHandler) setDeviceCookie(c echo.Context, token string) {
+	expiry := h.authService.DeviceTokenExpiry()
+	c.SetCookie(&http.Cookie{
+		Name:     deviceCookieName,
+		Value:    token,
+		Path:     deviceCookiePath,
+		Expires:  time.Now().Add(expiry),
+		MaxAge:   int(expiry.Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+func clearCookie(c echo.Context, name, path string) {
+	c.SetCookie(&http.Cookie{
+		Name:     name,
+		Value:    "",
+		Path:     path,
+		Expires:  time.Now().Add(-1 * time.Hour), // 设置过期时间为过去
+		MaxAge:   -1,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func deviceMeta(c echo.Context, deviceName string) services.DeviceMeta {
+	return services.DeviceMeta{
+		DeviceName: deviceName,
+		UserAgent:  c.Request().UserAgent(),
+		IP:         c.RealIP(),
+	}
+}
+
 // Refresh token
 func (h *AuthHandler) RefreshToken(c echo.Context) error {
 	var req struct {
@@ -212,21 +508,24 @@ func (h *AuthHandler) RefreshToken(c echo.Context) error {
 		})
 	}
 
-	claims, err := h.authService.ValidateToken(req.RefreshToken)
+	user, err := h.authService.AuthenticateToken(req.RefreshToken)
 	if err != nil {
+		if errors.Is(err, services.ErrUserNotFound) {
+			return c.JSON(http.StatusNotFound, map[string]string{
+				"error": "user not found",
+			})
+		}
+		if errors.Is(err, services.ErrUserBanned) {
+			return c.JSON(http.StatusForbidden, map[string]string{
+				"error": err.Error(),
+			})
+		}
 		return c.JSON(http.StatusUnauthorized, map[string]string{
 			"error": "invalid token",
 		})
 	}
 
-	var user models.User
-	if err := h.authService.Db.First(&user, claims.UserID).Error; err != nil {
-		return c.JSON(http.StatusNotFound, map[string]string{
-			"error": "user not found",
-		})
-	}
-
-	authResponse, err := h.authService.GenerateTokens(&user)
+	authResponse, err := h.authService.GenerateTokens(user)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{
 			"error": "failed to generate tokens",
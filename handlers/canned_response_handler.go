@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"LiteAdmin/models"
+	"LiteAdmin/services"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+type CannedResponseHandler struct {
+	canned *services.CannedResponseService
+}
+
+func NewCannedResponseHandler(canned *services.CannedResponseService) *CannedResponseHandler {
+	return &CannedResponseHandler{canned: canned}
+}
+
+// ListCannedResponses 当前客服可用的快捷回复（全局模板和本店铺模板），q 按快捷码或标题前缀过滤
+func (h *CannedResponseHandler) ListCannedResponses(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+	templates, err := h.canned.List(user, c.QueryParam("q"))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "failed to fetch canned responses",
+		})
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"canned_responses": templates,
+		"total":            len(templates),
+	})
+}
+
+// CreateCannedResponse 新建快捷回复，商家账号创建的是本店铺模板
+func (h *CannedResponseHandler) CreateCannedResponse(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+	var req services.CannedResponseInput
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid request"})
+	}
+	template, err := h.canned.Create(c.Request().Context(), user, req)
+	if err != nil {
+		return cannedResponseError(c, err)
+	}
+	return c.JSON(http.StatusCreated, template)
+}
+
+// UpdateCannedResponse 修改快捷回复
+func (h *CannedResponseHandler) UpdateCannedResponse(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return cannedResponseError(c, services.ErrCannedResponseNotFound)
+	}
+	var req services.CannedResponseInput
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid request"})
+	}
+	template, err := h.canned.Update(c.Request().Context(), user, uint(id), req)
+	if err != nil {
+		return cannedResponseError(c, err)
+	}
+	return c.JSON(http.StatusOK, template)
+}
+
+// DeleteCannedResponse 删除快捷回复
+func (h *CannedResponseHandler) DeleteCannedResponse(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return cannedResponseError(c, services.ErrCannedResponseNotFound)
+	}
+	if err := h.canned.Delete(c.Request().Context(), user, uint(id)); err != nil {
+		return cannedResponseError(c, err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func cannedResponseError(c echo.Context, err error) error {
+	switch err {
+	case services.ErrCannedResponseNotFound:
+		return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+	case services.ErrAccessDenied:
+		return c.JSON(http.StatusForbidden, map[string]string{"error": err.Error()})
+	case services.ErrShortcutTaken:
+		return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+	case services.ErrInvalidShortcut, services.ErrInvalidCannedResponse:
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	default:
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to save canned response"})
+	}
+}
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"LiteAdmin/models"
+	"LiteAdmin/services"
 	"net/http"
 	"strconv"
 
@@ -10,11 +11,12 @@ import (
 )
 
 type CategoryServiceHandler struct {
-	db *gorm.DB
+	db    *gorm.DB
+	audit *services.AuditService
 }
 
-func NewCategoryHandler(db *gorm.DB) *CategoryServiceHandler {
-	return &CategoryServiceHandler{db: db}
+func NewCategoryHandler(db *gorm.DB, audit *services.AuditService) *CategoryServiceHandler {
+	return &CategoryServiceHandler{db: db, audit: audit}
 }
 
 // GetCategories 获取所有分类（树形结构）
@@ -124,6 +126,12 @@ func (h *CategoryServiceHandler) CreateCategory(c echo.Context) error {
 			"error":   err.Error(),
 		})
 	}
+	h.audit.Record(c.Request().Context(), services.AuditEntry{
+		Action:     services.AuditCategoryCreate,
+		TargetType: "category",
+		TargetID:   category.ID,
+		After:      category,
+	})
 
 	return c.JSON(http.StatusOK, map[string]interface{}{
 		"code":    200,
@@ -165,6 +173,7 @@ func (h *CategoryServiceHandler) UpdateCategory(c echo.Context) error {
 		})
 	}
 
+	before := category
 	// 更新字段
 	updates := map[string]interface{}{}
 	if req.Name != "" {
@@ -190,6 +199,13 @@ func (h *CategoryServiceHandler) UpdateCategory(c echo.Context) error {
 			"error":   err.Error(),
 		})
 	}
+	h.audit.Record(c.Request().Context(), services.AuditEntry{
+		Action:     services.AuditCategoryUpdate,
+		TargetType: "category",
+		TargetID:   category.ID,
+		Before:     before,
+		After:      category,
+	})
 
 	return c.JSON(http.StatusOK, map[string]interface{}{
 		"code":    200,
@@ -228,13 +244,26 @@ func (h *CategoryServiceHandler) DeleteCategory(c echo.Context) error {
 		})
 	}
 
-	if err := h.db.Delete(&models.PetCategory{}, id).Error; err != nil {
+	var category models.PetCategory
+	if err := h.db.First(&category, id).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]interface{}{
+			"code":    404,
+			"message": "分类不存在",
+		})
+	}
+	if err := h.db.Delete(&category).Error; err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
 			"code":    500,
 			"message": "删除失败",
 			"error":   err.Error(),
 		})
 	}
+	h.audit.Record(c.Request().Context(), services.AuditEntry{
+		Action:     services.AuditCategoryDelete,
+		TargetType: "category",
+		TargetID:   category.ID,
+		Before:     category,
+	})
 
 	return c.JSON(http.StatusOK, map[string]interface{}{
 		"code":    200,
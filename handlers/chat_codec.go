@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/vmihailenco/msgpack/v4"
+)
+
+// Codec WebSocket 帧的编码格式，通过 Sec-WebSocket-Protocol 子协议协商
+type Codec interface {
+	Subprotocol() string // 对应的子协议名
+	FrameType() int      // websocket.TextMessage 或 websocket.BinaryMessage
+	Encode(event *ServerEvent) ([]byte, error)
+	Decode(data []byte, frame *ClientFrame) error
+}
+
+// chatProtocolV1MsgPack v1 协议的 MessagePack 编码，字段与 JSON 相同
+const chatProtocolV1MsgPack = "chat.v1.msgpack"
+
+var (
+	jsonChatCodec    Codec = jsonCodec{}
+	msgpackChatCodec Codec = msgpackCodec{}
+
+	// chatCodecs 按服务端优先级排列：客户端同时支持时优先使用 MessagePack
+	chatCodecs = []Codec{msgpackChatCodec, jsonChatCodec}
+)
+
+// codecFor 按协商结果选择编码，客户端未声明子协议时使用 JSON
+func codecFor(subprotocol string) Codec {
+	for _, codec := range chatCodecs {
+		if codec.Subprotocol() == subprotocol {
+			return codec
+		}
+	}
+	return jsonChatCodec
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Subprotocol() string { return chatProtocolV1 }
+func (jsonCodec) FrameType() int      { return websocket.TextMessage }
+
+func (jsonCodec) Encode(event *ServerEvent) ([]byte, error) {
+	return json.Marshal(event)
+}
+
+func (jsonCodec) Decode(data []byte, frame *ClientFrame) error {
+	return json.Unmarshal(data, frame)
+}
+
+// msgpackCodec 直接按 json 标签编码事件结构，字段名和取值与 JSON 一致：时间为 RFC 3339 字符串，
+// json.RawMessage（回放的 payload、客户端帧的 payload）与 MessagePack 互相转换，整数不经过 float64
+type msgpackCodec struct{}
+
+func (msgpackCodec) Subprotocol() string { return chatProtocolV1MsgPack }
+func (msgpackCodec) FrameType() int      { return websocket.BinaryMessage }
+
+func (msgpackCodec) Encode(event *ServerEvent) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := msgpack.NewEncoder(&buf).UseJSONTag(true).UseCompactEncoding(true).Encode(event); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (msgpackCodec) Decode(data []byte, frame *ClientFrame) error {
+	r := bytes.NewReader(data)
+	if err := msgpack.NewDecoder(r).UseJSONTag(true).Decode(frame); err != nil {
+		return err
+	}
+	if r.Len() != 0 {
+		return errors.New("msgpack: trailing data")
+	}
+	return nil
+}
+
+// 编解码函数注册在 msgpack 的全局表中，对进程内所有 MessagePack 编解码生效
+func init() {
+	msgpack.Register(time.Time{}, encodeMsgpackTime, nil)
+	msgpack.Register(json.RawMessage(nil), encodeMsgpackRawJSON, decodeMsgpackRawJSON)
+	msgpack.Register(json.Number(""), encodeMsgpackNumber, nil)
+}
+
+func encodeMsgpackTime(e *msgpack.Encoder, v reflect.Value) error {
+	return e.EncodeString(v.Interface().(time.Time).Format(time.RFC3339Nano))
+}
+
+// encodeMsgpackRawJSON 按 JSON 解析后编码，数字保留为 json.Number
+func encodeMsgpackRawJSON(e *msgpack.Encoder, v reflect.Value) error {
+	if v.Len() == 0 {
+		return e.EncodeNil()
+	}
+	decoder := json.NewDecoder(bytes.NewReader(v.Bytes()))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return err
+	}
+	return e.Encode(value)
+}
+
+// decodeMsgpackRawJSON 解码任意值后转换为 JSON；bin 按 base64 字符串处理
+func decodeMsgpackRawJSON(d *msgpack.Decoder, v reflect.Value) error {
+	value, err := d.DecodeInterface()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	v.SetBytes(data)
+	return nil
+}
+
+// encodeMsgpackNumber 整数使用能容纳的最短编码，超出 int64/uint64 范围或带小数的按 float64 编码
+func encodeMsgpackNumber(e *msgpack.Encoder, v reflect.Value) error {
+	n := v.String()
+	if i, err := strconv.ParseInt(n, 10, 64); err == nil {
+		return e.EncodeInt(i)
+	}
+	if u, err := strconv.ParseUint(n, 10, 64); err == nil {
+		return e.EncodeUint(u)
+	}
+	f, err := strconv.ParseFloat(n, 64)
+	if err != nil {
+		return err
+	}
+	return e.EncodeFloat64(f)
+}
+
+// preparedFrame 一种编码下预先生成的帧；PreparedMessage 内部再按是否压缩缓存帧数据，
+// 同一事件发给成千上万个连接时，序列化和压缩都只做一次
+type preparedFrame struct {
+	size     int
+	prepared *websocket.PreparedMessage
+}
+
+// prepare 按编码格式生成帧并缓存在事件上，广播事件由多个连接共享
+func (e *ServerEvent) prepare(codec Codec) (*preparedFrame, error) {
+	e.encodeMu.Lock()
+	defer e.encodeMu.Unlock()
+	if frame, ok := e.frames[codec.Subprotocol()]; ok {
+		return frame, nil
+	}
+	data, err := codec.Encode(e)
+	if err != nil {
+		return nil, err
+	}
+	prepared, err := websocket.NewPreparedMessage(codec.FrameType(), data)
+	if err != nil {
+		return nil, err
+	}
+	if e.frames == nil {
+		e.frames = make(map[string]*preparedFrame, len(chatCodecs))
+	}
+	frame := &preparedFrame{size: len(data), prepared: prepared}
+	e.frames[codec.Subprotocol()] = frame
+	return frame, nil
+}
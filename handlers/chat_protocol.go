@@ -0,0 +1,430 @@
+package handlers
+
+import (
+	"LiteAdmin/models"
+	liteRedis "LiteAdmin/redis"
+	"LiteAdmin/services"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 聊天 WebSocket 协议：入站和出站事件都使用 {"type", "id", "payload"} 信封。
+// 客户端在入站事件中带上自己生成的 id 时，服务端处理后回复同一 id 的 ack 或 error，
+// 用于乐观更新界面；没有 id 的事件只在失败时回复 error。
+
+const (
+	// chatProtocolV1 通过 Sec-WebSocket-Protocol 协商，客户端不声明子协议时同样按 v1（JSON）处理
+	chatProtocolV1      = "chat.v1"
+	chatProtocolVersion = 1
+
+	maxFrameSize         = 64 << 10 // 单个入站帧的最大字节数，超过时连接以 1009 关闭
+	maxClientMessageID   = 64       // 客户端消息 ID 的最大长度
+	maxTemplateVariables = 20       // send_template 最多携带的变量数
+)
+
+// supportedChatProtocols 服务端支持的子协议，按优先级排列，与 chatCodecs 一致
+var supportedChatProtocols = []string{chatProtocolV1MsgPack, chatProtocolV1}
+
+// 入站事件类型
+const (
+	RequestMessage        = "message"
+	RequestTyping         = "typing"
+	RequestEditMessage    = "edit_message"
+	RequestDeleteMessage  = "delete_message"
+	RequestAddReaction    = "add_reaction"
+	RequestRemoveReaction = "remove_reaction"
+	RequestResume         = "resume"
+	RequestMarkRead       = "mark_read"
+	RequestModeration     = "moderation"
+	RequestSendTemplate   = "send_template" // 客服发送快捷回复模板，服务端展开后按普通消息发送
+)
+
+// 出站事件类型
+const (
+	EventInit            = "init"
+	EventAck             = "ack"
+	EventError           = "error"
+	EventMessage         = "message"
+	EventThreadReply     = "thread_reply"
+	EventMessageEdited   = "message_edited"
+	EventMessageDeleted  = "message_deleted"
+	EventReactionUpdated = "reaction_updated"
+	EventReadReceipt     = "read_receipt"
+	EventTyping          = "typing"
+	EventUserJoined      = "user_joined"
+	EventUserLeft        = "user_left"
+	EventResumed         = "resumed"
+	EventQueueUpdate     = "queue_update" // 客服会话排队位置/接入客服，payload 为 services.QueueStatus
+)
+
+// 错误码
+const (
+	ErrCodeInvalidFrame   = "invalid_frame"   // 帧无法解码或缺少 type
+	ErrCodeInvalidPayload = "invalid_payload" // payload 字段类型或取值不合法
+	ErrCodeUnknownType    = "unknown_type"
+	ErrCodeForbidden      = "forbidden"
+	ErrCodeNotFound       = "not_found"
+	ErrCodeConflict       = "conflict" // 消息已删除、附件已发送等状态冲突
+	ErrCodeMuted          = "muted"
+	ErrCodeSlowMode       = "slow_mode"
+	ErrCodeInternal       = "internal_error"
+)
+
+// ClientFrame 客户端发送的事件
+type ClientFrame struct {
+	Type    string          `json:"type"`
+	ID      string          `json:"id,omitempty"` // 客户端生成的消息 ID
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// ServerEvent 服务端发送的事件；广播事件带房间序号 seq，断线重连时据此 resume
+type ServerEvent struct {
+	Type     string      `json:"type"`
+	ID       string      `json:"id,omitempty"` // ack/error 对应的客户端消息 ID
+	Seq      int64       `json:"seq,omitempty"`
+	Replayed bool        `json:"replayed,omitempty"` // resume 补发的事件
+	Payload  interface{} `json:"payload,omitempty"`
+
+	encodeMu sync.Mutex                // 保护 frames
+	frames   map[string]*preparedFrame // 按子协议缓存的帧
+}
+
+// chatError 回复给客户端的错误
+type chatError struct {
+	Code       string
+	Message    string
+	RetryAfter int // 慢速模式下需要等待的秒数
+}
+
+func (e *chatError) Error() string {
+	return e.Message
+}
+
+func newChatError(code, message string) *chatError {
+	return &chatError{Code: code, Message: message}
+}
+
+func invalidPayload(message string) *chatError {
+	return newChatError(ErrCodeInvalidPayload, message)
+}
+
+// toChatError 将服务层错误映射为错误码，未知错误记录日志后只返回通用错误
+func toChatError(requestType string, err error) *chatError {
+	if e, ok := err.(*chatError); ok {
+		return e
+	}
+	if errors.Is(err, services.ErrMissingVariables) {
+		return invalidPayload(err.Error())
+	}
+	switch err {
+	case services.ErrMessageNotFound, services.ErrAttachmentNotFound, services.ErrRestrictionNotFound,
+		services.ErrUserNotFound, services.ErrCannedResponseNotFound:
+		return newChatError(ErrCodeNotFound, err.Error())
+	case services.ErrMessageDeleted, services.ErrAttachmentInUse:
+		return newChatError(ErrCodeConflict, err.Error())
+	case services.ErrNotMessageAuthor, services.ErrAccessDenied, services.ErrNotRoomMember,
+		services.ErrCannotManageOwner, services.ErrCannotModerateSelf, services.ErrNotCustomerService:
+		return newChatError(ErrCodeForbidden, err.Error())
+	case services.ErrInvalidContent, services.ErrInvalidReaction, services.ErrInvalidSlowMode:
+		return invalidPayload(err.Error())
+	case services.ErrMutedInRoom:
+		return newChatError(ErrCodeMuted, err.Error())
+	default:
+		log.Printf("Chat %s failed: %v", requestType, err)
+		return newChatError(ErrCodeInternal, "failed to process request")
+	}
+}
+
+// chatRequest 入站事件的 payload，解码后先校验再交给处理函数
+type chatRequest interface {
+	validate() error
+}
+
+// newChatRequest 按事件类型创建 payload 结构，未知类型返回 nil
+func newChatRequest(requestType string) chatRequest {
+	switch requestType {
+	case RequestMessage:
+		return &SendMessageRequest{}
+	case RequestTyping:
+		return &TypingRequest{}
+	case RequestEditMessage:
+		return &EditMessageRequest{}
+	case RequestDeleteMessage:
+		return &DeleteMessageRequest{}
+	case RequestAddReaction, RequestRemoveReaction:
+		return &ReactionRequest{}
+	case RequestResume:
+		return &ResumeRequest{}
+	case RequestMarkRead:
+		return &MarkReadRequest{}
+	case RequestModeration:
+		return &ModerationRequest{}
+	case RequestSendTemplate:
+		return &SendTemplateRequest{}
+	}
+	return nil
+}
+
+// decodeChatRequest 解码并校验 payload，缺少 payload 时按空对象处理
+func decodeChatRequest(raw json.RawMessage, req chatRequest) error {
+	if len(raw) == 0 || string(raw) == "null" {
+		raw = json.RawMessage("{}")
+	}
+	if err := json.Unmarshal(raw, req); err != nil {
+		if typeErr, ok := err.(*json.UnmarshalTypeError); ok && typeErr.Field != "" {
+			return invalidPayload(fmt.Sprintf("invalid %s", typeErr.Field))
+		}
+		return invalidPayload("payload must be a JSON object")
+	}
+	return req.validate()
+}
+
+// SendMessageRequest 发送消息；parent_id 不为空时作为话题回复，发送附件时 content 可以为空
+type SendMessageRequest struct {
+	Content      string `json:"content"`
+	ParentID     uint   `json:"parent_id,omitempty"`
+	AttachmentID uint   `json:"attachment_id,omitempty"`
+}
+
+func (r *SendMessageRequest) validate() error {
+	if strings.TrimSpace(r.Content) == "" && r.AttachmentID == 0 {
+		return invalidPayload("content or attachment_id is required")
+	}
+	return nil
+}
+
+// TypingRequest 正在输入状态
+type TypingRequest struct {
+	IsTyping *bool `json:"is_typing"`
+}
+
+func (r *TypingRequest) validate() error {
+	if r.IsTyping == nil {
+		return invalidPayload("is_typing is required")
+	}
+	return nil
+}
+
+// EditMessageRequest 编辑消息（仅作者）
+type EditMessageRequest struct {
+	MessageID uint   `json:"message_id"`
+	Content   string `json:"content"`
+}
+
+func (r *EditMessageRequest) validate() error {
+	return requireMessageID(r.MessageID)
+}
+
+// DeleteMessageRequest 删除消息（作者或房主、版主）
+type DeleteMessageRequest struct {
+	MessageID uint `json:"message_id"`
+}
+
+func (r *DeleteMessageRequest) validate() error {
+	return requireMessageID(r.MessageID)
+}
+
+// ReactionRequest 添加或取消表情回应
+type ReactionRequest struct {
+	MessageID uint   `json:"message_id"`
+	Emoji     string `json:"emoji"`
+}
+
+func (r *ReactionRequest) validate() error {
+	return requireMessageID(r.MessageID)
+}
+
+// ResumeRequest 断线重连后补发 last_seq 之后的事件
+type ResumeRequest struct {
+	LastSeq *int64 `json:"last_seq"`
+}
+
+func (r *ResumeRequest) validate() error {
+	if r.LastSeq == nil || *r.LastSeq < 0 {
+		return invalidPayload("invalid last_seq")
+	}
+	return nil
+}
+
+// MarkReadRequest 标记已读到指定消息
+type MarkReadRequest struct {
+	MessageID uint `json:"message_id"`
+}
+
+func (r *MarkReadRequest) validate() error {
+	return requireMessageID(r.MessageID)
+}
+
+// 管理指令
+const (
+	ModerationKick     = "kick"
+	ModerationBan      = "ban"
+	ModerationUnban    = "unban"
+	ModerationMute     = "mute"
+	ModerationUnmute   = "unmute"
+	ModerationSlowMode = "slow_mode"
+)
+
+// ModerationRequest 管理指令（房主或版主）；duration 为封禁、禁言的秒数（0 表示永久），slow_mode 使用 seconds
+type ModerationRequest struct {
+	Action   string `json:"action"`
+	UserID   uint   `json:"user_id"`
+	Duration int64  `json:"duration"`
+	Seconds  int    `json:"seconds"`
+	Reason   string `json:"reason"`
+}
+
+func (r *ModerationRequest) validate() error {
+	switch r.Action {
+	case ModerationKick, ModerationBan, ModerationUnban, ModerationMute, ModerationUnmute:
+		if r.UserID == 0 {
+			return invalidPayload("invalid user ID")
+		}
+	case ModerationSlowMode:
+	default:
+		return invalidPayload("unknown moderation action")
+	}
+	if r.Duration < 0 {
+		return invalidPayload("invalid duration")
+	}
+	return nil
+}
+
+// SendTemplateRequest 发送快捷回复，template_id 和 shortcut 二选一；variables 提供服务端无法填充的变量（如 pet_name）
+type SendTemplateRequest struct {
+	TemplateID uint              `json:"template_id,omitempty"`
+	Shortcut   string            `json:"shortcut,omitempty"`
+	Variables  map[string]string `json:"variables,omitempty"`
+	ParentID   uint              `json:"parent_id,omitempty"`
+}
+
+func (r *SendTemplateRequest) validate() error {
+	if r.TemplateID == 0 && strings.TrimSpace(r.Shortcut) == "" {
+		return invalidPayload("template_id or shortcut is required")
+	}
+	if len(r.Variables) > maxTemplateVariables {
+		return invalidPayload(fmt.Sprintf("at most %d variables are allowed", maxTemplateVariables))
+	}
+	return nil
+}
+
+func requireMessageID(id uint) error {
+	if id == 0 {
+		return invalidPayload("invalid message ID")
+	}
+	return nil
+}
+
+// AckPayload 处理成功的回复；发送消息时带上新消息的 ID 和创建时间，客户端据此替换乐观显示的临时消息
+type AckPayload struct {
+	MessageID uint       `json:"message_id,omitempty"`
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+}
+
+// ErrorPayload 处理失败的回复
+type ErrorPayload struct {
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+	RetryAfter int    `json:"retry_after,omitempty"`
+}
+
+// InitPayload 连接建立后发送的初始化数据
+type InitPayload struct {
+	ProtocolVersion int                  `json:"protocol_version"`
+	Users           []liteRedis.UserInfo `json:"users"`
+	Seq             int64                `json:"seq"` // 重连时作为 resume 的 last_seq 起点
+}
+
+// MessagePayload 新消息；附件只带元数据，下载链接按用户签名，客户端通过附件接口获取
+type MessagePayload struct {
+	ID          uint                   `json:"id"`
+	RoomID      string                 `json:"room_id"`
+	UserID      uint                   `json:"user_id"`
+	Username    string                 `json:"username"`
+	UserColor   string                 `json:"user_color"`
+	Content     string                 `json:"content"`
+	Type        string                 `json:"type"`
+	CreatedAt   time.Time              `json:"created_at"`
+	ParentID    *uint                  `json:"parent_id"`
+	ReplyTo     *services.MessageQuote `json:"reply_to,omitempty"`
+	Attachment  *models.Attachment     `json:"attachment,omitempty"`
+	ClientMsgID string                 `json:"client_msg_id,omitempty"` // 发送者的客户端消息 ID，用于和乐观显示的消息去重
+}
+
+// SystemMessagePayload 系统消息（用户加入/离开、管理操作通知），ID 为临时 UUID，不保存到数据库
+type SystemMessagePayload struct {
+	ID        string    `json:"id"`
+	RoomID    string    `json:"room_id"`
+	Type      string    `json:"type"`
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ThreadReplyPayload 话题有新回复，只发给话题参与者
+type ThreadReplyPayload struct {
+	ParentID  uint      `json:"parent_id"`
+	MessageID uint      `json:"message_id"`
+	RoomID    string    `json:"room_id"`
+	UserID    uint      `json:"user_id"`
+	Username  string    `json:"username"`
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type MessageEditedPayload struct {
+	ID       uint       `json:"id"`
+	RoomID   string     `json:"room_id"`
+	Content  string     `json:"content"`
+	EditedAt *time.Time `json:"edited_at"`
+}
+
+type MessageDeletedPayload struct {
+	ID        uint       `json:"id"`
+	RoomID    string     `json:"room_id"`
+	DeletedAt *time.Time `json:"deleted_at"`
+	DeletedBy uint       `json:"deleted_by"`
+}
+
+// ReactionUpdatedPayload 表情回应变化，count 为该表情最新的回应人数
+type ReactionUpdatedPayload struct {
+	MessageID uint   `json:"message_id"`
+	RoomID    string `json:"room_id"`
+	Emoji     string `json:"emoji"`
+	Count     int64  `json:"count"`
+	UserID    uint   `json:"user_id"`
+	Action    string `json:"action"` // added / removed
+}
+
+type ReadReceiptPayload struct {
+	RoomID    string    `json:"room_id"`
+	UserID    uint      `json:"user_id"`
+	Username  string    `json:"username"`
+	MessageID uint      `json:"message_id"`
+	ReadAt    time.Time `json:"read_at"`
+}
+
+type TypingPayload struct {
+	UserID   uint   `json:"user_id"`
+	Username string `json:"username"`
+	IsTyping bool   `json:"is_typing"`
+}
+
+// PresencePayload 用户加入/离开
+type PresencePayload struct {
+	UserID            uint   `json:"user_id"`
+	Username          string `json:"username"`
+	Color             string `json:"color,omitempty"`
+	SystemMessageSent bool   `json:"system_message_sent"`
+}
+
+// ResumedPayload 补发结束；complete 为 false 表示缓冲中已经缺少部分事件，客户端应重新加载历史消息
+type ResumedPayload struct {
+	From     int64 `json:"from"`
+	To       int64 `json:"to"`
+	Complete bool  `json:"complete"`
+}
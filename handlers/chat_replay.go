@@ -0,0 +1,319 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	replayWindow      = 5 * time.Minute    // 断线后可以补发的时间窗口
+	replayMaxLen      = 1000               // 每个房间最多保留的事件数（近似）
+	replayMaxEvents   = 200                // 一次 resume 最多补发的事件数，小于发送队列容量
+	replayQueueSize   = 1024               // 等待写入回放流的事件数，写满时丢弃（resume 会发现缺口）
+	replayBatchSize   = 128                // 一次 pipeline 最多写入的事件数
+	roomSeqBlock      = 1 << 20            // 每次向 Redis 预留的序号数
+	roomSeqTTL        = 7 * 24 * time.Hour // 序号在房间无活动一段时间后才过期
+	seqRetryInterval  = 10 * time.Second   // 预留序号失败后的重试间隔
+	sequenceTimeout   = 500 * time.Millisecond
+	replayReadTimeout = 2 * time.Second
+)
+
+func roomSeqKey(roomID string) string {
+	return fmt.Sprintf("chat:room:%s:seq", roomID)
+}
+
+// roomStreamKey 每段序号对应一个回放流，只有预留该段的房间实例写入，流 ID 为 <seq>-0
+func roomStreamKey(roomID string, blockStart int64) string {
+	return fmt.Sprintf("chat:room:%s:events:%d", roomID, blockStart)
+}
+
+// sequence 在房间循环中为广播事件分配序号并交给回放写入 goroutine，不访问 Redis；
+// 序号从预留的号段中按内存计数分配，号段用完或 Redis 不可用时事件照常广播但没有序号，客户端断线重连后需要重新加载历史
+func (room *ChatRoom) sequence(message *BroadcastMessage) {
+	if room.replay == nil {
+		return
+	}
+	if room.seqNext > room.seqEnd {
+		room.requestSeqBlock()
+		return
+	}
+	seq := room.seqNext
+	room.seqNext++
+	if seq == room.blockStart {
+		room.seqStart.Store(seq)
+	}
+	room.lastSeq.Store(seq)
+	message.Event.Seq = seq
+
+	select {
+	case room.replay.entries <- replayEntry{stream: roomStreamKey(room.ID, room.blockStart), seq: seq, message: message}:
+	default:
+		log.Printf("Replay queue of room %s is full, dropping event %d", room.ID, seq)
+	}
+	if room.seqNext > room.seqEnd {
+		room.requestSeqBlock()
+	}
+}
+
+// requestSeqBlock 号段用完或预留失败后在后台重新预留，结果通过 seqBlocks 交回房间循环
+func (room *ChatRoom) requestSeqBlock() {
+	if room.seqPending || time.Now().Before(room.seqRetryAt) {
+		return
+	}
+	room.seqPending = true
+	go func() {
+		end := room.reserveSeqBlock()
+		select {
+		case room.seqBlocks <- end:
+		case <-room.ctx.Done():
+		}
+	}()
+}
+
+// reserveSeqBlock 向 Redis 预留 roomSeqBlock 个序号，返回号段末尾序号，失败时返回 0
+func (room *ChatRoom) reserveSeqBlock() int64 {
+	ctx, cancel := context.WithTimeout(room.ctx, sequenceTimeout)
+	defer cancel()
+	var end *redis.IntCmd
+	_, err := room.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		end = pipe.IncrBy(ctx, roomSeqKey(room.ID), roomSeqBlock)
+		pipe.Expire(ctx, roomSeqKey(room.ID), roomSeqTTL)
+		return nil
+	})
+	if err != nil {
+		log.Printf("Failed to reserve sequence block for room %s: %v", room.ID, err)
+		return 0
+	}
+	return end.Val()
+}
+
+// adoptSeqBlock 在房间循环中启用预留到的号段，end 为 0 表示预留失败
+func (room *ChatRoom) adoptSeqBlock(end int64) {
+	room.seqPending = false
+	if end <= 0 {
+		room.seqRetryAt = time.Now().Add(seqRetryInterval)
+		return
+	}
+	room.blockStart = end - roomSeqBlock + 1
+	room.seqNext, room.seqEnd = room.blockStart, end
+}
+
+// replayEntry 等待写入回放流的事件
+type replayEntry struct {
+	stream  string
+	seq     int64
+	message *BroadcastMessage
+}
+
+// replayWriter 把房间事件批量写入 Redis 回放流，序列化和网络往返都不占用房间循环
+type replayWriter struct {
+	redis   *redis.Client
+	roomID  string
+	entries chan replayEntry
+
+	mu      sync.Mutex
+	written int64         // 已处理（写入或写入失败）的最大序号
+	flushed chan struct{} // 每批处理完后关闭并替换，resume 据此等待
+}
+
+func newReplayWriter(redisClient *redis.Client, roomID string) *replayWriter {
+	return &replayWriter{
+		redis:   redisClient,
+		roomID:  roomID,
+		entries: make(chan replayEntry, replayQueueSize),
+		flushed: make(chan struct{}),
+	}
+}
+
+func (w *replayWriter) run(ctx context.Context) {
+	batch := make([]replayEntry, 0, replayBatchSize)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case entry := <-w.entries:
+			batch = append(batch[:0], entry)
+			// 把已经排队的事件一起写入
+		drain:
+			for len(batch) < replayBatchSize {
+				select {
+				case entry := <-w.entries:
+					batch = append(batch, entry)
+				default:
+					break drain
+				}
+			}
+			w.flush(ctx, batch)
+		}
+	}
+}
+
+// flush 用一个 pipeline 写入一批事件，并刷新回放流和序号的过期时间
+func (w *replayWriter) flush(ctx context.Context, batch []replayEntry) {
+	ctx, cancel := context.WithTimeout(ctx, sequenceTimeout)
+	defer cancel()
+	pipe := w.redis.Pipeline()
+	for _, entry := range batch {
+		data, err := json.Marshal(entry.message.Event)
+		if err != nil {
+			log.Printf("Failed to marshal event for replay: %v", err)
+			continue
+		}
+		pipe.XAdd(ctx, &redis.XAddArgs{
+			Stream: entry.stream,
+			MaxLen: replayMaxLen,
+			Approx: true,
+			ID:     fmt.Sprintf("%d-0", entry.seq),
+			Values: []interface{}{"data", data, "users", replayUsers(entry.message.UserIDs)},
+		})
+	}
+	last := batch[len(batch)-1]
+	pipe.PExpire(ctx, last.stream, replayWindow)
+	pipe.Expire(ctx, roomSeqKey(w.roomID), roomSeqTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("Failed to append %d events to replay buffer of room %s: %v", len(batch), w.roomID, err)
+	}
+
+	w.mu.Lock()
+	w.written = last.seq
+	close(w.flushed)
+	w.flushed = make(chan struct{})
+	w.mu.Unlock()
+}
+
+// wait 等待 seq 之前的事件处理完毕
+func (w *replayWriter) wait(ctx context.Context, seq int64) bool {
+	for {
+		w.mu.Lock()
+		written, flushed := w.written, w.flushed
+		w.mu.Unlock()
+		if written >= seq {
+			return true
+		}
+		select {
+		case <-flushed:
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// replayUsers 只发给部分用户的事件记录接收者，空字符串表示所有人
+func replayUsers(userIDs map[uint]bool) string {
+	if userIDs == nil {
+		return ""
+	}
+	ids := make([]string, 0, len(userIDs))
+	for id := range userIDs {
+		ids = append(ids, strconv.FormatUint(uint64(id), 10))
+	}
+	return strings.Join(ids, ",")
+}
+
+// currentSeq 房间当前的最大序号
+func (room *ChatRoom) currentSeq() int64 {
+	return room.lastSeq.Load()
+}
+
+// handleResume 断线重连后补发 last_seq 之后的事件，补发结束后回复 resumed（id 与请求相同）。
+// 只能补发当前号段内的事件：last_seq 属于其他节点、节点重启前或更早的号段时返回 complete=false
+func (h *ChatWebSocketHandler) handleResume(client *ChatClient, req *ResumeRequest, requestID string) error {
+	lastSeq := *req.LastSeq
+	room := client.Room
+
+	ctx, cancel := context.WithTimeout(client.ctx, replayReadTimeout)
+	defer cancel()
+	blockStart, current := room.seqStart.Load(), room.currentSeq()
+	complete := lastSeq <= current
+	replayed := lastSeq
+
+	if room.replay != nil && lastSeq < current {
+		if lastSeq < blockStart-1 {
+			complete = false
+		} else if !room.replay.wait(ctx, current) {
+			log.Printf("Timed out waiting for replay buffer of room %s", room.ID)
+			complete = false
+		} else if entries, err := room.redis.XRangeN(ctx, roomStreamKey(room.ID, blockStart),
+			fmt.Sprintf("%d-0", lastSeq+1), "+", replayMaxEvents).Result(); err != nil {
+			log.Printf("Failed to read replay buffer of room %s: %v", room.ID, err)
+			complete = false
+		} else {
+			for _, entry := range entries {
+				seq := entrySeq(entry.ID)
+				// 号段内序号连续，出现缺口说明事件已过期或写入失败
+				if seq != replayed+1 {
+					complete = false
+					break
+				}
+				if event, ok := replayEvent(entry, client.UserID, seq); ok {
+					select {
+					case client.Send <- event:
+					case <-client.ctx.Done():
+						return nil
+					}
+				}
+				replayed = seq
+			}
+			if replayed < current {
+				complete = false
+			}
+		}
+	}
+
+	select {
+	case client.Send <- &ServerEvent{
+		Type: EventResumed,
+		ID:   requestID,
+		Payload: &ResumedPayload{
+			From:     lastSeq,
+			To:       replayed,
+			Complete: complete,
+		},
+	}:
+	case <-client.ctx.Done():
+	}
+	return nil
+}
+
+// replayEvent 还原回放流中的事件；只发给部分用户的事件对其他用户跳过
+func replayEvent(entry redis.XMessage, userID uint, seq int64) (*ServerEvent, bool) {
+	if users, _ := entry.Values["users"].(string); users != "" {
+		allowed := false
+		for _, id := range strings.Split(users, ",") {
+			if id == strconv.FormatUint(uint64(userID), 10) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return nil, false
+		}
+	}
+	raw, _ := entry.Values["data"].(string)
+	var stored struct {
+		Type    string          `json:"type"`
+		Payload json.RawMessage `json:"payload"`
+	}
+	if err := json.Unmarshal([]byte(raw), &stored); err != nil {
+		return nil, false
+	}
+	return &ServerEvent{
+		Type:     stored.Type,
+		Seq:      seq,
+		Replayed: true,
+		Payload:  stored.Payload,
+	}, true
+}
+
+func entrySeq(id string) int64 {
+	seq, _ := strconv.ParseInt(strings.SplitN(id, "-", 2)[0], 10, 64)
+	return seq
+}
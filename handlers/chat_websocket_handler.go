@@ -2,13 +2,19 @@ package handlers
 
 import (
 	"LiteAdmin/models"
+	liteRedis "LiteAdmin/redis"
+	"LiteAdmin/services"
 	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
@@ -22,32 +28,43 @@ var upgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool {
 		return true
 	},
+	Subprotocols: supportedChatProtocols,
+	// 协商 permessage-deflate，是否压缩按帧大小决定（见 writePump）
+	EnableCompression: true,
+	// 写缓冲只在写入时从池中借用，空闲连接不占用写缓冲，单节点上万连接时节省内存
+	WriteBufferPool: &sync.Pool{},
 }
 
+const (
+	compressionThreshold = 512 // 小于该字节数的帧不压缩，压缩收益抵不上 CPU 开销
+	writeWait            = 10 * time.Second
+)
+
 // 消息结构
 type BroadcastMessage struct {
-	Data      map[string]interface{} // 要广播的消息数据
-	ExceptIDs map[string]bool        // 排除的客户端ID（不发送给这些客户端）
-}
-
-// 用户信息结构（用于在线列表）
-type UserInfo struct {
-	UserID   uint   `json:"user_id"`
-	Username string `json:"username"`
-	Color    string `json:"color"`
+	Event     *ServerEvent    // 要广播的事件
+	ExceptIDs map[string]bool // 排除的客户端ID（不发送给这些客户端）
+	UserIDs   map[uint]bool   // 只发送给这些用户（为空时发送给所有人）
 }
 
 // 聊天客户端 代表一个 WebSocket 连接的客户端，包含连接、用户信息和消息通道
 type ChatClient struct {
-	ID       string                      // 客户端唯一标识（UUID）
-	UserID   uint                        // 用户数据库ID
-	Username string                      // 用户名
-	Color    string                      // 用户颜色标识
-	Conn     *websocket.Conn             // WebSocket连接
-	Room     *ChatRoom                   // 所属聊天室
-	Send     chan map[string]interface{} // 发送消息队列（缓冲256条）
-	ctx      context.Context             // 上下文管理
-	cancel   context.CancelFunc          // 取消函数
+	ID       string               // 客户端唯一标识（UUID）
+	UserID   uint                 // 用户数据库ID
+	Username string               // 用户名
+	Color    string               // 用户颜色标识
+	Conn     *websocket.Conn      // WebSocket连接
+	Room     *ChatRoom            // 所属聊天室
+	Send     chan *ServerEvent    // 发送消息队列（缓冲256条）
+	codec    Codec                // 协商得到的帧编码
+	User     *models.User         // 连接时的用户（执行管理指令时作为操作者）
+	access   *services.ChatAccess // 房间角色、禁言状态（受 mu 保护）
+	mu       sync.Mutex           // 保护 access
+	ctx      context.Context      // 上下文管理
+	cancel   context.CancelFunc   // 取消函数
+	evicted  sync.Once            // 发送队列积压时只断开一次
+	init     *ServerEvent         // 初始化数据，注册时由房间循环填入序号后放在发送队列最前面
+	index    int                  // 在房间 members 中的位置，只由房间循环访问
 }
 
 // 管理一个聊天室内的所有连接和消息分发
@@ -55,12 +72,25 @@ type ChatRoom struct {
 	ID         string                 // 房间ID
 	Clients    map[string]*ChatClient // 房间内所有客户端
 	mu         sync.RWMutex           // 读写锁（保护Clients）
+	members    []*ChatClient          // 广播目标列表，只由房间循环读写，广播时无需加锁复制
 	Broadcast  chan *BroadcastMessage // 广播消息通道（缓冲256条）
 	Register   chan *ChatClient       // 客户端注册通道（缓冲16个）
 	Unregister chan *ChatClient       // 客户端注销通道（缓冲16个）
 	ctx        context.Context        // 房间上下文
 	cancel     context.CancelFunc     // 房间关闭函数
 	redis      *redis.Client          // Redis客户端
+	slowMode   atomic.Int32           // 慢速模式间隔（秒），0 表示关闭
+
+	// 广播序号：从 Redis 预留的号段中由房间循环分配，seqStart/lastSeq 供 init 和 resume 读取
+	replay     *replayWriter // 回放流写入，Redis 不可用时为 nil
+	seqBlocks  chan int64    // 后台预留的号段末尾序号
+	seqPending bool          // 正在预留号段
+	seqRetryAt time.Time     // 预留失败后的下次重试时间
+	blockStart int64         // 当前号段的第一个序号
+	seqNext    int64         // 下一个序号
+	seqEnd     int64         // 当前号段的最后一个序号
+	seqStart   atomic.Int64  // 已分配序号所在号段的第一个序号
+	lastSeq    atomic.Int64  // 最后分配的序号
 }
 
 // 房间管理器
@@ -95,169 +125,239 @@ func (m *ChatRoomManager) GetOrCreateRoom(roomID string) *ChatRoom {
 		ctx:        ctx,
 		cancel:     cancel,
 		redis:      m.redis,
+		seqBlocks:  make(chan int64, 1),
+		seqNext:    1,
 	}
 	m.rooms[roomID] = room
 
+	if m.redis != nil {
+		room.replay = newReplayWriter(m.redis, roomID)
+		go room.replay.run(ctx)
+	}
 	go room.run()
 
 	return room
 }
 
+// GetRoom 本节点上已存在的房间，不会创建新房间
+func (m *ChatRoomManager) GetRoom(roomID string) (*ChatRoom, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	room, ok := m.rooms[roomID]
+	return room, ok
+}
+
+// DisconnectUser 断开用户在本节点上的连接，roomID 为空时断开所有房间
+func (m *ChatRoomManager) DisconnectUser(userID uint, roomID, reason string) {
+	for _, client := range m.userClients(userID, roomID) {
+		client.Disconnect(reason)
+	}
+}
+
+// SetMuted 更新用户在房间内所有连接的禁言状态
+func (m *ChatRoomManager) SetMuted(userID uint, roomID string, muted bool, until *time.Time) {
+	for _, client := range m.userClients(userID, roomID) {
+		client.mu.Lock()
+		client.access.Muted = muted
+		client.access.MutedUntil = until
+		client.mu.Unlock()
+	}
+}
+
+// userClients 用户在本节点上的连接，roomID 为空时包括所有房间
+func (m *ChatRoomManager) userClients(userID uint, roomID string) []*ChatClient {
+	m.mu.RLock()
+	rooms := make([]*ChatRoom, 0, len(m.rooms))
+	for id, room := range m.rooms {
+		if roomID == "" || id == roomID {
+			rooms = append(rooms, room)
+		}
+	}
+	m.mu.RUnlock()
+
+	var clients []*ChatClient
+	for _, room := range rooms {
+		room.mu.RLock()
+		for _, client := range room.Clients {
+			if client.UserID == userID {
+				clients = append(clients, client)
+			}
+		}
+		room.mu.RUnlock()
+	}
+	return clients
+}
+
+// Disconnect 发送关闭帧并断开连接，readPump 退出后完成注销
+func (client *ChatClient) Disconnect(reason string) {
+	deadline := time.Now().Add(time.Second)
+	client.Conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, reason), deadline)
+	client.Conn.Close()
+}
+
+// evict 断开发送队列已满的慢速客户端：只关闭连接，不阻塞房间循环，注销由 readPump 退出时完成
+func (client *ChatClient) evict() {
+	client.evicted.Do(func() {
+		log.Printf("Client %s send buffer full, disconnecting", client.ID)
+		client.cancel()
+		go client.Disconnect("send buffer full")
+	})
+}
+
+// reply 向客户端回复一个事件（ack、error 等）；发送队列已满时丢弃，连接随后会因积压被断开
+func (client *ChatClient) reply(event *ServerEvent) {
+	select {
+	case client.Send <- event:
+	default:
+	}
+}
+
+// muted 当前是否处于禁言中（到期后自动解除）
+func (client *ChatClient) muted() bool {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if !client.access.Muted {
+		return false
+	}
+	return client.access.MutedUntil == nil || time.Now().Before(*client.access.MutedUntil)
+}
+
 // 房间的核心消息分发循环
 func (room *ChatRoom) run() {
+	// 房间启动时预留第一段序号，之后的广播只在内存中分配
+	if room.replay != nil {
+		room.adoptSeqBlock(room.reserveSeqBlock())
+	}
 	for {
 		select {
 		case <-room.ctx.Done():
 			return
 
+		case end := <-room.seqBlocks:
+			room.adoptSeqBlock(end)
+
 		case client := <-room.Register:
+			// init 在房间循环中取序号并先于之后的广播进入发送队列，客户端据此衔接后续事件
+			if client.init != nil {
+				client.init.Payload.(*InitPayload).Seq = room.currentSeq()
+				select {
+				case client.Send <- client.init:
+				default:
+					client.evict()
+				}
+				client.init = nil
+			}
 			room.mu.Lock()
 			room.Clients[client.ID] = client
 			room.mu.Unlock()
-
-			// 添加用户到Redis在线列表
-			room.addUserToRedis(client)
+			client.index = len(room.members)
+			room.members = append(room.members, client)
 
 		case client := <-room.Unregister:
 			room.mu.Lock()
-			if _, ok := room.Clients[client.ID]; ok {
+			_, ok := room.Clients[client.ID]
+			if ok {
 				delete(room.Clients, client.ID)
-				close(client.Send)
 			}
 			room.mu.Unlock()
-
-			// 从Redis在线列表移除用户
-			room.removeUserFromRedis(client)
+			if !ok {
+				continue
+			}
+			// 与最后一个交换后删除，O(1)
+			last := room.members[len(room.members)-1]
+			room.members[client.index] = last
+			last.index = client.index
+			room.members[len(room.members)-1] = nil
+			room.members = room.members[:len(room.members)-1]
+			close(client.Send)
 
 		case message := <-room.Broadcast:
-			room.mu.RLock()
-			clients := make([]*ChatClient, 0, len(room.Clients))
-			for _, client := range room.Clients {
-				clients = append(clients, client)
-			}
-			room.mu.RUnlock()
+			// 分配序号并交给回放写入 goroutine，断线的客户端重连后可以补发
+			room.sequence(message)
 
-			for _, client := range clients {
+			// 事件在各连接的 writePump 中按编码格式只序列化一次（ServerEvent.prepare）
+			for _, client := range room.members {
 				if message.ExceptIDs != nil && message.ExceptIDs[client.ID] {
 					continue
 				}
+				if message.UserIDs != nil && !message.UserIDs[client.UserID] {
+					continue
+				}
 
 				select {
-				case client.Send <- message.Data:
+				case client.Send <- message.Event:
 				default:
-					log.Printf("Client %s send buffer full, disconnecting", client.ID)
-					room.Unregister <- client
+					client.evict()
 				}
 			}
 		}
 	}
 }
 
-// 添加用户到Redis在线列表
-func (room *ChatRoom) addUserToRedis(client *ChatClient) {
-	ctx := context.Background()
-	key := fmt.Sprintf("chat:room:%s:online_users", room.ID)
-
-	userInfo := UserInfo{
-		UserID:   client.UserID,
-		Username: client.Username,
-		Color:    client.Color,
-	}
-
-	data, err := json.Marshal(userInfo)
-	if err != nil {
-		log.Printf("Failed to marshal user info: %v", err)
-		return
-	}
-
-	// 使用Hash存储，field为user_id，value为用户信息JSON
-	field := fmt.Sprintf("%d", client.UserID)
-	if err := room.redis.HSet(ctx, key, field, data).Err(); err != nil {
-		log.Printf("Failed to add user to Redis: %v", err)
-		return
-	}
-
-	// 设置过期时间（24小时）
-	room.redis.Expire(ctx, key, 24*time.Hour)
-}
-
-// 从Redis在线列表移除用户
-func (room *ChatRoom) removeUserFromRedis(client *ChatClient) {
-	ctx := context.Background()
-	key := fmt.Sprintf("chat:room:%s:online_users", room.ID)
-
-	// 检查是否还有其他连接使用同一个user_id
-	room.mu.RLock()
-	hasOtherConnection := false
-	for _, c := range room.Clients {
-		if c.UserID == client.UserID && c.ID != client.ID {
-			hasOtherConnection = true
-			break
-		}
-	}
-	room.mu.RUnlock()
-
-	// 只有在没有其他连接时才从Redis删除
-	if !hasOtherConnection {
-		field := fmt.Sprintf("%d", client.UserID)
-		if err := room.redis.HDel(ctx, key, field).Err(); err != nil {
-			log.Printf("Failed to remove user from Redis: %v", err)
-		}
-	}
-}
-
-// 从Redis获取房间当前在线用户列表
-func (room *ChatRoom) GetOnlineUsersFromRedis() ([]UserInfo, error) {
-	ctx := context.Background()
-	key := fmt.Sprintf("chat:room:%s:online_users", room.ID)
-
-	// 获取所有在线用户
-	result, err := room.redis.HGetAll(ctx, key).Result()
-	if err != nil {
-		return nil, err
-	}
-
-	users := make([]UserInfo, 0, len(result))
-	for _, data := range result {
-		var userInfo UserInfo
-		if err := json.Unmarshal([]byte(data), &userInfo); err != nil {
-			log.Printf("Failed to unmarshal user info: %v", err)
-			continue
-		}
-		users = append(users, userInfo)
-	}
-
-	return users, nil
-}
-
 type ChatWebSocketHandler struct {
-	db          *gorm.DB             // 数据库连接
-	redis       *redis.Client        // Redis客户端
-	roomManager *ChatRoomManager     // 房间管理器
-	dbQueue     chan *models.Message // 数据库写入队列（缓冲1000条）
-	dbWorkers   int                  // 数据库工作协程数（4个）
+	db          *gorm.DB                        // 数据库连接
+	redis       *redis.Client                   // Redis客户端
+	rooms       *services.RoomService           // 房间权限校验
+	messages    *services.MessageService        // 消息保存、编辑、删除和表情回应
+	attachments *services.AttachmentService     // 附件元数据和下载链接
+	canned      *services.CannedResponseService // 客服快捷回复模板展开
+	presence    *liteRedis.Presence             // 本节点连接的在线状态
+	roomManager *ChatRoomManager                // 房间管理器
 }
 
-func NewChatWebSocketHandler(db *gorm.DB, redisClient *redis.Client) *ChatWebSocketHandler {
+func NewChatWebSocketHandler(db *gorm.DB, redisClient *redis.Client, rooms *services.RoomService, messages *services.MessageService, attachments *services.AttachmentService, canned *services.CannedResponseService) *ChatWebSocketHandler {
 	h := &ChatWebSocketHandler{
 		db:          db,
 		redis:       redisClient,
+		rooms:       rooms,
+		messages:    messages,
+		attachments: attachments,
+		canned:      canned,
+		presence:    liteRedis.NewPresence(redisClient, nodeID()),
 		roomManager: NewChatRoomManager(redisClient),
-		dbQueue:     make(chan *models.Message, 1000),
-		dbWorkers:   4,
 	}
 
-	for i := 0; i < h.dbWorkers; i++ {
-		go h.dbWorker()
-	}
+	go h.listenControl()
+	go h.presence.Run(context.Background())
 
 	return h
 }
 
-func (h *ChatWebSocketHandler) dbWorker() {
-	for message := range h.dbQueue {
-		if err := h.db.Create(message).Error; err != nil {
-			log.Printf("Failed to save message: %v", err)
+// 订阅控制频道，处理封禁、强制下线等需要断开连接的指令（任意节点发布，所有节点执行）
+func (h *ChatWebSocketHandler) listenControl() {
+	if h.redis == nil {
+		return
+	}
+	pubsub := h.redis.Subscribe(context.Background(), services.ChatControlChannel)
+	defer pubsub.Close()
+	for msg := range pubsub.Channel() {
+		var event services.ChatControlEvent
+		if err := json.Unmarshal([]byte(msg.Payload), &event); err != nil {
+			log.Printf("Invalid chat control event: %v", err)
+			continue
+		}
+		switch event.Action {
+		case services.ChatControlDisconnectUser:
+			h.roomManager.DisconnectUser(event.UserID, event.RoomID, event.Reason)
+		case services.ChatControlMuteUser:
+			h.roomManager.SetMuted(event.UserID, event.RoomID, true, event.Until)
+		case services.ChatControlUnmuteUser:
+			h.roomManager.SetMuted(event.UserID, event.RoomID, false, nil)
+		case services.ChatControlSlowMode:
+			if room, ok := h.roomManager.GetRoom(event.RoomID); ok {
+				room.slowMode.Store(int32(event.Seconds))
+			}
+		case services.ChatControlSystemMessage:
+			if room, ok := h.roomManager.GetRoom(event.RoomID); ok {
+				h.broadcastSystemMessage(room, event.Content)
+			}
+		case services.ChatControlQueueUpdate:
+			if room, ok := h.roomManager.GetRoom(event.RoomID); ok && event.Queue != nil {
+				room.Broadcast <- &BroadcastMessage{
+					Event:   &ServerEvent{Type: EventQueueUpdate, Payload: event.Queue},
+					UserIDs: map[uint]bool{event.UserID: true},
+				}
+			}
 		}
 	}
 }
@@ -266,31 +366,64 @@ func (h *ChatWebSocketHandler) HandleWebSocket(c echo.Context) error {
 	roomID := c.Param("roomId")
 	user := c.Get("user").(*models.User)
 
+	// 升级前校验成员身份和封禁状态，未通过时返回普通 HTTP 错误
+	access, err := h.rooms.AuthorizeConnection(c.Request().Context(), roomID, user)
+	if err != nil {
+		return roomAccessError(c, err)
+	}
+
+	// 客户端声明了子协议但都不支持时拒绝升级，客户端据此降级或提示升级
+	if requested := websocket.Subprotocols(c.Request()); len(requested) > 0 &&
+		!slices.ContainsFunc(requested, func(p string) bool { return slices.Contains(supportedChatProtocols, p) }) {
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"error":     "unsupported protocol version",
+			"supported": supportedChatProtocols,
+		})
+	}
+
 	ws, err := upgrader.Upgrade(c.Response(), c.Request(), nil)
 	if err != nil {
 		return err
 	}
+	ws.SetReadLimit(maxFrameSize)
 
-	ctx, cancel := context.WithCancel(context.Background())
+	// 保留请求 context 中的审计信息，连接期间执行的管理指令据此记录来源
+	ctx, cancel := context.WithCancel(context.WithoutCancel(c.Request().Context()))
 	client := &ChatClient{
 		ID:       uuid.New().String(),
 		UserID:   user.ID,
 		Username: user.Username,
 		Color:    getUserColor(user.ID),
 		Conn:     ws,
-		Send:     make(chan map[string]interface{}, 256),
+		Send:     make(chan *ServerEvent, 256),
+		codec:    codecFor(ws.Subprotocol()),
+		User:     user,
+		access:   access,
 		ctx:      ctx,
 		cancel:   cancel,
 	}
 
+	// 在线状态在注册前写入，初始化数据中的在线列表包含自己
+	if err := h.presence.Add(ctx, liteRedis.Connection{
+		ID:       client.ID,
+		RoomID:   roomID,
+		UserID:   user.ID,
+		Username: user.Username,
+		Device:   clientDevice(c.QueryParam("device")),
+	}); err != nil {
+		log.Printf("Failed to record presence: %v", err)
+	}
+
 	room := h.roomManager.GetOrCreateRoom(roomID)
+	room.slowMode.Store(int32(access.SlowMode))
 	client.Room = room
+	client.init = h.initEvent(client, room)
 
-	// 注册到房间
-	room.Register <- client
+	// 先启动写入goroutine，注册后房间广播随时会写入发送队列
+	go h.writePump(client)
 
-	// 发送初始化数据
-	h.sendInitData(client, room)
+	// 注册到房间，初始化数据由房间循环在注册时放入发送队列
+	room.Register <- client
 
 	// 广播用户加入（通知其他用户）
 	h.broadcastUserJoined(room, client)
@@ -298,9 +431,6 @@ func (h *ChatWebSocketHandler) HandleWebSocket(c echo.Context) error {
 	// 发送系统消息：用户加入
 	h.sendSystemMessage(room, client, "joined")
 
-	// 启动写入goroutine
-	go h.writePump(client)
-
 	// 当前goroutine处理读取
 	h.readPump(client)
 
@@ -313,6 +443,9 @@ func (h *ChatWebSocketHandler) readPump(client *ChatClient) {
 		client.cancel()
 		client.Room.Unregister <- client
 		client.Conn.Close()
+		if err := h.presence.Remove(context.Background(), client.ID); err != nil {
+			log.Printf("Failed to remove presence: %v", err)
+		}
 
 		// 广播用户离开
 		h.broadcastUserLeft(client.Room, client)
@@ -324,20 +457,36 @@ func (h *ChatWebSocketHandler) readPump(client *ChatClient) {
 	client.Conn.SetReadDeadline(time.Now().Add(60 * time.Second))
 	client.Conn.SetPongHandler(func(string) error {
 		client.Conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+		h.presence.Touch(client.ID)
 		return nil
 	})
 
 	for {
-		var msg map[string]interface{}
-		err := client.Conn.ReadJSON(&msg)
+		// 超过 maxFrameSize 的帧会返回错误，连接以 1009 关闭
+		messageType, data, err := client.Conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				log.Printf("WebSocket error: %v", err)
 			}
 			break
 		}
+		h.presence.Touch(client.ID)
+		if messageType != client.codec.FrameType() {
+			client.reply(errorEvent("", newChatError(ErrCodeInvalidFrame, "frame type does not match the negotiated protocol")))
+			continue
+		}
 
-		h.handleMessage(client, msg)
+		// 格式错误的帧回复错误后继续读取，不断开连接
+		var frame ClientFrame
+		if err := client.codec.Decode(data, &frame); err != nil || frame.Type == "" {
+			client.reply(errorEvent("", newChatError(ErrCodeInvalidFrame, "frame must be an object with a type")))
+			continue
+		}
+		if len(frame.ID) > maxClientMessageID {
+			client.reply(errorEvent("", newChatError(ErrCodeInvalidFrame, "id is too long")))
+			continue
+		}
+		h.handleFrame(client, &frame)
 	}
 }
 
@@ -355,19 +504,25 @@ func (h *ChatWebSocketHandler) writePump(client *ChatClient) {
 			return
 
 		case message, ok := <-client.Send:
-			client.Conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			client.Conn.SetWriteDeadline(time.Now().Add(writeWait))
 			if !ok {
 				client.Conn.WriteMessage(websocket.CloseMessage, []byte{})
 				return
 			}
 
-			if err := client.Conn.WriteJSON(message); err != nil {
-				log.Printf("WriteJSON error: %v", err)
+			frame, err := message.prepare(client.codec)
+			if err != nil {
+				log.Printf("Failed to encode %s event: %v", message.Type, err)
+				continue
+			}
+			client.Conn.EnableWriteCompression(frame.size >= compressionThreshold)
+			if err := client.Conn.WritePreparedMessage(frame.prepared); err != nil {
+				log.Printf("WriteMessage error: %v", err)
 				return
 			}
 
 		case <-ticker.C:
-			client.Conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			client.Conn.SetWriteDeadline(time.Now().Add(writeWait))
 			if err := client.Conn.WriteMessage(websocket.PingMessage, nil); err != nil {
 				return
 			}
@@ -375,22 +530,21 @@ func (h *ChatWebSocketHandler) writePump(client *ChatClient) {
 	}
 }
 
-// 发送初始化数据（从Redis获取在线用户列表）
-func (h *ChatWebSocketHandler) sendInitData(client *ChatClient, room *ChatRoom) {
-	users, err := room.GetOnlineUsersFromRedis()
+// 初始化数据（从Redis获取在线用户列表），序号在注册时由房间循环填入
+func (h *ChatWebSocketHandler) initEvent(client *ChatClient, room *ChatRoom) *ServerEvent {
+	users, err := h.onlineUsers(client.ctx, room.ID)
 	if err != nil {
 		log.Printf("Failed to get online users from Redis: %v", err)
-		users = []UserInfo{}
+		users = []liteRedis.UserInfo{}
 	}
 
-	initMsg := map[string]interface{}{
-		"type": "init",
-		"payload": map[string]interface{}{
-			"users": users,
+	return &ServerEvent{
+		Type: EventInit,
+		Payload: &InitPayload{
+			ProtocolVersion: chatProtocolVersion,
+			Users:           users,
 		},
 	}
-
-	client.Send <- initMsg
 }
 
 // 发送系统消息（用户加入/离开）
@@ -401,178 +555,442 @@ func (h *ChatWebSocketHandler) sendSystemMessage(room *ChatRoom, client *ChatCli
 	} else if action == "left" {
 		content = client.Username + " 离开了聊天室"
 	}
-	systemMsg := map[string]interface{}{
-		"type": "message",
-		"payload": map[string]interface{}{
-			"id":         uuid.New().String(),
-			"room_id":    room.ID,
-			"type":       "system",
-			"content":    content,
-			"created_at": time.Now(),
-		},
-	}
+	h.broadcastSystemMessage(room, content)
+}
 
+// 向房间广播一条系统消息
+func (h *ChatWebSocketHandler) broadcastSystemMessage(room *ChatRoom, content string) {
 	room.Broadcast <- &BroadcastMessage{
-		Data: systemMsg,
+		Event: &ServerEvent{
+			Type: EventMessage,
+			Payload: &SystemMessagePayload{
+				ID:        uuid.New().String(),
+				RoomID:    room.ID,
+				Type:      "system",
+				Content:   content,
+				CreatedAt: time.Now(),
+			},
+		},
 	}
 }
 
-// 消息类型分发
-func (h *ChatWebSocketHandler) handleMessage(client *ChatClient, msg map[string]interface{}) {
-	msgType, ok := msg["type"].(string)
-	if !ok {
+// handleFrame 解码、校验并处理一个入站事件；带 id 的事件成功后回复 ack，失败时总是回复 error
+func (h *ChatWebSocketHandler) handleFrame(client *ChatClient, frame *ClientFrame) {
+	ack, err := h.dispatch(client, frame)
+	if err != nil {
+		client.reply(errorEvent(frame.ID, toChatError(frame.Type, err)))
 		return
 	}
+	// resume 以带同一 id 的 resumed 事件作为回复
+	if frame.ID != "" && frame.Type != RequestResume {
+		event := &ServerEvent{Type: EventAck, ID: frame.ID}
+		if ack != nil {
+			event.Payload = ack
+		}
+		client.reply(event)
+	}
+}
 
-	payload, _ := msg["payload"].(map[string]interface{})
+// 消息类型分发
+func (h *ChatWebSocketHandler) dispatch(client *ChatClient, frame *ClientFrame) (*AckPayload, error) {
+	req := newChatRequest(frame.Type)
+	if req == nil {
+		return nil, newChatError(ErrCodeUnknownType, "unknown event type "+strconv.Quote(frame.Type))
+	}
+	if err := decodeChatRequest(frame.Payload, req); err != nil {
+		return nil, err
+	}
 
-	switch msgType {
-	case "message":
-		h.handleChatMessage(client, payload)
-	case "typing":
-		h.handleTyping(client, payload)
+	switch req := req.(type) {
+	case *SendMessageRequest:
+		return h.handleChatMessage(client, req, frame.ID)
+	case *TypingRequest:
+		return nil, h.handleTyping(client, req)
+	case *EditMessageRequest:
+		return h.handleEditMessage(client, req)
+	case *DeleteMessageRequest:
+		return h.handleDeleteMessage(client, req)
+	case *ReactionRequest:
+		return h.handleReaction(client, req, frame.Type == RequestAddReaction)
+	case *ResumeRequest:
+		return nil, h.handleResume(client, req, frame.ID)
+	case *MarkReadRequest:
+		return h.handleMarkRead(client, req)
+	case *ModerationRequest:
+		return nil, h.handleModeration(client, req)
+	case *SendTemplateRequest:
+		return h.handleSendTemplate(client, req, frame.ID)
 	}
+	return nil, newChatError(ErrCodeUnknownType, "unknown event type "+strconv.Quote(frame.Type))
 }
 
-// 异步数据库写入
-func (h *ChatWebSocketHandler) handleChatMessage(client *ChatClient, payload map[string]interface{}) {
-	content, ok := payload["content"].(string)
-	if !ok || content == "" {
-		return
+// errorEvent 错误回复，id 为对应的客户端消息 ID（帧无法解析时为空）
+func errorEvent(id string, err *chatError) *ServerEvent {
+	return &ServerEvent{
+		Type: EventError,
+		ID:   id,
+		Payload: &ErrorPayload{
+			Code:       err.Code,
+			Message:    err.Message,
+			RetryAfter: err.RetryAfter,
+		},
 	}
+}
 
-	now := time.Now()
-	message := models.Message{
-		RoomID:    client.Room.ID,
-		UserID:    client.UserID,
-		Content:   content,
-		Type:      "text",
-		CreatedAt: now,
+// 发送消息；clientMsgID 随广播带回，发送者据此和乐观显示的消息去重
+func (h *ChatWebSocketHandler) handleChatMessage(client *ChatClient, req *SendMessageRequest, clientMsgID string) (*AckPayload, error) {
+	if client.muted() {
+		return nil, services.ErrMutedInRoom
+	}
+	if !client.access.CanModerate() {
+		wait, err := h.rooms.CheckSlowMode(client.ctx, client.Room.ID, client.UserID, int(client.Room.slowMode.Load()))
+		if err != nil {
+			log.Printf("Failed to check slow mode: %v", err)
+		} else if wait > 0 {
+			seconds := int(wait.Seconds() + 0.999)
+			return nil, &chatError{
+				Code:       ErrCodeSlowMode,
+				Message:    fmt.Sprintf("slow mode is enabled, wait %d seconds", seconds),
+				RetryAfter: seconds,
+			}
+		}
 	}
 
-	// 异步保存到数据库
-	select {
-	case h.dbQueue <- &message:
-	default:
-		log.Println("Database queue full, dropping message")
+	// 同步保存，广播时带上消息 ID，客户端据此编辑、删除和回应
+	message, err := h.messages.CreateMessage(client.Room.ID, client.UserID, req.Content, req.ParentID, req.AttachmentID)
+	if err != nil {
+		return nil, err
 	}
 
 	// 如果是客服房间,更新会话信息
-	if strings.HasPrefix(client.Room.ID, "customer_service_") {
-		go h.updateCustomerServiceSession(client.Room.ID, content)
-	}
-
-	// 立即广播消息
-	broadcastMsg := map[string]interface{}{
-		"type": "message",
-		"payload": map[string]interface{}{
-			"id":         message.ID,
-			"room_id":    message.RoomID,
-			"user_id":    client.UserID,
-			"username":   client.Username,
-			"user_color": client.Color,
-			"content":    content,
-			"type":       "text",
-			"created_at": now,
+	if strings.HasPrefix(client.Room.ID, services.CustomerServiceRoomPrefix) {
+		go h.updateCustomerServiceSession(client.Room.ID, messageSummary(message), client.UserID)
+	}
+
+	payload := &MessagePayload{
+		ID:          message.ID,
+		RoomID:      message.RoomID,
+		UserID:      client.UserID,
+		Username:    client.Username,
+		UserColor:   client.Color,
+		Content:     message.Content,
+		Type:        message.Type,
+		CreatedAt:   message.CreatedAt,
+		ParentID:    message.ParentID,
+		ClientMsgID: clientMsgID,
+	}
+	if message.AttachmentID != nil {
+		attachments, err := h.attachments.Attachments([]uint{*message.AttachmentID})
+		if err != nil {
+			log.Printf("Failed to load attachment %d: %v", *message.AttachmentID, err)
+		} else {
+			payload.Attachment = attachments[*message.AttachmentID]
+		}
+	}
+	var notification *BroadcastMessage
+	if message.ParentID != nil {
+		notification = h.attachReply(client, message, payload)
+	}
+
+	client.Room.Broadcast <- &BroadcastMessage{
+		Event: &ServerEvent{Type: EventMessage, Payload: payload},
+	}
+	if notification != nil {
+		client.Room.Broadcast <- notification
+	}
+	return &AckPayload{MessageID: message.ID, CreatedAt: &message.CreatedAt}, nil
+}
+
+// handleSendTemplate 展开快捷回复模板后按普通消息发送，并在审计日志中记录使用的模板
+func (h *ChatWebSocketHandler) handleSendTemplate(client *ChatClient, req *SendTemplateRequest, clientMsgID string) (*AckPayload, error) {
+	template, content, err := h.canned.Render(client.User, client.Room.ID, req.TemplateID, req.Shortcut, req.Variables)
+	if err != nil {
+		return nil, err
+	}
+	ack, err := h.handleChatMessage(client, &SendMessageRequest{Content: content, ParentID: req.ParentID}, clientMsgID)
+	if err != nil {
+		return nil, err
+	}
+	h.canned.RecordUse(client.ctx, client.User, template, client.Room.ID, ack.MessageID)
+	return ack, nil
+}
+
+// attachReply 话题回复：消息中附带被引用的首条消息，并返回发给话题参与者（不含发送者）的通知
+func (h *ChatWebSocketHandler) attachReply(client *ChatClient, message *models.Message, payload *MessagePayload) *BroadcastMessage {
+	rootID := *message.ParentID
+	quotes, err := h.messages.Quotes([]uint{rootID})
+	if err != nil {
+		log.Printf("Failed to load quoted message %d: %v", rootID, err)
+	} else {
+		payload.ReplyTo = quotes[rootID]
+	}
+
+	participants, err := h.messages.ThreadParticipants(rootID)
+	if err != nil {
+		log.Printf("Failed to load thread participants for %d: %v", rootID, err)
+		return nil
+	}
+	userIDs := make(map[uint]bool, len(participants))
+	for _, id := range participants {
+		if id != client.UserID {
+			userIDs[id] = true
+		}
+	}
+	if len(userIDs) == 0 {
+		return nil
+	}
+	return &BroadcastMessage{
+		UserIDs: userIDs,
+		Event: &ServerEvent{
+			Type: EventThreadReply,
+			Payload: &ThreadReplyPayload{
+				ParentID:  rootID,
+				MessageID: message.ID,
+				RoomID:    message.RoomID,
+				UserID:    client.UserID,
+				Username:  client.Username,
+				Content:   message.Content,
+				CreatedAt: message.CreatedAt,
+			},
 		},
 	}
+}
 
+// 编辑消息（仅作者）
+func (h *ChatWebSocketHandler) handleEditMessage(client *ChatClient, req *EditMessageRequest) (*AckPayload, error) {
+	if client.muted() {
+		return nil, services.ErrMutedInRoom
+	}
+	message, err := h.messages.EditMessage(client.Room.ID, req.MessageID, client.UserID, req.Content)
+	if err != nil {
+		return nil, err
+	}
 	client.Room.Broadcast <- &BroadcastMessage{
-		Data: broadcastMsg,
+		Event: &ServerEvent{
+			Type: EventMessageEdited,
+			Payload: &MessageEditedPayload{
+				ID:       message.ID,
+				RoomID:   message.RoomID,
+				Content:  message.Content,
+				EditedAt: message.EditedAt,
+			},
+		},
 	}
+	return &AckPayload{MessageID: message.ID}, nil
 }
 
-func (h *ChatWebSocketHandler) updateCustomerServiceSession(roomID string, lastMessage string) {
-	var session models.CustomerSession
-	if err := h.db.Where("room_id = ?", roomID).First(&session).Error; err != nil {
-		return
+// 删除消息（作者或房主、版主）
+func (h *ChatWebSocketHandler) handleDeleteMessage(client *ChatClient, req *DeleteMessageRequest) (*AckPayload, error) {
+	message, err := h.messages.DeleteMessage(client.Room.ID, req.MessageID, client.UserID, client.access.CanModerate())
+	if err != nil {
+		return nil, err
 	}
-	session.LastMessage = lastMessage
-	session.UpdatedAt = time.Now()
-	if session.Status == "pending" {
-		session.Status = "active"
+	client.Room.Broadcast <- &BroadcastMessage{
+		Event: &ServerEvent{
+			Type: EventMessageDeleted,
+			Payload: &MessageDeletedPayload{
+				ID:        message.ID,
+				RoomID:    message.RoomID,
+				DeletedAt: message.DeletedAt,
+				DeletedBy: message.DeletedBy,
+			},
+		},
 	}
-	h.db.Save(&session)
+	return &AckPayload{MessageID: message.ID}, nil
 }
 
-func (h *ChatWebSocketHandler) handleTyping(client *ChatClient, payload map[string]interface{}) {
-	isTyping, ok := payload["is_typing"].(bool)
-	if !ok {
-		return
+// 添加/取消表情回应，广播该表情最新的回应人数
+func (h *ChatWebSocketHandler) handleReaction(client *ChatClient, req *ReactionRequest, add bool) (*AckPayload, error) {
+	var count int64
+	var err error
+	if add {
+		count, err = h.messages.AddReaction(client.Room.ID, req.MessageID, client.UserID, req.Emoji)
+	} else {
+		count, err = h.messages.RemoveReaction(client.Room.ID, req.MessageID, client.UserID, req.Emoji)
+	}
+	if err != nil {
+		return nil, err
+	}
+	action := "added"
+	if !add {
+		action = "removed"
 	}
+	client.Room.Broadcast <- &BroadcastMessage{
+		Event: &ServerEvent{
+			Type: EventReactionUpdated,
+			Payload: &ReactionUpdatedPayload{
+				MessageID: req.MessageID,
+				RoomID:    client.Room.ID,
+				Emoji:     req.Emoji,
+				Count:     count,
+				UserID:    client.UserID,
+				Action:    action,
+			},
+		},
+	}
+	return &AckPayload{MessageID: req.MessageID}, nil
+}
 
-	typingMsg := map[string]interface{}{
-		"type": "typing",
-		"payload": map[string]interface{}{
-			"user_id":   client.UserID,
-			"username":  client.Username,
-			"is_typing": isTyping,
+// 标记已读；已读位置前进时向房间广播已读回执
+func (h *ChatWebSocketHandler) handleMarkRead(client *ChatClient, req *MarkReadRequest) (*AckPayload, error) {
+	advanced, err := h.messages.MarkRead(client.Room.ID, client.UserID, req.MessageID)
+	if err != nil {
+		return nil, err
+	}
+	if !advanced {
+		return &AckPayload{MessageID: req.MessageID}, nil
+	}
+	if strings.HasPrefix(client.Room.ID, services.CustomerServiceRoomPrefix) {
+		go h.resetCustomerServiceUnread(client.Room.ID, client.UserID)
+	}
+	client.Room.Broadcast <- &BroadcastMessage{
+		Event: &ServerEvent{
+			Type: EventReadReceipt,
+			Payload: &ReadReceiptPayload{
+				RoomID:    client.Room.ID,
+				UserID:    client.UserID,
+				Username:  client.Username,
+				MessageID: req.MessageID,
+				ReadAt:    time.Now(),
+			},
 		},
 	}
+	return &AckPayload{MessageID: req.MessageID}, nil
+}
+
+// messageSummary 会话列表中显示的最后一条消息，附件没有说明文字时显示类型
+func messageSummary(message *models.Message) string {
+	if message.Content != "" {
+		return message.Content
+	}
+	switch message.Type {
+	case models.AttachmentImage:
+		return "[图片]"
+	case models.AttachmentAudio:
+		return "[语音]"
+	case models.AttachmentFile:
+		return "[文件]"
+	}
+	return ""
+}
+
+// 管理指令（房主或版主）：kick / ban / unban / mute / unmute / slow_mode
+// 执行结果通过系统消息广播，失败时只回复发送者
+func (h *ChatWebSocketHandler) handleModeration(client *ChatClient, req *ModerationRequest) error {
+	if client.access.RoomID == 0 {
+		return newChatError(ErrCodeForbidden, "moderation is not available in this room")
+	}
+	roomID := client.access.RoomID
+	duration := time.Duration(req.Duration) * time.Second
+
+	var err error
+	switch req.Action {
+	case ModerationKick:
+		err = h.rooms.KickUser(client.ctx, roomID, req.UserID, client.User, req.Reason)
+	case ModerationBan:
+		_, err = h.rooms.BanUser(client.ctx, roomID, req.UserID, client.User, duration, req.Reason)
+	case ModerationUnban:
+		err = h.rooms.UnbanUser(client.ctx, roomID, req.UserID, client.User)
+	case ModerationMute:
+		_, err = h.rooms.MuteUser(client.ctx, roomID, req.UserID, client.User, duration, req.Reason)
+	case ModerationUnmute:
+		err = h.rooms.UnmuteUser(client.ctx, roomID, req.UserID, client.User)
+	case ModerationSlowMode:
+		err = h.rooms.SetSlowMode(client.ctx, roomID, req.Seconds, client.User)
+	}
+	return err
+}
+
+// updateCustomerServiceSession 更新会话的最后一条消息和活动时间；客户发送的消息计入客服侧的未读数，
+// 客服的第一条回复记为首次响应（会话状态由分配流程维护）
+func (h *ChatWebSocketHandler) updateCustomerServiceSession(roomID string, lastMessage string, senderID uint) {
+	var session models.CustomerSession
+	sessionRoomID := strings.TrimPrefix(roomID, services.CustomerServiceRoomPrefix)
+	if err := h.db.Where("room_id = ?", sessionRoomID).First(&session).Error; err != nil {
+		return
+	}
+	now := time.Now()
+	updates := map[string]interface{}{
+		"last_message":     lastMessage,
+		"last_activity_at": now,
+		"updated_at":       now,
+	}
+	if senderID == session.UserID {
+		updates["unread_count"] = gorm.Expr("unread_count + 1")
+	} else if session.FirstResponseAt == nil && session.AgentID != nil && senderID == *session.AgentID {
+		// 只有当前接待的客服回复才算首次响应，主管旁听发言不计入 SLA
+		updates["first_response_at"] = now
+	}
+	h.db.Model(&session).Updates(updates)
+}
+
+// resetCustomerServiceUnread 客服读过会话后清零客服侧未读数（客户自己的已读不影响）
+func (h *ChatWebSocketHandler) resetCustomerServiceUnread(roomID string, readerID uint) {
+	sessionRoomID := strings.TrimPrefix(roomID, services.CustomerServiceRoomPrefix)
+	h.db.Model(&models.CustomerSession{}).
+		Where("room_id = ? AND user_id <> ?", sessionRoomID, readerID).
+		Update("unread_count", 0)
+}
 
+func (h *ChatWebSocketHandler) handleTyping(client *ChatClient, req *TypingRequest) error {
 	client.Room.Broadcast <- &BroadcastMessage{
-		Data:      typingMsg,
+		Event: &ServerEvent{
+			Type: EventTyping,
+			Payload: &TypingPayload{
+				UserID:   client.UserID,
+				Username: client.Username,
+				IsTyping: *req.IsTyping,
+			},
+		},
 		ExceptIDs: map[string]bool{client.ID: true},
 	}
+	return nil
 }
 
 // 广播用户加入
 func (h *ChatWebSocketHandler) broadcastUserJoined(room *ChatRoom, client *ChatClient) {
-	msg := map[string]interface{}{
-		"type": "user_joined",
-		"payload": map[string]interface{}{
-			"user_id":             client.UserID,
-			"username":            client.Username,
-			"color":               client.Color,
-			"system_message_sent": true,
-		},
-	}
-
 	room.Broadcast <- &BroadcastMessage{
-		Data:      msg,
+		Event: &ServerEvent{
+			Type: EventUserJoined,
+			Payload: &PresencePayload{
+				UserID:            client.UserID,
+				Username:          client.Username,
+				Color:             client.Color,
+				SystemMessageSent: true,
+			},
+		},
 		ExceptIDs: map[string]bool{client.ID: true},
 	}
 }
 
 // 广播用户离开
 func (h *ChatWebSocketHandler) broadcastUserLeft(room *ChatRoom, client *ChatClient) {
-	msg := map[string]interface{}{
-		"type": "user_left",
-		"payload": map[string]interface{}{
-			"user_id":             client.UserID,
-			"username":            client.Username,
-			"system_message_sent": true,
-		},
-	}
-
 	room.Broadcast <- &BroadcastMessage{
-		Data: msg,
+		Event: &ServerEvent{
+			Type: EventUserLeft,
+			Payload: &PresencePayload{
+				UserID:            client.UserID,
+				Username:          client.Username,
+				SystemMessageSent: true,
+			},
+		},
 	}
 }
 
 // HTTP接口：获取房间在线用户列表
 func (h *ChatWebSocketHandler) GetOnlineUsers(c echo.Context) error {
 	roomID := c.Param("roomId")
-
-	ctx := context.Background()
-	key := fmt.Sprintf("chat:room:%s:online_users", roomID)
+	if _, err := h.rooms.AuthorizeConnection(c.Request().Context(), roomID, c.Get("user").(*models.User)); err != nil {
+		return roomAccessError(c, err)
+	}
 
 	// 从Redis获取所有在线用户
-	result, err := h.redis.HGetAll(ctx, key).Result()
+	users, err := h.onlineUsers(c.Request().Context(), roomID)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{
 			"error": "failed to fetch online users",
 		})
 	}
-	users := make([]UserInfo, 0, len(result))
-	for _, data := range result {
-		var userInfo UserInfo
-		if err := json.Unmarshal([]byte(data), &userInfo); err != nil {
-			log.Printf("Failed to unmarshal user info: %v", err)
-			continue
-		}
-		users = append(users, userInfo)
-	}
 
 	return c.JSON(http.StatusOK, map[string]interface{}{
 		"room_id": roomID,
@@ -581,39 +999,331 @@ func (h *ChatWebSocketHandler) GetOnlineUsers(c echo.Context) error {
 	})
 }
 
+// onlineUsers 房间在线用户（多个连接的用户只出现一次）
+func (h *ChatWebSocketHandler) onlineUsers(ctx context.Context, roomID string) ([]liteRedis.UserInfo, error) {
+	users, err := liteRedis.RoomOnlineUsers(ctx, h.redis, roomID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range users {
+		users[i].Color = getUserColor(users[i].UserID)
+	}
+	return users, nil
+}
+
+// maxPresenceQuery 一次最多查询的用户数
+const maxPresenceQuery = 100
+
+// GetPresence 批量查询用户的全局在线状态，?user_ids=1,2,3
+func (h *ChatWebSocketHandler) GetPresence(c echo.Context) error {
+	var userIDs []uint
+	for _, part := range strings.Split(c.QueryParam("user_ids"), ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		id, err := strconv.ParseUint(part, 10, 64)
+		if err != nil || id == 0 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid user_ids"})
+		}
+		userIDs = append(userIDs, uint(id))
+	}
+	if len(userIDs) == 0 || len(userIDs) > maxPresenceQuery {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": fmt.Sprintf("user_ids must contain 1 to %d IDs", maxPresenceQuery),
+		})
+	}
+	statuses, err := liteRedis.UsersPresence(c.Request().Context(), h.redis, userIDs)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to fetch presence"})
+	}
+	return c.JSON(http.StatusOK, statuses)
+}
+
+// clientDevice 连接时声明的客户端类型，只保留简短的字母数字
+func clientDevice(device string) string {
+	device = strings.ToLower(strings.TrimSpace(device))
+	if device == "" || len(device) > 16 || strings.ContainsFunc(device, func(r rune) bool {
+		return (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '-' && r != '_'
+	}) {
+		return "web"
+	}
+	return device
+}
+
+// nodeID 本进程的节点标识，在线状态据此区分连接所在的节点
+func nodeID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "node"
+	}
+	return host + "-" + uuid.New().String()[:8]
+}
+
 // 获取聊天历史消息
 func (h *ChatWebSocketHandler) GetMessages(c echo.Context) error {
 	roomID := c.Param("roomId")
+	user := c.Get("user").(*models.User)
+	if _, err := h.rooms.AuthorizeConnection(c.Request().Context(), roomID, user); err != nil {
+		return roomAccessError(c, err)
+	}
+
+	// 游标参数：before/after/around 为消息 ID，最多指定一个；都不指定时返回最新一页
+	limit := defaultMessagePageSize
+	if v := c.QueryParam("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid limit"})
+		}
+		limit = min(n, maxMessagePageSize)
+	}
+	cursors := 0
+	var cursor uint
+	for _, name := range []string{"before", "after", "around"} {
+		if v := c.QueryParam(name); v != "" {
+			id, err := strconv.ParseUint(v, 10, 64)
+			if err != nil || id == 0 {
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid " + name})
+			}
+			cursors++
+			cursor = uint(id)
+		}
+	}
+	if cursors > 1 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "only one of before, after and around can be used"})
+	}
+
+	var page messagePage
+	var err error
+	switch {
+	case c.QueryParam("after") != "":
+		page, err = h.messagesAfter(user.ID, roomID, cursor, limit)
+	case c.QueryParam("around") != "":
+		page, err = h.messagesAround(user.ID, roomID, cursor, limit)
+	default:
+		// before 为 0 时表示从最新消息开始
+		page, err = h.messagesBefore(user.ID, roomID, cursor, limit)
+	}
+	if err == services.ErrMessageNotFound {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "failed to fetch messages",
+		})
+	}
+
+	return c.JSON(http.StatusOK, page)
+}
+
+const (
+	defaultMessagePageSize = 50
+	maxMessagePageSize     = 100
+)
+
+// messagePage 一页历史消息，按 ID 从新到旧排列
+type messagePage struct {
+	Messages []chatMessageView `json:"messages"`
+	HasOlder bool              `json:"has_older"` // 是否还有更早的消息（用最后一条的 ID 作为 before 继续加载）
+	HasNewer bool              `json:"has_newer"` // 是否还有更新的消息（用第一条的 ID 作为 after 继续加载）
+}
+
+// messagesBefore ID 小于 before 的消息，before 为 0 时从最新开始
+func (h *ChatWebSocketHandler) messagesBefore(userID uint, roomID string, before uint, limit int) (messagePage, error) {
+	var messages []chatMessageView
+	var err error
+	if before == 0 {
+		messages, err = h.queryMessages(userID, `
+			WHERE messages.room_id = ?
+			ORDER BY messages.id DESC
+			LIMIT ?
+		`, roomID, limit+1)
+	} else {
+		messages, err = h.queryMessages(userID, `
+			WHERE messages.room_id = ? AND messages.id < ?
+			ORDER BY messages.id DESC
+			LIMIT ?
+		`, roomID, before, limit+1)
+	}
+	if err != nil {
+		return messagePage{}, err
+	}
+	page := messagePage{Messages: messages, HasNewer: before != 0}
+	if len(messages) > limit {
+		page.Messages, page.HasOlder = messages[:limit], true
+	}
+	return page, nil
+}
+
+// messagesAfter ID 大于 after 的最早 limit 条消息
+func (h *ChatWebSocketHandler) messagesAfter(userID uint, roomID string, after uint, limit int) (messagePage, error) {
+	messages, err := h.queryMessages(userID, `
+		WHERE messages.room_id = ? AND messages.id > ?
+		ORDER BY messages.id ASC
+		LIMIT ?
+	`, roomID, after, limit+1)
+	if err != nil {
+		return messagePage{}, err
+	}
+	page := messagePage{HasOlder: true}
+	if len(messages) > limit {
+		messages, page.HasNewer = messages[:limit], true
+	}
+	slices.Reverse(messages)
+	page.Messages = messages
+	return page, nil
+}
+
+// messagesAround 跳转到指定消息：返回该消息及其前后各约一半的消息
+func (h *ChatWebSocketHandler) messagesAround(userID uint, roomID string, around uint, limit int) (messagePage, error) {
+	var count int64
+	if err := h.db.Model(&models.Message{}).Where("id = ? AND room_id = ?", around, roomID).Count(&count).Error; err != nil {
+		return messagePage{}, err
+	}
+	if count == 0 {
+		return messagePage{}, services.ErrMessageNotFound
+	}
+	// 目标消息算在较早的一半中，limit 为 1 时只返回目标消息本身
+	newer, err := h.messagesAfter(userID, roomID, around, (limit-1)/2)
+	if err != nil {
+		return messagePage{}, err
+	}
+	older, err := h.messagesBefore(userID, roomID, around+1, limit-len(newer.Messages))
+	if err != nil {
+		return messagePage{}, err
+	}
+	return messagePage{
+		Messages: append(newer.Messages, older.Messages...),
+		HasOlder: older.HasOlder,
+		HasNewer: newer.HasNewer,
+	}, nil
+}
 
-	// 分页参数
-	limit := 50
-	offset := 0
-	if c.QueryParam("offset") != "" {
-		fmt.Sscanf(c.QueryParam("offset"), "%d", &offset)
+// GetThread 获取话题首条消息及其全部回复（按时间正序）
+func (h *ChatWebSocketHandler) GetThread(c echo.Context) error {
+	roomID := c.Param("roomId")
+	user := c.Get("user").(*models.User)
+	if _, err := h.rooms.AuthorizeConnection(c.Request().Context(), roomID, user); err != nil {
+		return roomAccessError(c, err)
+	}
+	messageID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid message ID"})
 	}
 
-	var messages []struct {
-		models.Message
-		Username  string `json:"username"`
-		UserColor string `json:"user_color"`
+	root, err := h.messages.ThreadRoot(roomID, uint(messageID))
+	if err != nil {
+		if err == services.ErrMessageNotFound {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to fetch thread"})
+	}
+	messages, err := h.queryMessages(user.ID, `
+		WHERE messages.id = ? OR messages.parent_id = ?
+		ORDER BY messages.id ASC
+		LIMIT ?
+	`, root.ID, root.ID, maxThreadMessages)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to fetch thread"})
+	}
+	// 首条消息最早创建，排在第一位
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"parent":  messages[0],
+		"replies": messages[1:],
+	})
+}
+
+// GetReadStates 房间内各用户的已读位置（进入房间时渲染已读回执）
+func (h *ChatWebSocketHandler) GetReadStates(c echo.Context) error {
+	roomID := c.Param("roomId")
+	if _, err := h.rooms.AuthorizeConnection(c.Request().Context(), roomID, c.Get("user").(*models.User)); err != nil {
+		return roomAccessError(c, err)
 	}
+	states, err := h.messages.ReadStates(roomID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to fetch read states"})
+	}
+	return c.JSON(http.StatusOK, states)
+}
 
+// maxThreadMessages 话题接口一次返回的最大消息数（含首条消息）
+const maxThreadMessages = 501
+
+// chatMessageView 历史消息：附带发送者信息、引用的话题首条消息和表情回应
+type chatMessageView struct {
+	models.Message
+	Username   string                   `json:"username"`
+	UserColor  string                   `json:"user_color"`
+	ReplyTo    *services.MessageQuote   `json:"reply_to,omitempty" gorm:"-"`
+	Attachment *services.AttachmentView `json:"attachment,omitempty" gorm:"-"`
+	Reactions  []services.ReactionCount `json:"reactions" gorm:"-"`
+}
+
+// queryMessages 按条件查询历史消息，clause 为 WHERE/ORDER/LIMIT 部分
+func (h *ChatWebSocketHandler) queryMessages(userID uint, clause string, args ...interface{}) ([]chatMessageView, error) {
+	var messages []chatMessageView
 	err := h.db.Raw(`
-		SELECT messages.*, users.username, users.avatar as user_color
+		SELECT messages.*, users.username
 		FROM messages
 		LEFT JOIN users ON messages.user_id = users.id
-		WHERE messages.room_id = ?
-		ORDER BY messages.created_at ASC
-		LIMIT ? OFFSET ?
-	`, roomID, limit, offset).Scan(&messages).Error
+	`+clause, args...).Scan(&messages).Error
+	if err != nil {
+		return nil, err
+	}
 
+	ids := make([]uint, 0, len(messages))
+	var parentIDs, attachmentIDs []uint
+	for _, message := range messages {
+		ids = append(ids, message.ID)
+		if message.ParentID != nil {
+			parentIDs = append(parentIDs, *message.ParentID)
+		}
+		// 已删除消息的附件不再提供下载链接
+		if message.AttachmentID != nil && message.DeletedAt == nil {
+			attachmentIDs = append(attachmentIDs, *message.AttachmentID)
+		}
+	}
+	// 汇总表情回应（已删除的消息没有回应）
+	reactions, err := h.messages.ReactionCounts(ids, userID)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error": "failed to fetch messages",
-		})
+		return nil, err
 	}
+	quotes, err := h.messages.Quotes(parentIDs)
+	if err != nil {
+		return nil, err
+	}
+	attachments, err := h.attachments.Attachments(attachmentIDs)
+	if err != nil {
+		return nil, err
+	}
+	for i := range messages {
+		messages[i].UserColor = getUserColor(messages[i].UserID)
+		messages[i].Reactions = reactions[messages[i].ID]
+		if messages[i].Reactions == nil {
+			messages[i].Reactions = []services.ReactionCount{}
+		}
+		if messages[i].ParentID != nil {
+			messages[i].ReplyTo = quotes[*messages[i].ParentID]
+		}
+		if messages[i].AttachmentID != nil {
+			if attachment, ok := attachments[*messages[i].AttachmentID]; ok {
+				view := h.attachments.View(attachment, userID)
+				messages[i].Attachment = &view
+			}
+		}
+	}
+	return messages, nil
+}
 
-	return c.JSON(http.StatusOK, messages)
+// roomAccessError 将房间权限错误映射为 HTTP 状态码
+func roomAccessError(c echo.Context, err error) error {
+	switch err {
+	case services.ErrRoomNotFound:
+		return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+	case services.ErrAccessDenied, services.ErrNotRoomMember, services.ErrBannedFromRoom:
+		return c.JSON(http.StatusForbidden, map[string]string{"error": err.Error()})
+	default:
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to verify room access"})
+	}
 }
 
 // 获取用户颜色（根据ID生成）
@@ -0,0 +1,285 @@
+package handlers
+
+import (
+	"LiteAdmin/config"
+	"LiteAdmin/models"
+	"LiteAdmin/services"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// supportChat 客服会话的测试环境：SQLite、miniredis 和真实的 WebSocket 服务，?uid= 指定连接的用户
+type supportChat struct {
+	db       *gorm.DB
+	support  *services.SupportService
+	chat     *ChatWebSocketHandler
+	server   *httptest.Server
+	session  models.CustomerSession
+	customer *models.User
+	agentA   *models.User // 当前接待的客服
+	agentB   *models.User
+}
+
+func newSupportChat(t *testing.T) *supportChat {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "chat.db")), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&models.User{}, &models.Role{}, &models.Permission{}, &models.UserRole{},
+		&models.CustomerSession{}, &models.SupportAgent{}, &models.AuditLog{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := models.SeedRBAC(db); err != nil {
+		t.Fatal(err)
+	}
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+
+	env := &supportChat{
+		db:       db,
+		customer: &models.User{Username: "customer", Email: "customer@example.com", Type: models.RoleClient},
+		agentA:   &models.User{Username: "agent-a", Email: "agent-a@example.com", Type: models.RoleSupport},
+		agentB:   &models.User{Username: "agent-b", Email: "agent-b@example.com", Type: models.RoleSupport},
+	}
+	for _, user := range []*models.User{env.customer, env.agentA, env.agentB} {
+		if err := db.Create(user).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, agent := range []*models.User{env.agentA, env.agentB} {
+		if err := db.Create(&models.SupportAgent{UserID: agent.ID, Status: models.AgentStatusOnline, MaxConcurrent: 5}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	now := time.Now()
+	env.session = models.CustomerSession{
+		UserID:     env.customer.ID,
+		RoomID:     7,
+		Status:     models.SessionStatusActive,
+		AgentID:    &env.agentA.ID,
+		AssignedAt: &now,
+		QueuedAt:   &now,
+	}
+	if err := db.Create(&env.session).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	audit := services.NewAuditService(db)
+	rooms := services.NewRoomService(db, rdb, services.NewRBACService(db, rdb), audit)
+	env.support = services.NewSupportService(db, rdb, audit, &config.SupportConfig{})
+	h := NewChatWebSocketHandler(db, rdb, rooms, services.NewMessageService(db), nil, nil)
+	env.chat = h
+
+	e := echo.New()
+	e.GET("/ws/:roomId", h.HandleWebSocket, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			var user models.User
+			if err := db.First(&user, c.QueryParam("uid")).Error; err != nil {
+				return c.NoContent(http.StatusUnauthorized)
+			}
+			c.Set("user", &user)
+			return next(c)
+		}
+	})
+	env.server = httptest.NewServer(e)
+	t.Cleanup(env.server.Close)
+
+	// 控制频道订阅生效后再开始，否则断开指令可能在订阅前发布
+	deadline := time.Now().Add(5 * time.Second)
+	for mr.PubSubNumSub(services.ChatControlChannel)[services.ChatControlChannel] == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("chat control channel was not subscribed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return env
+}
+
+// dial 以 user 的身份连接会话房间，返回连接或握手失败时的 HTTP 状态码
+func (env *supportChat) dial(t *testing.T, user *models.User) (*websocket.Conn, int) {
+	t.Helper()
+	url := "ws" + strings.TrimPrefix(env.server.URL, "http") + "/ws/" +
+		services.CustomerServiceRoomPrefix + strconv.Itoa(int(env.session.RoomID)) + "?uid=" + strconv.Itoa(int(user.ID))
+	conn, resp, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		if resp == nil {
+			t.Fatalf("dial: %v", err)
+		}
+		return nil, resp.StatusCode
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn, http.StatusSwitchingProtocols
+}
+
+// waitInit 读取第一帧 init：房间循环注册连接时才放入，收到后断开指令一定能找到该连接
+func waitInit(t *testing.T, conn *websocket.Conn) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var event struct {
+		Type string `json:"type"`
+	}
+	if err := conn.ReadJSON(&event); err != nil {
+		t.Fatalf("read init: %v", err)
+	}
+	if event.Type != EventInit {
+		t.Fatalf("first frame is %q, want init", event.Type)
+	}
+}
+
+// waitClosed 读取直到服务端关闭连接，返回关闭帧
+func waitClosed(t *testing.T, conn *websocket.Conn) *websocket.CloseError {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			var closeErr *websocket.CloseError
+			if !errors.As(err, &closeErr) {
+				t.Fatalf("connection was not closed by the server: %v", err)
+			}
+			return closeErr
+		}
+	}
+}
+
+// TestSupportReassignmentDisconnectsPreviousAgent 转接、释放或下线后，原客服已建立的连接被断开且不能重新进入会话
+func TestSupportReassignmentDisconnectsPreviousAgent(t *testing.T) {
+	tests := []struct {
+		name     string
+		reassign func(env *supportChat) error
+	}{
+		{"transfer", func(env *supportChat) error {
+			_, err := env.support.Transfer(context.Background(), env.session.ID, env.agentA, env.agentB.ID)
+			return err
+		}},
+		{"release", func(env *supportChat) error {
+			_, err := env.support.Release(context.Background(), env.session.ID, env.agentA)
+			return err
+		}},
+		{"agent goes offline", func(env *supportChat) error {
+			_, err := env.support.SetAgentStatus(context.Background(), env.agentA, models.AgentStatusOffline, 0)
+			return err
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newSupportChat(t)
+			if _, status := env.dial(t, env.agentB); status != http.StatusForbidden {
+				t.Fatalf("unassigned agent: status = %d, want 403", status)
+			}
+			agentConn, status := env.dial(t, env.agentA)
+			if agentConn == nil {
+				t.Fatalf("assigned agent: status = %d", status)
+			}
+			waitInit(t, agentConn)
+			customerConn, status := env.dial(t, env.customer)
+			if customerConn == nil {
+				t.Fatalf("customer: status = %d", status)
+			}
+			waitInit(t, customerConn)
+
+			if err := tt.reassign(env); err != nil {
+				t.Fatal(err)
+			}
+
+			if closeErr := waitClosed(t, agentConn); closeErr.Code != websocket.ClosePolicyViolation {
+				t.Fatalf("close code = %d, want %d", closeErr.Code, websocket.ClosePolicyViolation)
+			}
+			if _, status := env.dial(t, env.agentA); status != http.StatusForbidden {
+				t.Fatalf("previous agent reconnect: status = %d, want 403", status)
+			}
+			// 会话被重新分配给 B，客户的连接不受影响
+			var session models.CustomerSession
+			if err := env.db.First(&session, env.session.ID).Error; err != nil {
+				t.Fatal(err)
+			}
+			if session.AgentID == nil || *session.AgentID != env.agentB.ID {
+				t.Fatalf("session agent = %v, want %d", session.AgentID, env.agentB.ID)
+			}
+			if conn, status := env.dial(t, env.agentB); conn == nil {
+				t.Fatalf("new agent: status = %d", status)
+			}
+			customerConn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+			for {
+				_, _, err := customerConn.ReadMessage()
+				var netErr net.Error
+				if errors.As(err, &netErr) && netErr.Timeout() {
+					break
+				}
+				if err != nil {
+					t.Fatalf("customer connection: %v", err)
+				}
+			}
+		})
+	}
+}
+
+// TestInitPrecedesBroadcasts 房间持续广播时连接：init 总是第一帧，之后的广播序号都大于 init 的序号
+func TestInitPrecedesBroadcasts(t *testing.T) {
+	env := newSupportChat(t)
+	room := env.chat.roomManager.GetOrCreateRoom(services.CustomerServiceRoomPrefix + strconv.Itoa(int(env.session.RoomID)))
+
+	ctx, stop := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for ctx.Err() == nil {
+			env.chat.broadcastSystemMessage(room, "flood")
+			time.Sleep(100 * time.Microsecond)
+		}
+	}()
+	defer func() {
+		stop()
+		<-done
+	}()
+
+	for i := 0; i < 5; i++ {
+		conn, status := env.dial(t, env.customer)
+		if conn == nil {
+			t.Fatalf("customer: status = %d", status)
+		}
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		var lastSeq int64
+		for n := 0; n < 600; n++ {
+			var event struct {
+				Type    string `json:"type"`
+				Seq     int64  `json:"seq"`
+				Payload struct {
+					Seq int64 `json:"seq"`
+				} `json:"payload"`
+			}
+			if err := conn.ReadJSON(&event); err != nil {
+				t.Fatalf("read frame %d: %v", n, err)
+			}
+			if n == 0 {
+				if event.Type != EventInit {
+					t.Fatalf("first frame is %q, want init", event.Type)
+				}
+				lastSeq = event.Payload.Seq
+				continue
+			}
+			if event.Seq <= lastSeq {
+				t.Fatalf("frame %d: seq %d after %d", n, event.Seq, lastSeq)
+			}
+			lastSeq = event.Seq
+		}
+		conn.Close()
+	}
+}
@@ -2,9 +2,11 @@ package handlers
 
 import (
 	"LiteAdmin/models"
+	"LiteAdmin/services"
 	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/labstack/echo/v4"
@@ -12,11 +14,13 @@ import (
 )
 
 type CustomerServiceHandler struct {
-	db *gorm.DB
+	db      *gorm.DB
+	audit   *services.AuditService
+	support *services.SupportService
 }
 
-func NewCustomerServiceHandler(db *gorm.DB) *CustomerServiceHandler {
-	return &CustomerServiceHandler{db: db}
+func NewCustomerServiceHandler(db *gorm.DB, audit *services.AuditService, support *services.SupportService) *CustomerServiceHandler {
+	return &CustomerServiceHandler{db: db, audit: audit, support: support}
 }
 
 // 创建或获取客服会话
@@ -65,22 +69,37 @@ func (h *CustomerServiceHandler) CreateOrGetSession(c echo.Context) error {
 				"error": "failed to create session",
 			})
 		}
+		// 新会话进入排队，有空闲客服时立即分配
+		if err := h.support.Enqueue(c.Request().Context(), &session); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{
+				"error": "failed to enqueue session",
+			})
+		}
 	} else if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{
 			"error": "database error",
 		})
 	}
 
-	// 如果会话是关闭状态,重新激活
-	if session.Status == "closed" {
-		session.Status = "pending"
-		session.UpdatedAt = time.Now()
-		h.db.Save(&session)
+	// 如果会话是关闭状态,重新排队
+	if session.Status == models.SessionStatusClosed {
+		if err := h.support.Enqueue(c.Request().Context(), &session); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{
+				"error": "failed to enqueue session",
+			})
+		}
+	}
+	queue, err := h.support.QueueStatus(user.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "failed to load queue status",
+		})
 	}
 
 	// 统一返回
 	return c.JSON(http.StatusOK, map[string]interface{}{
 		"session": session,
+		"queue":   queue,
 	})
 }
 
@@ -93,6 +112,10 @@ func (h *CustomerServiceHandler) GetAllSessions(c echo.Context) error {
 	if status != "" {
 		query = query.Where("status = ?", status)
 	}
+	// escalated=true 只看首次响应超时被升级的会话
+	if c.QueryParam("escalated") == "true" {
+		query = query.Where("escalated_at IS NOT NULL")
+	}
 	if err := query.Find(&sessions).Error; err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{
 			"error": "failed to fetch sessions",
@@ -124,14 +147,36 @@ func (h *CustomerServiceHandler) UpdateSessionStatus(c echo.Context) error {
 		})
 	}
 
+	before := session
 	session.Status = req.Status
 	session.UpdatedAt = time.Now()
+	if session.Status == models.SessionStatusClosed && before.Status != models.SessionStatusClosed {
+		session.ClosedAt = &session.UpdatedAt
+	}
 
 	if err := h.db.Save(&session).Error; err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{
 			"error": "failed to update session",
 		})
 	}
+	h.audit.Record(c.Request().Context(), services.AuditEntry{
+		Action:     services.AuditSessionStatus,
+		TargetType: "customer_session",
+		TargetID:   session.ID,
+		Before:     before,
+		After:      session,
+	})
+	// 关闭会话腾出客服名额；改回 pending 表示重新排队
+	switch session.Status {
+	case models.SessionStatusClosed:
+		h.support.Dispatch(c.Request().Context())
+	case models.SessionStatusPending:
+		if err := h.support.Enqueue(c.Request().Context(), &session); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{
+				"error": "failed to enqueue session",
+			})
+		}
+	}
 
 	return c.JSON(http.StatusOK, session)
 }
@@ -146,10 +191,161 @@ func (h *CustomerServiceHandler) UpdateLastMessage(roomID string, content string
 	session.LastMessage = content
 	session.UpdatedAt = time.Now()
 
-	// 如果状态是 pending,改为 active
-	if session.Status == "pending" {
-		session.Status = "active"
+	h.db.Save(&session)
+}
+
+// GetQueueStatus 用户查询自己会话的排队位置和接入的客服
+func (h *CustomerServiceHandler) GetQueueStatus(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+	status, err := h.support.QueueStatus(user.ID)
+	if err != nil {
+		return supportError(c, err)
 	}
+	return c.JSON(http.StatusOK, status)
+}
 
-	h.db.Save(&session)
+// UpdateAgentStatus 客服切换在线状态（online/away/offline），可同时调整接待上限
+func (h *CustomerServiceHandler) UpdateAgentStatus(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+	var req struct {
+		Status        string `json:"status"`
+		MaxConcurrent int    `json:"max_concurrent"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "invalid request",
+		})
+	}
+	agent, err := h.support.SetAgentStatus(c.Request().Context(), user, req.Status, req.MaxConcurrent)
+	if err != nil {
+		return supportError(c, err)
+	}
+	return c.JSON(http.StatusOK, agent)
+}
+
+// ListAgents 客服列表及当前接待数
+func (h *CustomerServiceHandler) ListAgents(c echo.Context) error {
+	agents, err := h.support.ListAgents()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "failed to fetch agents",
+		})
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"agents": agents,
+		"total":  len(agents),
+	})
+}
+
+// GetQueue 排队中的会话，按分配顺序排列
+func (h *CustomerServiceHandler) GetQueue(c echo.Context) error {
+	sessions, err := h.support.ListQueue()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "failed to fetch queue",
+		})
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"sessions": sessions,
+		"total":    len(sessions),
+	})
+}
+
+// AcceptSession 客服手动接入排队中的会话
+func (h *CustomerServiceHandler) AcceptSession(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+	sessionID, err := strconv.ParseUint(c.Param("sessionId"), 10, 64)
+	if err != nil {
+		return supportError(c, services.ErrSessionNotFound)
+	}
+	session, err := h.support.Accept(c.Request().Context(), uint(sessionID), user)
+	if err != nil {
+		return supportError(c, err)
+	}
+	return c.JSON(http.StatusOK, session)
+}
+
+// TransferSession 把自己接待的会话转给其他客服
+func (h *CustomerServiceHandler) TransferSession(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+	sessionID, err := strconv.ParseUint(c.Param("sessionId"), 10, 64)
+	if err != nil {
+		return supportError(c, services.ErrSessionNotFound)
+	}
+	var req struct {
+		AgentID uint `json:"agent_id"`
+	}
+	if err := c.Bind(&req); err != nil || req.AgentID == 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "agent_id is required",
+		})
+	}
+	session, err := h.support.Transfer(c.Request().Context(), uint(sessionID), user, req.AgentID)
+	if err != nil {
+		return supportError(c, err)
+	}
+	return c.JSON(http.StatusOK, session)
+}
+
+// ReleaseSession 释放自己接待的会话，会话回到队列由其他客服接入
+func (h *CustomerServiceHandler) ReleaseSession(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+	sessionID, err := strconv.ParseUint(c.Param("sessionId"), 10, 64)
+	if err != nil {
+		return supportError(c, services.ErrSessionNotFound)
+	}
+	session, err := h.support.Release(c.Request().Context(), uint(sessionID), user)
+	if err != nil {
+		return supportError(c, err)
+	}
+	return c.JSON(http.StatusOK, session)
+}
+
+// GetSLAMetrics 各客服的 SLA 指标，from/to 为 RFC 3339 时间，默认最近 7 天
+func (h *CustomerServiceHandler) GetSLAMetrics(c echo.Context) error {
+	to := time.Now()
+	if v := c.QueryParam("to"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid to"})
+		}
+		to = t
+	}
+	from := to.AddDate(0, 0, -7)
+	if v := c.QueryParam("from"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid from"})
+		}
+		from = t
+	}
+	if !from.Before(to) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "from must be before to"})
+	}
+	metrics, err := h.support.SLAMetrics(from, to)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "failed to fetch sla metrics",
+		})
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"from":   from,
+		"to":     to,
+		"agents": metrics,
+	})
+}
+
+func supportError(c echo.Context, err error) error {
+	switch err {
+	case services.ErrSessionNotFound:
+		return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+	case services.ErrNotSessionAgent:
+		return c.JSON(http.StatusForbidden, map[string]string{"error": err.Error()})
+	case services.ErrSessionNotQueued, services.ErrAgentUnavailable:
+		return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+	case services.ErrInvalidAgentStatus, services.ErrInvalidAgentCapacity:
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	default:
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to update session"})
+	}
 }
@@ -0,0 +1,471 @@
+package handlers
+
+import (
+	"LiteAdmin/models"
+	"LiteAdmin/services"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v4"
+)
+
+// refDecode 测试用的参考解码器，按 MessagePack 规范逐字节实现，与 msgpack 库互相独立
+func refDecode(t *testing.T, data []byte) interface{} {
+	t.Helper()
+	r := bytes.NewReader(data)
+	value, err := refRead(r)
+	if err != nil {
+		t.Fatalf("reference decoder: %v", err)
+	}
+	if r.Len() != 0 {
+		t.Fatalf("reference decoder: %d trailing bytes", r.Len())
+	}
+	return value
+}
+
+func refRead(r *bytes.Reader) (interface{}, error) {
+	code, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	uintN := func(size int) (uint64, error) {
+		buf := make([]byte, 8)
+		if _, err := io.ReadFull(r, buf[8-size:]); err != nil {
+			return 0, err
+		}
+		return binary.BigEndian.Uint64(buf), nil
+	}
+	switch {
+	case code>>7 == 0:
+		return int64(code), nil
+	case code>>5 == 0x7:
+		return int64(code) - 256, nil
+	case code>>5 == 0x5:
+		return refString(r, uint64(code&0x1f))
+	case code>>4 == 0x9:
+		return refArray(r, uint64(code&0x0f))
+	case code>>4 == 0x8:
+		return refMap(r, uint64(code&0x0f))
+	}
+	switch code {
+	case 0xc0:
+		return nil, nil
+	case 0xc2, 0xc3:
+		return code == 0xc3, nil
+	case 0xca:
+		bits, err := uintN(4)
+		return float64(math.Float32frombits(uint32(bits))), err
+	case 0xcb:
+		bits, err := uintN(8)
+		return math.Float64frombits(bits), err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		v, err := uintN(1 << (code - 0xcc))
+		if code != 0xcf {
+			return int64(v), err
+		}
+		return v, err
+	case 0xd0:
+		v, err := uintN(1)
+		return int64(int8(v)), err
+	case 0xd1:
+		v, err := uintN(2)
+		return int64(int16(v)), err
+	case 0xd2:
+		v, err := uintN(4)
+		return int64(int32(v)), err
+	case 0xd3:
+		v, err := uintN(8)
+		return int64(v), err
+	case 0xd9, 0xda, 0xdb:
+		n, err := uintN(1 << (code - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		return refString(r, n)
+	case 0xdc, 0xdd:
+		n, err := uintN(2 << (code - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return refArray(r, n)
+	case 0xde, 0xdf:
+		n, err := uintN(2 << (code - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return refMap(r, n)
+	}
+	return nil, fmt.Errorf("unexpected code 0x%02x", code)
+}
+
+func refString(r *bytes.Reader, n uint64) (string, error) {
+	buf := make([]byte, n)
+	_, err := io.ReadFull(r, buf)
+	return string(buf), err
+}
+
+func refArray(r *bytes.Reader, n uint64) ([]interface{}, error) {
+	items := []interface{}{}
+	for i := uint64(0); i < n; i++ {
+		item, err := refRead(r)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+func refMap(r *bytes.Reader, n uint64) (map[string]interface{}, error) {
+	m := map[string]interface{}{}
+	for i := uint64(0); i < n; i++ {
+		key, err := refRead(r)
+		if err != nil {
+			return nil, err
+		}
+		s, ok := key.(string)
+		if !ok {
+			return nil, fmt.Errorf("non-string key %T", key)
+		}
+		if m[s], err = refRead(r); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func numberedKeys(n int) map[string]interface{} {
+	m := make(map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		m["k"+strconv.Itoa(i)] = int64(i % 100)
+	}
+	return m
+}
+
+func filledArray(n int) []interface{} {
+	items := make([]interface{}, n)
+	for i := range items {
+		items[i] = int64(i % 100)
+	}
+	return items
+}
+
+// TestMsgpackRawJSONFormats json.RawMessage 按 JSON 解析后编码：每个长度和整数边界两侧都使用最短的格式，
+// 并由参考解码器还原出相同的值
+func TestMsgpackRawJSONFormats(t *testing.T) {
+	tests := []struct {
+		name  string
+		value interface{} // 参考解码器应还原出的值，同时用于生成输入 JSON
+		json  string      // 为空时由 value 生成
+		code  byte        // 期望的首字节
+	}{
+		{"nil", nil, "", 0xc0},
+		{"false", false, "", 0xc2},
+		{"true", true, "", 0xc3},
+
+		{"fixstr 0", "", "", 0xa0},
+		{"fixstr 31", strings.Repeat("a", 31), "", 0xbf},
+		{"str8 32", strings.Repeat("a", 32), "", 0xd9},
+		{"str8 255", strings.Repeat("a", 255), "", 0xd9},
+		{"str16 256", strings.Repeat("a", 256), "", 0xda},
+		{"str16 65535", strings.Repeat("a", 65535), "", 0xda},
+		{"str32 65536", strings.Repeat("a", 65536), "", 0xdb},
+		{"utf-8 bytes", "你好，世界", "", 0xa0 | 15},
+
+		{"fixarray 0", []interface{}{}, "", 0x90},
+		{"fixarray 15", filledArray(15), "", 0x9f},
+		{"array16 16", filledArray(16), "", 0xdc},
+		{"array16 65535", filledArray(65535), "", 0xdc},
+		{"array32 65536", filledArray(65536), "", 0xdd},
+
+		{"fixmap 0", map[string]interface{}{}, "", 0x80},
+		{"fixmap 15", numberedKeys(15), "", 0x8f},
+		{"map16 16", numberedKeys(16), "", 0xde},
+		{"map16 65535", numberedKeys(65535), "", 0xde},
+		{"map32 65536", numberedKeys(65536), "", 0xdf},
+
+		{"positive fixint 0", int64(0), "", 0x00},
+		{"positive fixint 127", int64(127), "", 0x7f},
+		{"uint8 128", int64(128), "", 0xcc},
+		{"uint8 255", int64(255), "", 0xcc},
+		{"uint16 256", int64(256), "", 0xcd},
+		{"uint16 65535", int64(65535), "", 0xcd},
+		{"uint32 65536", int64(65536), "", 0xce},
+		{"uint32 max", int64(math.MaxUint32), "", 0xce},
+		{"uint64 2^32", uint64(1 << 32), "", 0xcf},
+		{"uint64 2^53+1", uint64(1<<53 + 1), "", 0xcf},
+		{"uint64 max int64", uint64(math.MaxInt64), "", 0xcf},
+		{"uint64 max", uint64(math.MaxUint64), "", 0xcf},
+		{"negative fixint -1", int64(-1), "", 0xff},
+		{"negative fixint -32", int64(-32), "", 0xe0},
+		{"int8 -33", int64(-33), "", 0xd0},
+		{"int8 -128", int64(math.MinInt8), "", 0xd0},
+		{"int16 -129", int64(-129), "", 0xd1},
+		{"int16 min", int64(math.MinInt16), "", 0xd1},
+		{"int32 min int16 - 1", int64(math.MinInt16 - 1), "", 0xd2},
+		{"int32 min", int64(math.MinInt32), "", 0xd2},
+		{"int64 min int32 - 1", int64(math.MinInt32 - 1), "", 0xd3},
+		{"int64 -(2^53+1)", int64(-(1<<53 + 1)), "", 0xd3},
+		{"int64 min", int64(math.MinInt64), "", 0xd3},
+
+		{"float64", 1.5, "1.5", 0xcb},
+		{"float64 exponent", 1e300, "1e300", 0xcb},
+		{"float64 integral exponent", 1000.0, "1e3", 0xcb},
+		{"float64 beyond uint64", 18446744073709551616.0, "18446744073709551616", 0xcb},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := tt.json
+			if input == "" {
+				data, err := json.Marshal(tt.value)
+				if err != nil {
+					t.Fatal(err)
+				}
+				input = string(data)
+			}
+			packed, err := msgpack.Marshal(json.RawMessage(input))
+			if err != nil {
+				t.Fatalf("Marshal: %v", err)
+			}
+			if packed[0] != tt.code {
+				t.Fatalf("format = 0x%02x, want 0x%02x", packed[0], tt.code)
+			}
+			if got := refDecode(t, packed); !reflect.DeepEqual(got, tt.value) {
+				t.Fatalf("decoded value differs from input %.80s", input)
+			}
+		})
+	}
+}
+
+// refEncoder 测试用的参考编码器，可以选择任意（包括非最短的）格式
+type refEncoder struct{ bytes.Buffer }
+
+func (e *refEncoder) code(code byte, n uint64, size int) *refEncoder {
+	e.WriteByte(code)
+	buf := binary.BigEndian.AppendUint64(nil, n)
+	e.Write(buf[8-size:])
+	return e
+}
+
+func (e *refEncoder) str(code byte, size int, s string) *refEncoder {
+	if size == 0 {
+		e.WriteByte(code | byte(len(s)))
+	} else {
+		e.code(code, uint64(len(s)), size)
+	}
+	e.WriteString(s)
+	return e
+}
+
+// TestMsgpackCodecDecodePayload 客户端可能使用任意合法格式，payload 转换后与 JSON 编码得到的结果一致，大整数不丢精度
+func TestMsgpackCodecDecodePayload(t *testing.T) {
+	tests := []struct {
+		name  string
+		build func(e *refEncoder)
+		want  string
+	}{
+		{"uint8 as small int", func(e *refEncoder) { e.code(0xcc, 5, 1) }, `5`},
+		{"uint64 small", func(e *refEncoder) { e.code(0xcf, 7, 8) }, `7`},
+		{"uint64 2^53+1", func(e *refEncoder) { e.code(0xcf, 1<<53+1, 8) }, `9007199254740993`},
+		{"uint64 max", func(e *refEncoder) { e.code(0xcf, math.MaxUint64, 8) }, `18446744073709551615`},
+		{"int64 -(2^53+1)", func(e *refEncoder) { e.code(0xd3, 1<<64-(1<<53+1), 8) }, `-9007199254740993`},
+		{"int64 min", func(e *refEncoder) { e.code(0xd3, 1<<63, 8) }, `-9223372036854775808`},
+		{"int8 min", func(e *refEncoder) { e.code(0xd0, 0x80, 1) }, `-128`},
+		{"int16 -1", func(e *refEncoder) { e.code(0xd1, 0xffff, 2) }, `-1`},
+		{"int32 min", func(e *refEncoder) { e.code(0xd2, 0x80000000, 4) }, `-2147483648`},
+		{"negative fixint", func(e *refEncoder) { e.WriteByte(0xe0) }, `-32`},
+		{"float32", func(e *refEncoder) { e.code(0xca, uint64(math.Float32bits(0.5)), 4) }, `0.5`},
+		{"float64", func(e *refEncoder) { e.code(0xcb, math.Float64bits(-2.25), 8) }, `-2.25`},
+		{"str8 short", func(e *refEncoder) { e.str(0xd9, 1, "hi") }, `"hi"`},
+		{"str16 short", func(e *refEncoder) { e.str(0xda, 2, "hi") }, `"hi"`},
+		{"str32 short", func(e *refEncoder) { e.str(0xdb, 4, "hi") }, `"hi"`},
+		{"bin8 as base64", func(e *refEncoder) { e.code(0xc4, 3, 1).WriteString("abc") }, `"YWJj"`},
+		{"array16 short", func(e *refEncoder) { e.code(0xdc, 2, 2).code(0xc3, 0, 0).WriteByte(0xc0) }, `[true,null]`},
+		{"array32 short", func(e *refEncoder) { e.code(0xdd, 1, 4).WriteByte(0x01) }, `[1]`},
+		{"map16 short", func(e *refEncoder) { e.code(0xde, 1, 2).str(0xa0, 0, "a").WriteByte(0x02) }, `{"a":2}`},
+		{"map32 short", func(e *refEncoder) { e.code(0xdf, 1, 4).str(0xa0, 0, "b").WriteByte(0xc2) }, `{"b":false}`},
+		{"nested", func(e *refEncoder) {
+			e.WriteByte(0x82)
+			e.str(0xa0, 0, "id").code(0xcf, 1<<60, 8)
+			e.str(0xa0, 0, "tags").WriteByte(0x92)
+			e.str(0xa0, 0, "x").str(0xd9, 1, "y")
+		}, `{"id":1152921504606846976,"tags":["x","y"]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var e refEncoder
+			e.WriteByte(0x82)
+			e.str(0xa0, 0, "type").str(0xa0, 0, "message")
+			e.str(0xa0, 0, "payload")
+			tt.build(&e)
+			var frame ClientFrame
+			if err := (msgpackCodec{}).Decode(e.Bytes(), &frame); err != nil {
+				t.Fatalf("Decode: %v", err)
+			}
+			if frame.Type != "message" || string(frame.Payload) != tt.want {
+				t.Fatalf("got %s %s, want %s", frame.Type, frame.Payload, tt.want)
+			}
+		})
+	}
+}
+
+// TestMsgpackRoundTrip 回放的 JSON payload 编码后再按客户端帧解码，保持原文（键已排序、整数不经过 float64）
+func TestMsgpackRoundTrip(t *testing.T) {
+	inputs := []string{
+		`{"a":9007199254740993,"b":-9007199254740993,"c":18446744073709551615,"d":-9223372036854775808}`,
+		`{"content":"你好","mentions":[1,2,3],"reply_to":null,"seq":4294967296,"urgent":false}`,
+		`[0,127,128,255,256,65535,65536,4294967295,4294967296,-1,-32,-33,-128,-129,-32768,-32769,-2147483648,-2147483649]`,
+		`[1.5,-0.25,1e+300]`,
+	}
+	codec := msgpackCodec{}
+	for _, input := range inputs {
+		packed, err := codec.Encode(&ServerEvent{Type: "message", ID: "m1", Seq: 3, Replayed: true, Payload: json.RawMessage(input)})
+		if err != nil {
+			t.Fatalf("Encode(%s): %v", input, err)
+		}
+		var frame ClientFrame
+		if err := codec.Decode(packed, &frame); err != nil {
+			t.Fatalf("Decode(%s): %v", input, err)
+		}
+		if frame.Type != "message" || frame.ID != "m1" || string(frame.Payload) != input {
+			t.Fatalf("round trip\n got %s %s %s\nwant %s", frame.Type, frame.ID, frame.Payload, input)
+		}
+	}
+}
+
+// TestMsgpackMatchesJSON 两种编码的字段名、省略的字段和取值一致，时间为 RFC 3339 字符串
+func TestMsgpackMatchesJSON(t *testing.T) {
+	createdAt := time.Date(2026, 3, 1, 8, 30, 15, 123456789, time.FixedZone("CST", 8*3600))
+	parentID := uint(7)
+	events := []*ServerEvent{
+		{Type: EventMessage, Seq: 42, Payload: &MessagePayload{
+			ID:         1<<53 + 1,
+			RoomID:     "room-1",
+			UserID:     3,
+			Username:   "alice",
+			Content:    "你好",
+			Type:       "text",
+			CreatedAt:  createdAt,
+			ParentID:   &parentID,
+			ReplyTo:    &services.MessageQuote{ID: 7, UserID: 4, Username: "bob", Content: "hi", ReplyCount: 2},
+			Attachment: &models.Attachment{ID: 9, Kind: "image", StorageKey: "secret", Width: 640, CreatedAt: createdAt},
+		}},
+		{Type: EventMessage, Payload: &MessagePayload{ID: 1, CreatedAt: createdAt}},
+		{Type: EventInit, Payload: &InitPayload{ProtocolVersion: 1, Seq: 5}},
+		{Type: EventAck, ID: "c1", Payload: &AckPayload{}},
+		{Type: EventMessageEdited, Payload: &MessageEditedPayload{ID: 1, EditedAt: &createdAt}},
+		{Type: EventMessage, Seq: 8, Replayed: true, Payload: json.RawMessage(`{"id":18446744073709551615,"score":-1.5,"tags":["x"]}`)},
+		{Type: EventUserLeft},
+	}
+	for _, event := range events {
+		jsonData, err := jsonCodec{}.Encode(event)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoder := json.NewDecoder(bytes.NewReader(jsonData))
+		decoder.UseNumber()
+		var fromJSON interface{}
+		if err := decoder.Decode(&fromJSON); err != nil {
+			t.Fatal(err)
+		}
+		want, _ := json.Marshal(fromJSON)
+
+		packed, err := msgpackCodec{}.Encode(event)
+		if err != nil {
+			t.Fatalf("Encode(%s): %v", jsonData, err)
+		}
+		got, err := json.Marshal(refDecode(t, packed))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != string(want) {
+			t.Fatalf("msgpack differs from JSON\n got %s\nwant %s", got, want)
+		}
+	}
+}
+
+func TestMsgpackCodecRejectsMalformed(t *testing.T) {
+	codec := msgpackCodec{}
+	valid, err := codec.Encode(&ServerEvent{Type: "message", Payload: json.RawMessage(`{"content":"hello","ids":[1,300,70000,5000000000]}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 每个截断的前缀都应报错而不是 panic
+	for n := 0; n < len(valid); n++ {
+		var frame ClientFrame
+		if err := codec.Decode(valid[:n], &frame); err == nil {
+			t.Fatalf("prefix of %d bytes decoded without error", n)
+		}
+	}
+
+	// frame 构造 {"type": "message", "payload": <payload>}
+	frame := func(payload ...byte) []byte {
+		var e refEncoder
+		e.WriteByte(0x82)
+		e.str(0xa0, 0, "type").str(0xa0, 0, "message")
+		e.str(0xa0, 0, "payload").Write(payload)
+		return e.Bytes()
+	}
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"trailing data", append(append([]byte{}, valid...), 0xc0)},
+		{"extension type", frame(0xd4, 0x01, 0x00)},
+		{"type is not a string", []byte{0x81, 0xa4, 't', 'y', 'p', 'e', 0x01}},
+		{"forged array32 length", frame(0xdd, 0xff, 0xff, 0xff, 0xff)},
+		{"forged map32 length", frame(0xdf, 0xff, 0xff, 0xff, 0xff, 0xa0)},
+		{"forged str32 length", frame(0xdb, 0x7f, 0xff, 0xff, 0xff)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var frame ClientFrame
+			if err := codec.Decode(tt.data, &frame); err == nil {
+				t.Fatalf("decoded without error: %+v", frame)
+			}
+		})
+	}
+}
+
+// TestMsgpackCodecLargeIntegers 大于 2^53 的整数经过 msgpack 子协议收发后保持精确
+func TestMsgpackCodecLargeIntegers(t *testing.T) {
+	codec := msgpackCodec{}
+
+	var e refEncoder
+	e.WriteByte(0x83)
+	e.str(0xa0, 0, "type").str(0xa0, 0, "read")
+	e.str(0xa0, 0, "id").str(0xa0, 0, "c1")
+	e.str(0xa0, 0, "payload").WriteByte(0x81)
+	e.str(0xa0, 0, "message_id").code(0xcf, 1<<53+1, 8)
+	var frame ClientFrame
+	if err := codec.Decode(e.Bytes(), &frame); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	var payload struct {
+		MessageID uint64 `json:"message_id"`
+	}
+	if err := json.Unmarshal(frame.Payload, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if frame.Type != "read" || frame.ID != "c1" || payload.MessageID != 1<<53+1 {
+		t.Fatalf("decoded frame %+v, message_id %d", frame, payload.MessageID)
+	}
+
+	data, err := codec.Encode(&ServerEvent{Type: "message", Seq: 1<<53 + 1, Payload: map[string]uint64{"id": math.MaxUint64}})
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	event := refDecode(t, data).(map[string]interface{})
+	if event["seq"] != uint64(1<<53+1) || event["payload"].(map[string]interface{})["id"] != uint64(math.MaxUint64) {
+		t.Fatalf("encoded event %v", event)
+	}
+}
@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"LiteAdmin/models"
+	"LiteAdmin/services"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+type PetHandler struct {
+	pets *services.PetService
+}
+
+func NewPetHandler(pets *services.PetService) *PetHandler {
+	return &PetHandler{pets: pets}
+}
+
+// ListPets 本店铺的商品列表，sku 精确查找，page/page_size 分页
+func (h *PetHandler) ListPets(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+	page, _ := strconv.Atoi(c.QueryParam("page"))
+	pageSize, _ := strconv.Atoi(c.QueryParam("page_size"))
+	pets, total, err := h.pets.List(user, c.QueryParam("sku"), page, pageSize)
+	if err != nil {
+		return petError(c, err)
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"pets":  pets,
+		"total": total,
+	})
+}
+
+// CreatePet 新建商品，审核通过后才能上架
+func (h *PetHandler) CreatePet(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+	var req services.PetInput
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid request"})
+	}
+	pet, err := h.pets.Create(c.Request().Context(), user, req)
+	if err != nil {
+		return petError(c, err)
+	}
+	return c.JSON(http.StatusCreated, pet)
+}
+
+// UpdatePet 修改商品信息、库存或上下架状态
+func (h *PetHandler) UpdatePet(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return petError(c, services.ErrPetNotFound)
+	}
+	var req services.PetInput
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid request"})
+	}
+	pet, err := h.pets.Update(c.Request().Context(), user, uint(id), req)
+	if err != nil {
+		return petError(c, err)
+	}
+	return c.JSON(http.StatusOK, pet)
+}
+
+func petError(c echo.Context, err error) error {
+	switch err {
+	case services.ErrNotMerchant:
+		return c.JSON(http.StatusForbidden, map[string]string{"error": err.Error()})
+	case services.ErrPetNotFound:
+		return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+	case services.ErrSKUTaken:
+		return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+	case services.ErrInvalidPet, services.ErrInvalidPetStatus:
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	default:
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to process pet"})
+	}
+}
@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"LiteAdmin/models"
+	"LiteAdmin/services"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+type RBACHandler struct {
+	db    *gorm.DB
+	rbac  *services.RBACService
+	audit *services.AuditService
+}
+
+func NewRBACHandler(db *gorm.DB, rbac *services.RBACService, audit *services.AuditService) *RBACHandler {
+	return &RBACHandler{db: db, rbac: rbac, audit: audit}
+}
+
+// ListRoles 获取所有角色及权限（管理员）
+func (h *RBACHandler) ListRoles(c echo.Context) error {
+	roles, err := h.rbac.ListRoles()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"code":    500,
+			"message": "获取角色失败",
+			"error":   err.Error(),
+		})
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"code":    200,
+		"message": "success",
+		"data":    roles,
+	})
+}
+
+// GetUserPermissions 查看用户的角色与有效权限（管理员）
+func (h *RBACHandler) GetUserPermissions(c echo.Context) error {
+	user, ok := h.findUser(c)
+	if !ok {
+		return nil
+	}
+	roles, err := h.rbac.UserRoles(user)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"code":    500,
+			"message": "获取角色失败",
+			"error":   err.Error(),
+		})
+	}
+	perms, err := h.rbac.UserPermissions(c.Request().Context(), user)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"code":    500,
+			"message": "获取权限失败",
+			"error":   err.Error(),
+		})
+	}
+	roleNames := make([]string, 0, len(roles))
+	for _, role := range roles {
+		roleNames = append(roleNames, role.Name)
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"code":    200,
+		"message": "success",
+		"data": map[string]interface{}{
+			"user_id":     user.ID,
+			"roles":       roleNames,
+			"permissions": perms,
+		},
+	})
+}
+
+// AssignRole 为用户分配角色（管理员）
+func (h *RBACHandler) AssignRole(c echo.Context) error {
+	user, ok := h.findUser(c)
+	if !ok {
+		return nil
+	}
+	var req struct {
+		Role string `json:"role" validate:"required"`
+	}
+	if err := c.Bind(&req); err != nil || req.Role == "" {
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"code":    400,
+			"message": "请求参数错误",
+		})
+	}
+	if err := h.rbac.AssignRole(c.Request().Context(), user.ID, req.Role); err != nil {
+		return h.roleError(c, err, "分配角色失败")
+	}
+	h.audit.Record(c.Request().Context(), services.AuditEntry{
+		Action:     services.AuditRoleAssign,
+		TargetType: "user",
+		TargetID:   user.ID,
+		After:      map[string]string{"role": req.Role},
+	})
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"code":    200,
+		"message": "分配成功",
+	})
+}
+
+// RemoveRole 移除用户的角色（管理员）
+func (h *RBACHandler) RemoveRole(c echo.Context) error {
+	user, ok := h.findUser(c)
+	if !ok {
+		return nil
+	}
+	if err := h.rbac.RemoveRole(c.Request().Context(), user.ID, c.Param("role")); err != nil {
+		return h.roleError(c, err, "移除角色失败")
+	}
+	h.audit.Record(c.Request().Context(), services.AuditEntry{
+		Action:     services.AuditRoleRemove,
+		TargetType: "user",
+		TargetID:   user.ID,
+		Before:     map[string]string{"role": c.Param("role")},
+	})
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"code":    200,
+		"message": "移除成功",
+	})
+}
+
+// findUser 解析路径中的用户ID，返回 false 时已写入错误响应
+func (h *RBACHandler) findUser(c echo.Context) (*models.User, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"code":    400,
+			"message": "无效的用户ID",
+		})
+		return nil, false
+	}
+	var user models.User
+	if err := h.db.First(&user, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, map[string]interface{}{
+				"code":    404,
+				"message": "用户不存在",
+			})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"code":    500,
+			"message": "获取用户失败",
+			"error":   err.Error(),
+		})
+		return nil, false
+	}
+	return &user, true
+}
+
+func (h *RBACHandler) roleError(c echo.Context, err error, message string) error {
+	switch {
+	case errors.Is(err, services.ErrRoleNotFound):
+		return c.JSON(http.StatusNotFound, map[string]interface{}{
+			"code":    404,
+			"message": "角色不存在",
+		})
+	case errors.Is(err, services.ErrUserNotFound):
+		return c.JSON(http.StatusNotFound, map[string]interface{}{
+			"code":    404,
+			"message": "用户不存在",
+		})
+	default:
+		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"code":    500,
+			"message": message,
+			"error":   err.Error(),
+		})
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/labstack/echo/v4"
 	"net/http"
 	"strconv"
+	"time"
 )
 
 type RoomHandler struct {
@@ -26,7 +27,7 @@ func (h *RoomHandler) CreateRoom(c echo.Context) error {
 	}
 	room, err := h.roomService.CreateRoom(inputRoom, user)
 	if err != nil {
-		if err.Error() == "password is required for private rooms" {
+		if err.Error() == "password is required for private rooms" || err == services.ErrInvalidPrivacy {
 			return c.JSON(http.StatusBadRequest, map[string]string{
 				"error": err.Error(),
 			})
@@ -38,9 +39,10 @@ func (h *RoomHandler) CreateRoom(c echo.Context) error {
 	return c.JSON(http.StatusCreated, room)
 }
 
-// ListRooms 获取所有房间
+// ListRooms 获取所有房间（私密房间只返回自己加入的）
 func (h *RoomHandler) ListRooms(c echo.Context) error {
-	rooms, err := h.roomService.ListRooms()
+	user := c.Get("user").(*models.User)
+	rooms, err := h.roomService.ListRooms(user)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{
 			"error": "failed to fetch rooms",
@@ -61,7 +63,8 @@ func (h *RoomHandler) GetRoom(c echo.Context) error {
 	roomID := uint(roomID64)
 
 	// 调用 Service
-	room, err := h.roomService.GetRoomByID(roomID)
+	user := c.Get("user").(*models.User)
+	room, err := h.roomService.GetRoomByID(roomID, user)
 
 	// HTTP 职责：将 Service error 映射为 HTTP 状态码
 	if err != nil {
@@ -78,8 +81,9 @@ func (h *RoomHandler) GetRoom(c echo.Context) error {
 	return c.JSON(http.StatusOK, room)
 }
 
-// JoinRoom 加入房间（验证密码）
+// JoinRoom 加入房间（密码房间需验证密码），成功后成为房间成员
 func (h *RoomHandler) JoinRoom(c echo.Context) error {
+	user := c.Get("user").(*models.User)
 	// HTTP 职责：解析 Param
 	roomIDStr := c.Param("id")
 	roomID64, err := strconv.ParseUint(roomIDStr, 10, 64)
@@ -97,7 +101,7 @@ func (h *RoomHandler) JoinRoom(c echo.Context) error {
 	}
 
 	// 调用 Service
-	room, err := h.roomService.AuthorizeRoomEntry(roomID, req.Password)
+	room, err := h.roomService.JoinRoom(roomID, user, req.Password)
 
 	// HTTP 职责：映射 error
 	if err != nil {
@@ -108,6 +112,11 @@ func (h *RoomHandler) JoinRoom(c echo.Context) error {
 			return c.JSON(http.StatusUnauthorized, map[string]string{"error": err.Error()})
 		case services.ErrIncorrectPassword:
 			return c.JSON(http.StatusUnauthorized, map[string]string{"error": err.Error()})
+		case services.ErrBannedFromRoom:
+			return c.JSON(http.StatusForbidden, map[string]string{"error": err.Error()})
+		case services.ErrInviteRequired:
+			// 私密房间对非成员不可见
+			return c.JSON(http.StatusNotFound, map[string]string{"error": services.ErrRoomNotFound.Error()})
 		default:
 			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to verify room access"})
 		}
@@ -134,7 +143,7 @@ func (h *RoomHandler) DeleteRoom(c echo.Context) error {
 	roomID := uint(roomID64)
 
 	// 调用 Service
-	if err := h.roomService.DeleteRoom(roomID, user); err != nil {
+	if err := h.roomService.DeleteRoom(c.Request().Context(), roomID, user); err != nil {
 		switch err {
 		case services.ErrRoomNotFound:
 			return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
@@ -149,3 +158,174 @@ func (h *RoomHandler) DeleteRoom(c echo.Context) error {
 		"message": "room deleted",
 	})
 }
+
+// LeaveRoom 退出房间
+func (h *RoomHandler) LeaveRoom(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+	roomID, ok := parseRoomID(c)
+	if !ok {
+		return nil
+	}
+	if err := h.roomService.LeaveRoom(c.Request().Context(), roomID, user); err != nil {
+		return roomMemberError(c, err, "failed to leave room")
+	}
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": "left room",
+	})
+}
+
+// ListMembers 房间成员列表
+func (h *RoomHandler) ListMembers(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+	roomID, ok := parseRoomID(c)
+	if !ok {
+		return nil
+	}
+	members, err := h.roomService.ListMembers(roomID, user)
+	if err != nil {
+		return roomMemberError(c, err, "failed to fetch members")
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"members": members,
+	})
+}
+
+// UpdateMemberRole 设置成员角色（房主）
+func (h *RoomHandler) UpdateMemberRole(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+	roomID, ok := parseRoomID(c)
+	if !ok {
+		return nil
+	}
+	memberID, err := strconv.ParseUint(c.Param("userId"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid user ID"})
+	}
+	var req struct {
+		Role string `json:"role"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid request"})
+	}
+	if err := h.roomService.UpdateMemberRole(c.Request().Context(), roomID, uint(memberID), req.Role, user); err != nil {
+		return roomMemberError(c, err, "failed to update member")
+	}
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": "member updated",
+	})
+}
+
+// RemoveMember 移出成员（房主或版主）
+func (h *RoomHandler) RemoveMember(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+	roomID, ok := parseRoomID(c)
+	if !ok {
+		return nil
+	}
+	memberID, err := strconv.ParseUint(c.Param("userId"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid user ID"})
+	}
+	if err := h.roomService.RemoveMember(c.Request().Context(), roomID, uint(memberID), user); err != nil {
+		return roomMemberError(c, err, "failed to remove member")
+	}
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": "member removed",
+	})
+}
+
+// CreateInvite 创建邀请链接（房主或版主）
+func (h *RoomHandler) CreateInvite(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+	roomID, ok := parseRoomID(c)
+	if !ok {
+		return nil
+	}
+	var req struct {
+		ExpiresInHours int `json:"expiresInHours"` // 0 表示不过期
+		MaxUses        int `json:"maxUses"`        // 0 表示不限次数
+	}
+	if err := c.Bind(&req); err != nil || req.ExpiresInHours < 0 || req.MaxUses < 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid request"})
+	}
+	invite, err := h.roomService.CreateInvite(roomID, user, time.Duration(req.ExpiresInHours)*time.Hour, req.MaxUses)
+	if err != nil {
+		return roomMemberError(c, err, "failed to create invite")
+	}
+	return c.JSON(http.StatusCreated, invite)
+}
+
+// ListInvites 房间的邀请链接（房主或版主）
+func (h *RoomHandler) ListInvites(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+	roomID, ok := parseRoomID(c)
+	if !ok {
+		return nil
+	}
+	invites, err := h.roomService.ListInvites(roomID, user)
+	if err != nil {
+		return roomMemberError(c, err, "failed to fetch invites")
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"invites": invites,
+	})
+}
+
+// RevokeInvite 撤销邀请链接（房主或版主）
+func (h *RoomHandler) RevokeInvite(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+	roomID, ok := parseRoomID(c)
+	if !ok {
+		return nil
+	}
+	inviteID, err := strconv.ParseUint(c.Param("inviteId"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid invite ID"})
+	}
+	if err := h.roomService.RevokeInvite(roomID, uint(inviteID), user); err != nil {
+		return roomMemberError(c, err, "failed to revoke invite")
+	}
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": "invite revoked",
+	})
+}
+
+// AcceptInvite 通过邀请码加入房间
+func (h *RoomHandler) AcceptInvite(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+	room, err := h.roomService.JoinByInvite(c.Param("code"), user)
+	if err != nil {
+		return roomMemberError(c, err, "failed to join room")
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message": "access granted",
+		"room_id": room.ID,
+	})
+}
+
+func parseRoomID(c echo.Context) (uint, bool) {
+	roomID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid room ID"})
+		return 0, false
+	}
+	return uint(roomID), true
+}
+
+func roomMemberError(c echo.Context, err error, message string) error {
+	switch err {
+	case services.ErrRoomNotFound, services.ErrMemberNotFound, services.ErrInviteNotFound,
+		services.ErrUserNotFound, services.ErrRestrictionNotFound:
+		return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+	case services.ErrAccessDenied, services.ErrNotRoomMember, services.ErrCannotManageOwner,
+		services.ErrBannedFromRoom:
+		return c.JSON(http.StatusForbidden, map[string]string{"error": err.Error()})
+	case services.ErrInviteInvalid:
+		return c.JSON(http.StatusGone, map[string]string{"error": err.Error()})
+	case services.ErrOwnerCannotLeave, services.ErrInvalidRoomRole, services.ErrCannotModerateSelf,
+		services.ErrInvalidSlowMode:
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	default:
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": message})
+	}
+}
@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"LiteAdmin/models"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// moderationRequest 踢出、封禁、禁言的请求体，DurationSeconds 为 0 表示永久
+type moderationRequest struct {
+	UserID          uint   `json:"userId"`
+	DurationSeconds int    `json:"durationSeconds"`
+	Reason          string `json:"reason"`
+}
+
+func bindModerationRequest(c echo.Context) (*moderationRequest, bool) {
+	var req moderationRequest
+	if err := c.Bind(&req); err != nil || req.UserID == 0 || req.DurationSeconds < 0 || len(req.Reason) > 255 {
+		c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid request"})
+		return nil, false
+	}
+	return &req, true
+}
+
+// KickUser 把用户踢出聊天室（房主或版主）
+func (h *RoomHandler) KickUser(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+	roomID, ok := parseRoomID(c)
+	if !ok {
+		return nil
+	}
+	req, ok := bindModerationRequest(c)
+	if !ok {
+		return nil
+	}
+	if err := h.roomService.KickUser(c.Request().Context(), roomID, req.UserID, user, req.Reason); err != nil {
+		return roomMemberError(c, err, "failed to kick user")
+	}
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": "user kicked",
+	})
+}
+
+// BanUser 封禁用户（房主或版主）
+func (h *RoomHandler) BanUser(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+	roomID, ok := parseRoomID(c)
+	if !ok {
+		return nil
+	}
+	req, ok := bindModerationRequest(c)
+	if !ok {
+		return nil
+	}
+	restriction, err := h.roomService.BanUser(c.Request().Context(), roomID, req.UserID, user,
+		time.Duration(req.DurationSeconds)*time.Second, req.Reason)
+	if err != nil {
+		return roomMemberError(c, err, "failed to ban user")
+	}
+	return c.JSON(http.StatusCreated, restriction)
+}
+
+// UnbanUser 解除封禁（房主或版主）
+func (h *RoomHandler) UnbanUser(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+	roomID, ok := parseRoomID(c)
+	if !ok {
+		return nil
+	}
+	targetID, err := strconv.ParseUint(c.Param("userId"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid user ID"})
+	}
+	if err := h.roomService.UnbanUser(c.Request().Context(), roomID, uint(targetID), user); err != nil {
+		return roomMemberError(c, err, "failed to unban user")
+	}
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": "user unbanned",
+	})
+}
+
+// MuteUser 禁言用户（房主或版主）
+func (h *RoomHandler) MuteUser(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+	roomID, ok := parseRoomID(c)
+	if !ok {
+		return nil
+	}
+	req, ok := bindModerationRequest(c)
+	if !ok {
+		return nil
+	}
+	restriction, err := h.roomService.MuteUser(c.Request().Context(), roomID, req.UserID, user,
+		time.Duration(req.DurationSeconds)*time.Second, req.Reason)
+	if err != nil {
+		return roomMemberError(c, err, "failed to mute user")
+	}
+	return c.JSON(http.StatusCreated, restriction)
+}
+
+// UnmuteUser 解除禁言（房主或版主）
+func (h *RoomHandler) UnmuteUser(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+	roomID, ok := parseRoomID(c)
+	if !ok {
+		return nil
+	}
+	targetID, err := strconv.ParseUint(c.Param("userId"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid user ID"})
+	}
+	if err := h.roomService.UnmuteUser(c.Request().Context(), roomID, uint(targetID), user); err != nil {
+		return roomMemberError(c, err, "failed to unmute user")
+	}
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": "user unmuted",
+	})
+}
+
+// SetSlowMode 设置慢速模式（房主或版主），seconds 为 0 表示关闭
+func (h *RoomHandler) SetSlowMode(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+	roomID, ok := parseRoomID(c)
+	if !ok {
+		return nil
+	}
+	var req struct {
+		Seconds int `json:"seconds"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid request"})
+	}
+	if err := h.roomService.SetSlowMode(c.Request().Context(), roomID, req.Seconds, user); err != nil {
+		return roomMemberError(c, err, "failed to update slow mode")
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message":   "slow mode updated",
+		"slow_mode": req.Seconds,
+	})
+}
+
+// ListRestrictions 生效中的封禁和禁言（房主或版主）
+func (h *RoomHandler) ListRestrictions(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+	roomID, ok := parseRoomID(c)
+	if !ok {
+		return nil
+	}
+	restrictions, err := h.roomService.ListRestrictions(roomID, user)
+	if err != nil {
+		return roomMemberError(c, err, "failed to fetch restrictions")
+	}
+	return c.JSON(http.StatusOK, restrictions)
+}
@@ -0,0 +1,218 @@
+package handlers
+
+import (
+	"LiteAdmin/models"
+	"LiteAdmin/services"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+type UserAdminHandler struct {
+	users *services.UserAdminService
+	audit *services.AuditService
+}
+
+func NewUserAdminHandler(users *services.UserAdminService, audit *services.AuditService) *UserAdminHandler {
+	return &UserAdminHandler{users: users, audit: audit}
+}
+
+// ListUsers 搜索用户（管理员）
+// GET /admin/users?q=&email=&username=&provider=&type=&banned=&page=&page_size=
+func (h *UserAdminHandler) ListUsers(c echo.Context) error {
+	filter := services.UserFilter{
+		Keyword:  c.QueryParam("q"),
+		Email:    c.QueryParam("email"),
+		Username: c.QueryParam("username"),
+		Provider: c.QueryParam("provider"),
+		Type:     c.QueryParam("type"),
+	}
+	filter.Page, _ = strconv.Atoi(c.QueryParam("page"))
+	filter.PageSize, _ = strconv.Atoi(c.QueryParam("page_size"))
+	if banned := c.QueryParam("banned"); banned != "" {
+		value, err := strconv.ParseBool(banned)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]interface{}{
+				"code":    400,
+				"message": "banned 参数错误",
+			})
+		}
+		filter.Banned = &value
+	}
+	users, total, err := h.users.SearchUsers(filter)
+	if err != nil {
+		return userAdminError(c, err, "获取用户列表失败")
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"code":    200,
+		"message": "success",
+		"data": map[string]interface{}{
+			"items": users,
+			"total": total,
+		},
+	})
+}
+
+// GetUser 用户详情（管理员）
+func (h *UserAdminHandler) GetUser(c echo.Context) error {
+	userID, ok := parseUserID(c)
+	if !ok {
+		return nil
+	}
+	detail, err := h.users.GetUserDetail(userID)
+	if err != nil {
+		return userAdminError(c, err, "获取用户失败")
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"code":    200,
+		"message": "success",
+		"data":    detail,
+	})
+}
+
+// ChangeType 修改用户类型（管理员）
+func (h *UserAdminHandler) ChangeType(c echo.Context) error {
+	userID, ok := parseUserID(c)
+	if !ok {
+		return nil
+	}
+	var req struct {
+		Type string `json:"type" validate:"required"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"code":    400,
+			"message": "请求参数错误",
+		})
+	}
+	actor := c.Get("user").(*models.User)
+	user, err := h.users.ChangeType(c.Request().Context(), actor, userID, req.Type)
+	if err != nil {
+		return userAdminError(c, err, "修改用户类型失败")
+	}
+	h.audit.Record(c.Request().Context(), services.AuditEntry{
+		Action:     services.AuditUserTypeChange,
+		TargetType: "user",
+		TargetID:   userID,
+		After:      map[string]string{"type": req.Type},
+	})
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"code":    200,
+		"message": "修改成功",
+		"data":    user,
+	})
+}
+
+// BanUser 封禁用户（管理员）
+func (h *UserAdminHandler) BanUser(c echo.Context) error {
+	userID, ok := parseUserID(c)
+	if !ok {
+		return nil
+	}
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"code":    400,
+			"message": "请求参数错误",
+		})
+	}
+	actor := c.Get("user").(*models.User)
+	user, err := h.users.BanUser(c.Request().Context(), actor, userID, req.Reason)
+	if err != nil {
+		return userAdminError(c, err, "封禁用户失败")
+	}
+	h.audit.Record(c.Request().Context(), services.AuditEntry{
+		Action:     services.AuditUserBan,
+		TargetType: "user",
+		TargetID:   userID,
+		After:      map[string]string{"reason": req.Reason},
+	})
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"code":    200,
+		"message": "封禁成功",
+		"data":    user,
+	})
+}
+
+// UnbanUser 解除封禁（管理员）
+func (h *UserAdminHandler) UnbanUser(c echo.Context) error {
+	userID, ok := parseUserID(c)
+	if !ok {
+		return nil
+	}
+	user, err := h.users.UnbanUser(userID)
+	if err != nil {
+		return userAdminError(c, err, "解除封禁失败")
+	}
+	h.audit.Record(c.Request().Context(), services.AuditEntry{
+		Action:     services.AuditUserUnban,
+		TargetType: "user",
+		TargetID:   userID,
+	})
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"code":    200,
+		"message": "解除封禁成功",
+		"data":    user,
+	})
+}
+
+// ForceLogout 强制用户下线（管理员）
+func (h *UserAdminHandler) ForceLogout(c echo.Context) error {
+	userID, ok := parseUserID(c)
+	if !ok {
+		return nil
+	}
+	if err := h.users.ForceLogout(c.Request().Context(), userID); err != nil {
+		return userAdminError(c, err, "强制下线失败")
+	}
+	h.audit.Record(c.Request().Context(), services.AuditEntry{
+		Action:     services.AuditUserForceLogout,
+		TargetType: "user",
+		TargetID:   userID,
+	})
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"code":    200,
+		"message": "已强制下线",
+	})
+}
+
+// parseUserID 解析路径中的用户ID，返回 false 时已写入错误响应
+func parseUserID(c echo.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"code":    400,
+			"message": "无效的用户ID",
+		})
+		return 0, false
+	}
+	return uint(id), true
+}
+
+func userAdminError(c echo.Context, err error, message string) error {
+	switch {
+	case errors.Is(err, services.ErrUserNotFound):
+		return c.JSON(http.StatusNotFound, map[string]interface{}{
+			"code":    404,
+			"message": "用户不存在",
+		})
+	case errors.Is(err, services.ErrInvalidUserType),
+		errors.Is(err, services.ErrCannotBanSelf),
+		errors.Is(err, services.ErrAlreadyBanned),
+		errors.Is(err, services.ErrNotBanned):
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"code":    400,
+			"message": err.Error(),
+		})
+	default:
+		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"code":    500,
+			"message": message,
+			"error":   err.Error(),
+		})
+	}
+}
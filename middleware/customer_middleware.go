@@ -4,6 +4,7 @@ import (
 	"LiteAdmin/limiter"
 	"LiteAdmin/models"
 	"LiteAdmin/services"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
@@ -12,62 +13,153 @@ import (
 	"github.com/labstack/echo/v4"
 )
 
+// APIKeyHeader 第三方集成使用的 API 密钥请求头
+const APIKeyHeader = "X-API-Key"
+
+// AuthMiddleware 校验登录令牌。API 密钥请求一律拒绝：密钥只能访问用 RequirePermission 声明了权限、
+// 且密钥 scopes 包含该权限的接口
 func AuthMiddleware(authService *services.AuthService) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
-			authHeader := c.Request().Header.Get("Authorization")
-			var tokenString string
-			if authHeader != "" {
-				parts := strings.Split(authHeader, " ")
-				if len(parts) != 2 || parts[0] != "Bearer" {
-					return c.JSON(http.StatusUnauthorized, map[string]string{
-						"error": "invalid authorization header",
-					})
-				}
-				tokenString = parts[1]
-			} else {
-				tokenString = c.QueryParam("token")
-				if tokenString == "" {
-					return c.JSON(http.StatusUnauthorized, map[string]string{
-						"error": "missing authorization token",
-					})
-				}
-				tokenString = strings.TrimSpace(strings.TrimPrefix(tokenString, "Bearer "))
-			}
-
-			claims, err := authService.ValidateToken(tokenString)
-			if err != nil {
-				return c.JSON(http.StatusUnauthorized, map[string]string{
-					"error": "invalid token",
+			if c.Request().Header.Get(APIKeyHeader) != "" {
+				return c.JSON(http.StatusForbidden, map[string]string{
+					"error": "api keys cannot access this endpoint",
 				})
 			}
-			var user models.User
-			if err := authService.Db.First(&user, claims.UserID).Error; err != nil {
-				return c.JSON(http.StatusNotFound, map[string]string{
-					"error": "user not found",
-				})
+			user, err := authenticateToken(c, authService)
+			if user == nil {
+				return err
 			}
+			setUser(c, user)
+			return next(c)
+		}
+	}
+}
+
+// authenticateToken 校验 Authorization 头或 token 查询参数中的令牌，失败时写入错误响应并返回 nil 用户
+func authenticateToken(c echo.Context, authService *services.AuthService) (*models.User, error) {
+	authHeader := c.Request().Header.Get("Authorization")
+	var tokenString string
+	if authHeader != "" {
+		parts := strings.Split(authHeader, " ")
+		if len(parts) != 2 || parts[0] != "Bearer" {
+			return nil, c.JSON(http.StatusUnauthorized, map[string]string{
+				"error": "invalid authorization header",
+			})
+		}
+		tokenString = parts[1]
+	} else {
+		tokenString = c.QueryParam("token")
+		if tokenString == "" {
+			return nil, c.JSON(http.StatusUnauthorized, map[string]string{
+				"error": "missing authorization token",
+			})
+		}
+		tokenString = strings.TrimSpace(strings.TrimPrefix(tokenString, "Bearer "))
+	}
+
+	user, err := authService.AuthenticateToken(tokenString)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrUserNotFound):
+			return nil, c.JSON(http.StatusNotFound, map[string]string{
+				"error": "user not found",
+			})
+		case errors.Is(err, services.ErrTokenRevoked):
+			return nil, c.JSON(http.StatusUnauthorized, map[string]string{
+				"error": "token revoked",
+			})
+		case errors.Is(err, services.ErrUserBanned):
+			return nil, c.JSON(http.StatusForbidden, map[string]string{
+				"error": err.Error(),
+			})
+		}
+		return nil, c.JSON(http.StatusUnauthorized, map[string]string{
+			"error": "invalid token",
+		})
+	}
+	return user, nil
+}
 
-			c.Set("user", &user)
+// authenticateAPIKey 校验 API 密钥，失败时写入错误响应并返回 nil 用户
+func authenticateAPIKey(c echo.Context, apiKeys *services.APIKeyService, rawKey string) (*models.User, *models.APIKey, error) {
+	user, key, err := apiKeys.Authenticate(rawKey)
+	if err != nil {
+		if errors.Is(err, services.ErrAPIKeyInvalid) {
+			return nil, nil, c.JSON(http.StatusUnauthorized, map[string]string{
+				"error": "invalid api key",
+			})
+		}
+		if errors.Is(err, services.ErrUserBanned) {
+			return nil, nil, c.JSON(http.StatusForbidden, map[string]string{
+				"error": err.Error(),
+			})
+		}
+		c.Logger().Errorf("API key auth error: %v", err)
+		return nil, nil, c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "failed to verify api key",
+		})
+	}
+	return user, key, nil
+}
+
+// setUser 保存当前用户，并写入请求 context 供审计日志记录操作者
+func setUser(c echo.Context, user *models.User) {
+	c.Set("user", user)
+	c.SetRequest(c.Request().WithContext(services.WithAuditActor(c.Request().Context(), user)))
+}
+
+// AuditRequest 把请求来源（IP、User-Agent）写入 context，供审计日志使用
+func AuditRequest() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			ctx := services.WithAuditRequest(c.Request().Context(), services.AuditRequest{
+				IP:        c.RealIP(),
+				UserAgent: c.Request().UserAgent(),
+			})
+			c.SetRequest(c.Request().WithContext(ctx))
 			return next(c)
 		}
 	}
 }
 
-func AdminAuthMiddleware() echo.MiddlewareFunc {
+// RequirePermission 要求当前用户拥有指定权限，如 RequirePermission(auth, apiKeys, rbac, "category:write")。
+// 路由组未经 AuthMiddleware 认证时在这里认证，此时也接受 API 密钥，权限为用户权限与密钥 scopes 的交集
+func RequirePermission(authService *services.AuthService, apiKeys *services.APIKeyService, rbac *services.RBACService, permission string) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
 			user, ok := c.Get("user").(*models.User)
 			if !ok {
-				return c.JSON(http.StatusUnauthorized, map[string]interface{}{
-					"code":    401,
-					"message": "未授权访问",
+				var err error
+				if rawKey := c.Request().Header.Get(APIKeyHeader); rawKey != "" {
+					var key *models.APIKey
+					if user, key, err = authenticateAPIKey(c, apiKeys, rawKey); user == nil {
+						return err
+					}
+					c.Set("api_key", key)
+				} else if user, err = authenticateToken(c, authService); user == nil {
+					return err
+				}
+				setUser(c, user)
+			}
+			allowed, err := rbac.HasPermission(c.Request().Context(), user, permission)
+			if err != nil {
+				c.Logger().Errorf("Permission check error: %v", err)
+				return c.JSON(http.StatusInternalServerError, map[string]interface{}{
+					"code":    500,
+					"message": "权限校验失败",
+				})
+			}
+			if !allowed {
+				return c.JSON(http.StatusForbidden, map[string]interface{}{
+					"code":    403,
+					"message": "缺少权限: " + permission,
 				})
 			}
-			if user.Type != "admin" {
+			if key, ok := c.Get("api_key").(*models.APIKey); ok && !key.HasScope(permission) {
 				return c.JSON(http.StatusForbidden, map[string]interface{}{
 					"code":    403,
-					"message": "需要管理员权限",
+					"message": "API 密钥未授权: " + permission,
 				})
 			}
 			return next(c)
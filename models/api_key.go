@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+// APIKey 用户为第三方集成（如商家 ERP）创建的 API 密钥
+// 明文格式 shk_<prefix>_<secret>，服务端按 prefix 查找并只保存整串的哈希
+type APIKey struct {
+	ID         uint       `json:"id" gorm:"primaryKey"`
+	UserID     uint       `json:"user_id" gorm:"index;not null"`
+	Name       string     `json:"name" gorm:"type:varchar(100);not null"`
+	Prefix     string     `json:"prefix" gorm:"type:varchar(16);uniqueIndex;not null"`
+	KeyHash    string     `json:"-" gorm:"type:varchar(64);not null"`      // sha256(key)
+	Scopes     []string   `json:"scopes" gorm:"type:text;serializer:json"` // 权限码子集，如 pets:write
+	ExpiresAt  *time.Time `json:"expires_at"`                              // 为空表示不过期
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at" gorm:"index"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// HasScope 密钥是否授予了指定权限
+func (k *APIKey) HasScope(permission string) bool {
+	for _, scope := range k.Scopes {
+		if scope == permission {
+			return true
+		}
+	}
+	return false
+}
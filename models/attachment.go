@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// 附件类型，同时作为携带附件的 Message.Type
+const (
+	AttachmentImage = "image"
+	AttachmentFile  = "file"
+	AttachmentAudio = "audio"
+)
+
+// Attachment 聊天附件，上传后先处于未发送状态，随消息发送时绑定 MessageID
+type Attachment struct {
+	ID           uint      `json:"id" gorm:"primaryKey"`
+	RoomID       string    `json:"room_id" gorm:"index;not null"` // 与 Message.RoomID 一致
+	UserID       uint      `json:"user_id" gorm:"index"`
+	MessageID    *uint     `json:"message_id" gorm:"index"`
+	Kind         string    `json:"kind" gorm:"type:varchar(10)"` // image / file / audio
+	Filename     string    `json:"filename" gorm:"type:varchar(255)"`
+	ContentType  string    `json:"content_type" gorm:"type:varchar(100)"`
+	Size         int64     `json:"size"`
+	StorageKey   string    `json:"-"`
+	ThumbnailKey string    `json:"-"`
+	Width        int       `json:"width,omitempty"`  // 图片尺寸
+	Height       int       `json:"height,omitempty"` // 图片尺寸
+	CreatedAt    time.Time `json:"created_at"`
+}
@@ -0,0 +1,47 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrAuditLogImmutable 审计日志只允许追加
+var ErrAuditLogImmutable = errors.New("audit logs are append-only")
+
+// AuditLog 特权与敏感操作的审计记录，只追加不修改
+type AuditLog struct {
+	ID         uint                   `json:"id" gorm:"primaryKey"`
+	ActorID    uint                   `json:"actor_id" gorm:"index"` // 0 表示匿名（如登录失败）
+	ActorName  string                 `json:"actor_name" gorm:"type:varchar(255)"`
+	Action     string                 `json:"action" gorm:"type:varchar(100);index;not null"` // 资源.操作，如 category.update
+	TargetType string                 `json:"target_type" gorm:"type:varchar(50);index:idx_audit_target"`
+	TargetID   string                 `json:"target_id" gorm:"type:varchar(100);index:idx_audit_target"`
+	Before     map[string]interface{} `json:"before,omitempty" gorm:"type:text;serializer:json"` // 只包含变化的字段
+	After      map[string]interface{} `json:"after,omitempty" gorm:"type:text;serializer:json"`
+	IP         string                 `json:"ip" gorm:"type:varchar(64)"`
+	UserAgent  string                 `json:"user_agent" gorm:"type:varchar(500)"`
+	CreatedAt  time.Time              `json:"created_at" gorm:"index"`
+}
+
+func (AuditLog) BeforeUpdate(*gorm.DB) error { return ErrAuditLogImmutable }
+
+func (AuditLog) BeforeDelete(*gorm.DB) error { return ErrAuditLogImmutable }
+
+// protectAuditLogs 在 Postgres 上用触发器禁止 UPDATE/DELETE，绕过 ORM 的修改同样会被拒绝
+func protectAuditLogs(db *gorm.DB) error {
+	if db.Dialector.Name() != "postgres" {
+		return nil
+	}
+	return db.Exec(`
+		CREATE OR REPLACE FUNCTION audit_logs_immutable() RETURNS trigger AS $$
+		BEGIN
+			RAISE EXCEPTION 'audit_logs is append-only';
+		END;
+		$$ LANGUAGE plpgsql;
+		DROP TRIGGER IF EXISTS audit_logs_immutable ON audit_logs;
+		CREATE TRIGGER audit_logs_immutable BEFORE UPDATE OR DELETE ON audit_logs
+			FOR EACH ROW EXECUTE FUNCTION audit_logs_immutable();
+	`).Error
+}
@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// CannedResponse 客服快捷回复模板，MerchantID 为空表示全局模板；
+// 内容中的 {{变量名}} 在发送时由服务端替换
+type CannedResponse struct {
+	ID         uint      `json:"id" gorm:"primaryKey"`
+	MerchantID *uint     `json:"merchant_id" gorm:"index"`
+	Shortcut   string    `json:"shortcut" gorm:"type:varchar(32);index;not null"` // 快捷码，同一范围内唯一
+	Title      string    `json:"title" gorm:"type:varchar(100);not null"`
+	Content    string    `json:"content" gorm:"type:text;not null"`
+	UsageCount int       `json:"usage_count" gorm:"not null;default:0"`
+	CreatedBy  uint      `json:"created_by"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
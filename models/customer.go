@@ -12,6 +12,19 @@ type CustomerSession struct {
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
 	SessionType uint `json:"session_type"` // admin 0,customer 1
+	// 分配：排队中的会话 AgentID 为空，按 QueuedAt 先后分配
+	AgentID    *uint      `json:"agent_id" gorm:"index"`
+	AssignedAt *time.Time `json:"assigned_at"`
+	QueuedAt   *time.Time `json:"queued_at" gorm:"index"`
+	ReleasedBy *uint      `json:"-"` // 主动释放会话的客服，重新分配时跳过
+	// SLA：首次响应和解决时长从 QueuedAt（旧数据为 CreatedAt）起算，会话重新打开时重新计时
+	FirstResponseAt       *time.Time `json:"first_response_at"` // 客服首次回复的时间
+	LastActivityAt        *time.Time `json:"last_activity_at"`  // 最后一条消息的时间，用于自动关闭
+	EscalatedAt           *time.Time `json:"escalated_at"`      // 首次响应超时后升级，排队时优先分配
+	ClosedAt              *time.Time `json:"closed_at"`
+	FirstResponseBreached bool       `json:"first_response_breached" gorm:"default:false"`
+	ResolutionBreached    bool       `json:"resolution_breached" gorm:"default:false"`
 	// 关联
-	User User `json:"user" gorm:"foreignKey:UserID"`
+	User  User  `json:"user" gorm:"foreignKey:UserID"`
+	Agent *User `json:"agent,omitempty" gorm:"foreignKey:AgentID"`
 }
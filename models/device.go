@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// DeviceToken 长期登录设备令牌（记住我），服务端只保存令牌的哈希
+type DeviceToken struct {
+	ID         uint      `json:"id" gorm:"primaryKey"`
+	UserID     uint      `json:"user_id" gorm:"index;not null"`
+	TokenHash  string    `json:"-" gorm:"type:varchar(64);uniqueIndex;not null"` // sha256(token)
+	DeviceName string    `json:"device_name" gorm:"type:varchar(100)"`
+	UserAgent  string    `json:"user_agent" gorm:"type:varchar(500)"`
+	IP         string    `json:"ip" gorm:"type:varchar(64)"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at" gorm:"index"`
+	CreatedAt  time.Time `json:"created_at"`
+	Current    bool      `json:"current" gorm:"-"` // 是否为当前请求所用设备
+}
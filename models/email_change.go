@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// EmailChangeRequest 待确认的邮箱变更，新邮箱点击确认链接后才生效
+type EmailChangeRequest struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	UserID    uint      `json:"user_id" gorm:"uniqueIndex;not null"` // 每个用户只保留最近一次申请
+	NewEmail  string    `json:"new_email" gorm:"not null"`
+	TokenHash string    `json:"-" gorm:"type:varchar(64);uniqueIndex;not null"` // sha256(token)
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
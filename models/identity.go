@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// UserIdentity 用户绑定的第三方登录身份，一个用户可绑定多个 provider
+type UserIdentity struct {
+	ID            uint      `json:"id" gorm:"primaryKey"`
+	UserID        uint      `json:"user_id" gorm:"index;not null"`
+	Provider      string    `json:"provider" gorm:"type:varchar(50);uniqueIndex:idx_identity_provider;not null"`
+	ProviderID    string    `json:"provider_id" gorm:"type:varchar(255);uniqueIndex:idx_identity_provider;not null"`
+	Email         string    `json:"email"`
+	EmailVerified bool      `json:"email_verified"` // provider 是否声明邮箱已验证
+	Name          string    `json:"name"`
+	Avatar        string    `json:"avatar"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
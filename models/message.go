@@ -3,12 +3,27 @@ package models
 import "time"
 
 type Message struct {
-	ID        uint      `json:"id" gorm:"primaryKey"`
-	RoomID    string    `json:"room_id"`
-	UserID    uint      `json:"user_id"`
-	Content   string    `json:"content" gorm:"type:text"`
-	Type      string    `json:"type"`
+	ID           uint       `json:"id" gorm:"primaryKey;index:idx_messages_room_id_id,priority:2"`
+	RoomID       string     `json:"room_id" gorm:"index:idx_messages_room_id_id,priority:1"` // 历史消息游标分页和未读数统计都按 (room_id, id) 范围扫描
+	UserID       uint       `json:"user_id"`
+	Content      string     `json:"content" gorm:"type:text"`
+	Type         string     `json:"type"`
+	CreatedAt    time.Time  `json:"created_at"`
+	ParentID     *uint      `json:"parent_id" gorm:"index"`                // 所属话题的首条消息，话题只有一层
+	AttachmentID *uint      `json:"attachment_id"`                         // Type 为 image/file/audio 时的附件
+	ReplyCount   int        `json:"reply_count" gorm:"not null;default:0"` // 话题回复数（仅首条消息）
+	LastReplyAt  *time.Time `json:"last_reply_at"`                         // 最后回复时间（仅首条消息）
+	EditedAt     *time.Time `json:"edited_at"`                             // 最后一次编辑时间，未编辑为空
+	DeletedAt    *time.Time `json:"deleted_at"`                            // 删除后保留为墓碑记录，内容被清空
+	DeletedBy    uint       `json:"deleted_by,omitempty"`
+	Username     string     `json:"username" gorm:"-"`
+	UserColor    string     `json:"user_color" gorm:"-"`
+}
+
+// MessageReaction 用户对消息的表情回应，同一用户对同一消息的同一表情只记录一次
+type MessageReaction struct {
+	MessageID uint      `json:"message_id" gorm:"primaryKey"`
+	UserID    uint      `json:"user_id" gorm:"primaryKey"`
+	Emoji     string    `json:"emoji" gorm:"primaryKey;type:varchar(32)"`
 	CreatedAt time.Time `json:"created_at"`
-	Username  string    `json:"username" gorm:"-"`
-	UserColor string    `json:"user_color" gorm:"-"`
 }
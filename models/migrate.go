@@ -7,7 +7,13 @@ func AutoMigrateAll(db *gorm.DB) error {
 		&User{},
 		&Room{},
 		&Message{},
+		&MessageReaction{},
+		&RoomReadState{},
+		&Attachment{},
 		&CustomerSession{},
+		&SupportAgent{},
+		&SLABreach{},
+		&CannedResponse{},
 		&MerchantInfo{},
 		&PetCategory{},
 		&Pet{},
@@ -22,9 +28,24 @@ func AutoMigrateAll(db *gorm.DB) error {
 		&Favorite{},
 		&Cart{},
 		&MerchantFollow{},
+		&DeviceToken{},
+		&Permission{},
+		&Role{},
+		&UserRole{},
+		&UserIdentity{},
+		&SigningKey{},
+		&APIKey{},
+		&EmailChangeRequest{},
+		&AuditLog{},
+		&RoomMember{},
+		&RoomInvite{},
+		&RoomRestriction{},
 	)
 	if err != nil {
 		return err
 	}
-	return nil
+	if err := protectAuditLogs(db); err != nil {
+		return err
+	}
+	return SeedRBAC(db)
 }
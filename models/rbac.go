@@ -0,0 +1,114 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 权限编码，格式为 资源:操作
+const (
+	PermCategoryWrite            = "category:write"             // 管理商品分类
+	PermRoomDeleteAny            = "room:delete:any"            // 删除任意房间
+	PermCustomerServiceHandle    = "customer_service:handle"    // 处理客服会话
+	PermCustomerServiceSupervise = "customer_service:supervise" // 进入任意客服会话（主管）
+	PermUserManage               = "user:manage"                // 管理用户
+	PermRoleManage               = "role:manage"                // 分配角色
+	PermPetsWrite                = "pets:write"                 // 维护商品
+	PermOrdersRead               = "orders:read"                // 查看订单
+	PermAuditRead                = "audit:read"                 // 查看审计日志
+)
+
+// 内置角色，名称与 User.Type 保持一致
+const (
+	RoleAdmin    = "admin"
+	RoleMerchant = "merchant"
+	RoleClient   = "client"
+	RoleSupport  = "support"
+)
+
+// 权限表
+type Permission struct {
+	ID          uint      `json:"id" gorm:"primaryKey"`
+	Code        string    `json:"code" gorm:"type:varchar(100);uniqueIndex;not null"`
+	Description string    `json:"description" gorm:"type:varchar(255)"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// 角色表
+type Role struct {
+	ID          uint         `json:"id" gorm:"primaryKey"`
+	Name        string       `json:"name" gorm:"type:varchar(50);uniqueIndex;not null"`
+	Description string       `json:"description" gorm:"type:varchar(255)"`
+	Permissions []Permission `json:"permissions,omitempty" gorm:"many2many:role_permissions;"`
+	CreatedAt   time.Time    `json:"created_at"`
+	UpdatedAt   time.Time    `json:"updated_at"`
+}
+
+// 用户角色关联表（User.Type 对应的角色是隐式的，这里只记录额外分配的角色）
+type UserRole struct {
+	UserID    uint      `json:"user_id" gorm:"primaryKey"`
+	RoleID    uint      `json:"role_id" gorm:"primaryKey;index"`
+	CreatedAt time.Time `json:"created_at"`
+	Role      Role      `json:"role" gorm:"foreignKey:RoleID"`
+}
+
+var defaultPermissions = map[string]string{
+	PermCategoryWrite:            "管理商品分类",
+	PermRoomDeleteAny:            "删除任意房间",
+	PermCustomerServiceHandle:    "处理客服会话",
+	PermCustomerServiceSupervise: "进入任意客服会话",
+	PermUserManage:               "管理用户",
+	PermRoleManage:               "分配角色",
+	PermPetsWrite:                "维护商品",
+	PermOrdersRead:               "查看订单",
+	PermAuditRead:                "查看审计日志",
+}
+
+var defaultRoles = []struct {
+	Name        string
+	Description string
+	Permissions []string
+}{
+	{RoleAdmin, "平台管理员", nil}, // nil 表示拥有全部权限
+	{RoleMerchant, "商家", []string{PermPetsWrite, PermOrdersRead}},
+	{RoleClient, "客户", []string{}},
+	{RoleSupport, "客服", []string{PermCustomerServiceHandle}},
+}
+
+// SeedRBAC 初始化内置角色与权限（幂等，只补充缺失的数据）
+func SeedRBAC(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		perms := make(map[string]Permission, len(defaultPermissions))
+		for code, desc := range defaultPermissions {
+			perm := Permission{Code: code}
+			if err := tx.Where(Permission{Code: code}).Attrs(Permission{Description: desc}).FirstOrCreate(&perm).Error; err != nil {
+				return err
+			}
+			perms[code] = perm
+		}
+		for _, def := range defaultRoles {
+			role := Role{Name: def.Name}
+			if err := tx.Where(Role{Name: def.Name}).Attrs(Role{Description: def.Description}).FirstOrCreate(&role).Error; err != nil {
+				return err
+			}
+			grant := make([]Permission, 0, len(perms))
+			if def.Permissions == nil {
+				for _, perm := range perms {
+					grant = append(grant, perm)
+				}
+			} else {
+				for _, code := range def.Permissions {
+					grant = append(grant, perms[code])
+				}
+			}
+			if len(grant) == 0 {
+				continue
+			}
+			if err := tx.Model(&role).Association("Permissions").Append(grant); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -12,6 +12,7 @@ type Room struct {
 	Language    string    `json:"language,omitempty"` // 仅 code 类型有
 	OwnerID     uint      `json:"owner_id"`
 	IsActive    bool      `json:"is_active"`
+	SlowMode    int       `json:"slow_mode"` // 慢速模式：每个用户两次发言的最小间隔（秒），0 表示关闭
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
 }
@@ -20,4 +21,5 @@ type RoomWithUser struct {
 	Room
 	OwnerName   string `json:"owner_name" gorm:"column:username"` 
 	OnlineUsers uint   `json:"online_users"`
+	UnreadCount int64  `json:"unread_count" gorm:"-"`
 }
@@ -0,0 +1,40 @@
+package models
+
+import "time"
+
+// 房间内角色
+const (
+	RoomRoleOwner     = "owner"
+	RoomRoleModerator = "moderator"
+	RoomRoleMember    = "member"
+)
+
+// 房间隐私设置
+const (
+	RoomPrivacyPublic   = "public"   // 所有人可见、可进入
+	RoomPrivacyPassword = "password" // 所有人可见，输入密码后成为成员
+	RoomPrivacyPrivate  = "private"  // 仅成员可见，通过邀请链接加入
+)
+
+// RoomMember 房间成员，房主（Room.OwnerID）即使没有记录也视为 owner
+type RoomMember struct {
+	RoomID    uint      `json:"room_id" gorm:"primaryKey"`
+	UserID    uint      `json:"user_id" gorm:"primaryKey;index"`
+	Role      string    `json:"role" gorm:"type:varchar(20);not null;default:'member'"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	User      User      `json:"user" gorm:"foreignKey:UserID"`
+}
+
+// RoomInvite 房间邀请链接
+type RoomInvite struct {
+	ID        uint       `json:"id" gorm:"primaryKey"`
+	RoomID    uint       `json:"room_id" gorm:"index;not null"`
+	Code      string     `json:"code" gorm:"type:varchar(64);uniqueIndex;not null"`
+	CreatedBy uint       `json:"created_by"`
+	ExpiresAt *time.Time `json:"expires_at"`              // 为空表示不过期
+	MaxUses   int        `json:"max_uses"`                // 0 表示不限次数
+	Uses      int        `json:"uses" gorm:"default:0"`   // 已使用次数
+	RevokedAt *time.Time `json:"revoked_at" gorm:"index"` // 非空表示已撤销
+	CreatedAt time.Time  `json:"created_at"`
+}
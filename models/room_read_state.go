@@ -0,0 +1,11 @@
+package models
+
+import "time"
+
+// RoomReadState 用户在聊天室中最后已读的消息，RoomID 与 Message.RoomID 一致（包括客服会话）
+type RoomReadState struct {
+	UserID            uint      `json:"user_id" gorm:"primaryKey"`
+	RoomID            string    `json:"room_id" gorm:"primaryKey;type:varchar(64)"`
+	LastReadMessageID uint      `json:"last_read_message_id"`
+	UpdatedAt         time.Time `json:"updated_at"`
+}
@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// 房间处罚类型
+const (
+	RestrictionBan  = "ban"  // 禁止进入房间
+	RestrictionMute = "mute" // 禁止发言
+)
+
+// RoomRestriction 房间内对用户的封禁/禁言记录，ExpiresAt 为空表示永久
+type RoomRestriction struct {
+	ID        uint       `json:"id" gorm:"primaryKey"`
+	RoomID    uint       `json:"room_id" gorm:"index:idx_room_restriction;not null"`
+	UserID    uint       `json:"user_id" gorm:"index:idx_room_restriction;not null"`
+	Type      string     `json:"type" gorm:"type:varchar(10);index:idx_room_restriction;not null"`
+	Reason    string     `json:"reason" gorm:"type:varchar(255)"`
+	CreatedBy uint       `json:"created_by"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	LiftedAt  *time.Time `json:"lifted_at"` // 提前解除的时间
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// Active 处罚当前是否生效
+func (r *RoomRestriction) Active(now time.Time) bool {
+	return r.LiftedAt == nil && (r.ExpiresAt == nil || now.Before(*r.ExpiresAt))
+}
@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// SigningKey JWT 签名密钥，多个节点共享同一组密钥
+// 生命周期：CreatedAt 起公开到 JWKS，ActivatesAt 起用于签名，
+// 被下一把密钥替换后继续用于验签直到 ExpiresAt
+type SigningKey struct {
+	ID          uint       `json:"id" gorm:"primaryKey"`
+	Kid         string     `json:"kid" gorm:"type:varchar(64);uniqueIndex;not null"`
+	Algorithm   string     `json:"algorithm" gorm:"type:varchar(20);not null"` // RS256, EdDSA
+	PrivateKey  string     `json:"-" gorm:"type:text;not null"`                // PKCS#8 PEM
+	ActivatesAt time.Time  `json:"activates_at" gorm:"index"`
+	ExpiresAt   *time.Time `json:"expires_at" gorm:"index"` // 为空表示仍是最新密钥
+	CreatedAt   time.Time  `json:"created_at"`
+}
@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// SLA 类型
+const (
+	SLAFirstResponse = "first_response" // 首次响应超时
+	SLAResolution    = "resolution"     // 解决超时
+)
+
+// SLABreach 客服会话的 SLA 超时记录，按客服统计；排队中超时的会话 AgentID 为空
+type SLABreach struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	SessionID uint      `json:"session_id" gorm:"index;not null"`
+	AgentID   *uint     `json:"agent_id" gorm:"index"`
+	Type      string    `json:"type" gorm:"type:varchar(20);not null"`
+	Target    int       `json:"target"` // SLA 目标（秒）
+	CreatedAt time.Time `json:"created_at" gorm:"index"`
+}
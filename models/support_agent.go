@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+// 客服在线状态：只有 online 的客服会被分配新会话；away 保留已接入的会话，offline 时会话退回排队
+const (
+	AgentStatusOnline  = "online"
+	AgentStatusAway    = "away"
+	AgentStatusOffline = "offline"
+)
+
+// 客服会话状态
+const (
+	SessionStatusPending = "pending" // 排队等待客服接入
+	SessionStatusActive  = "active"  // 已分配客服
+	SessionStatusClosed  = "closed"
+)
+
+// SupportAgent 客服接待设置，客服第一次上线时创建
+type SupportAgent struct {
+	UserID         uint       `json:"user_id" gorm:"primaryKey"`
+	Status         string     `json:"status" gorm:"type:varchar(10);index;not null;default:'offline'"`
+	MaxConcurrent  int        `json:"max_concurrent" gorm:"not null"` // 同时接待的会话上限
+	LastAssignedAt *time.Time `json:"last_assigned_at"`               // 轮询分配按该时间排序
+	ActiveSessions int        `json:"active_sessions" gorm:"-"`       // 当前接待的会话数，查询时填充
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+
+	User User `json:"user" gorm:"foreignKey:UserID"`
+}
@@ -3,17 +3,21 @@ package models
 import "time"
 
 type User struct {
-	ID           uint          `json:"id" gorm:"primaryKey"`
-	Email        string        `json:"email" gorm:"uniqueIndex"`
-	Username     string        `json:"username" gorm:"uniqueIndex"`
-	Password     string        `json:"-"`        // For local auth, hashed
-	Provider     string        `json:"provider"` // google, github, facebook, local, custom
-	ProviderID   string        `json:"provider_id"`
-	Type         string        `json:"type"` // admin，merchant(商家),client(客户)
-	Avatar       string        `json:"avatar"`
-	CreatedAt    time.Time     `json:"created_at"`
-	UpdatedAt    time.Time     `json:"updated_at"`
-	MerchantInfo *MerchantInfo `gorm:"foreignKey:UserID" json:"merchant_info,omitempty"`
+	ID            uint          `json:"id" gorm:"primaryKey"`
+	Email         string        `json:"email" gorm:"uniqueIndex"`
+	EmailVerified bool          `json:"email_verified" gorm:"not null;default:false"` // 本地注册不验证邮箱；第三方登录声明已验证或确认过邮箱变更后为 true
+	Username      string        `json:"username" gorm:"uniqueIndex"`
+	Password      string        `json:"-"`        // For local auth, hashed
+	Provider      string        `json:"provider"` // google, github, facebook, local, custom
+	ProviderID    string        `json:"provider_id"`
+	Type          string        `json:"type"` // admin，merchant(商家),client(客户)
+	Avatar        string        `json:"avatar"`
+	TokenVersion  uint          `json:"-" gorm:"not null;default:0"`      // 递增后之前签发的令牌全部失效
+	BannedAt      *time.Time    `json:"banned_at,omitempty" gorm:"index"` // 非空表示已封禁
+	BanReason     string        `json:"ban_reason,omitempty"`
+	CreatedAt     time.Time     `json:"created_at"`
+	UpdatedAt     time.Time     `json:"updated_at"`
+	MerchantInfo  *MerchantInfo `gorm:"foreignKey:UserID" json:"merchant_info,omitempty"`
 }
 
 type AuthResponse struct {
@@ -0,0 +1,401 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// 在线状态以连接为单位记录，所有房间类型共用同一套键：
+//
+//	presence:room:<roomID>   ZSET  <userID>:<connID> -> 心跳截止时间（毫秒）
+//	presence:user:<userID>   ZSET  <connID> -> 心跳截止时间，用于跨房间的“用户在线”状态
+//	presence:conn:<connID>   HASH  user_id, username, room_id, node_id, device, connected_at
+//	presence:node:<nodeID>   SET   节点持有的连接
+//	presence:nodes           ZSET  <nodeID> -> 节点心跳截止时间
+//	presence:rooms           SET   有在线记录的房间，供清理任务遍历
+//	presence:last_seen       HASH  <userID> -> 最后一个连接断开的时间（Unix 秒）
+//
+// 读取时只统计截止时间未过的成员，节点宕机或连接停止心跳后即使尚未清理也不会显示为在线。
+const (
+	PresenceHeartbeat = 15 * time.Second      // 节点刷新自身和本节点连接心跳的间隔
+	NodeTTL           = 3 * PresenceHeartbeat // 节点超过该时间没有心跳视为宕机，由其他节点清理它的连接
+	ConnectionTTL     = 90 * time.Second      // 连接超过该时间没有心跳（WebSocket pong 或消息）视为离线，需大于 ping 间隔
+	sweepInterval     = 30 * time.Second
+
+	presenceNodesKey    = "presence:nodes"
+	presenceRoomsKey    = "presence:rooms"
+	presenceLastSeenKey = "presence:last_seen"
+	presenceSweepLock   = "presence:sweeper"
+)
+
+func presenceRoomKey(roomID string) string { return "presence:room:" + roomID }
+func presenceUserKey(userID uint) string   { return fmt.Sprintf("presence:user:%d", userID) }
+func presenceConnKey(connID string) string { return "presence:conn:" + connID }
+func presenceNodeKey(nodeID string) string { return "presence:node:" + nodeID }
+
+func roomMember(userID uint, connID string) string {
+	return fmt.Sprintf("%d:%s", userID, connID)
+}
+
+// Connection 一个 WebSocket 连接的在线信息
+type Connection struct {
+	ID       string
+	RoomID   string
+	UserID   uint
+	Username string
+	Device   string // 客户端类型（web、ios、android 等），由客户端连接时声明
+}
+
+// UserPresence 用户跨房间的在线状态
+type UserPresence struct {
+	UserID   uint       `json:"user_id"`
+	Online   bool       `json:"online"`
+	Devices  []string   `json:"devices"`             // 每个在线连接的客户端类型，同一设备类型可能出现多次
+	LastSeen *time.Time `json:"last_seen,omitempty"` // 离线时为最后一个连接断开的时间
+}
+
+type trackedConn struct {
+	Connection
+	connectedAt int64
+	lastSeen    atomic.Int64 // 最近一次心跳（Unix 毫秒）
+}
+
+// Presence 记录本节点持有的连接，定期刷新心跳并清理宕机节点留下的连接
+type Presence struct {
+	client *redis.Client
+	nodeID string
+	mu     sync.RWMutex
+	conns  map[string]*trackedConn
+}
+
+func NewPresence(client *redis.Client, nodeID string) *Presence {
+	return &Presence{client: client, nodeID: nodeID, conns: make(map[string]*trackedConn)}
+}
+
+// Run 定期发送节点心跳、刷新本节点连接并执行清理，ctx 取消后退出
+func (p *Presence) Run(ctx context.Context) {
+	p.heartbeat(ctx)
+	heartbeat := time.NewTicker(PresenceHeartbeat)
+	sweep := time.NewTicker(sweepInterval)
+	defer heartbeat.Stop()
+	defer sweep.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-heartbeat.C:
+			p.heartbeat(ctx)
+		case <-sweep.C:
+			p.sweep(ctx)
+		}
+	}
+}
+
+// Add 记录新连接，立即对其他节点可见
+func (p *Presence) Add(ctx context.Context, conn Connection) error {
+	now := time.Now()
+	tracked := &trackedConn{Connection: conn, connectedAt: now.Unix()}
+	tracked.lastSeen.Store(now.UnixMilli())
+	p.mu.Lock()
+	p.conns[conn.ID] = tracked
+	p.mu.Unlock()
+
+	pipe := p.client.TxPipeline()
+	p.refresh(ctx, pipe, tracked)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// Touch 连接的心跳（收到 pong 或消息），只更新本地时间，由下一次节点心跳批量写入 Redis
+func (p *Presence) Touch(connID string) {
+	p.mu.RLock()
+	tracked, ok := p.conns[connID]
+	p.mu.RUnlock()
+	if ok {
+		tracked.lastSeen.Store(time.Now().UnixMilli())
+	}
+}
+
+// Remove 连接断开时删除在线记录
+func (p *Presence) Remove(ctx context.Context, connID string) error {
+	p.mu.Lock()
+	tracked, ok := p.conns[connID]
+	delete(p.conns, connID)
+	p.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	pipe := p.client.TxPipeline()
+	removeConnection(ctx, pipe, tracked.ID, tracked.RoomID, tracked.UserID, p.nodeID)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// refresh 写入连接的全部记录，截止时间为最近一次心跳加 ConnectionTTL；
+// 每次心跳都完整写入，Redis 短暂不可用或节点被误判宕机清理后能自动恢复
+func (p *Presence) refresh(ctx context.Context, pipe redis.Pipeliner, conn *trackedConn) {
+	deadline := float64(conn.lastSeen.Load() + ConnectionTTL.Milliseconds())
+	pipe.HSet(ctx, presenceConnKey(conn.ID),
+		"user_id", conn.UserID,
+		"username", conn.Username,
+		"room_id", conn.RoomID,
+		"node_id", p.nodeID,
+		"device", conn.Device,
+		"connected_at", conn.connectedAt,
+	)
+	pipe.SAdd(ctx, presenceNodeKey(p.nodeID), conn.ID)
+	pipe.SAdd(ctx, presenceRoomsKey, conn.RoomID)
+	pipe.ZAdd(ctx, presenceRoomKey(conn.RoomID), redis.Z{Score: deadline, Member: roomMember(conn.UserID, conn.ID)})
+	pipe.ZAdd(ctx, presenceUserKey(conn.UserID), redis.Z{Score: deadline, Member: conn.ID})
+	pipe.Expire(ctx, presenceRoomKey(conn.RoomID), ConnectionTTL)
+	pipe.Expire(ctx, presenceUserKey(conn.UserID), ConnectionTTL)
+	pipe.Expire(ctx, presenceConnKey(conn.ID), ConnectionTTL)
+}
+
+// heartbeat 续期节点和仍有心跳的连接；超过 ConnectionTTL 没有心跳的连接不再续期，读取时自然视为离线
+func (p *Presence) heartbeat(ctx context.Context) {
+	now := time.Now()
+	p.mu.RLock()
+	conns := make([]*trackedConn, 0, len(p.conns))
+	for _, conn := range p.conns {
+		if now.UnixMilli()-conn.lastSeen.Load() < ConnectionTTL.Milliseconds() {
+			conns = append(conns, conn)
+		}
+	}
+	p.mu.RUnlock()
+
+	pipe := p.client.Pipeline()
+	pipe.ZAdd(ctx, presenceNodesKey, redis.Z{Score: float64(now.Add(NodeTTL).UnixMilli()), Member: p.nodeID})
+	for _, conn := range conns {
+		p.refresh(ctx, pipe, conn)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("Presence heartbeat failed: %v", err)
+	}
+}
+
+// sweep 清理宕机节点的连接和已过期的连接；多个节点同时运行时通过锁保证同一时间只有一个节点清理
+func (p *Presence) sweep(ctx context.Context) {
+	locked, err := p.client.SetNX(ctx, presenceSweepLock, p.nodeID, sweepInterval-time.Second).Result()
+	if err != nil || !locked {
+		return
+	}
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+
+	deadNodes, err := p.client.ZRangeByScore(ctx, presenceNodesKey, &redis.ZRangeBy{Min: "-inf", Max: "(" + now}).Result()
+	if err != nil {
+		log.Printf("Presence sweep failed: %v", err)
+		return
+	}
+	for _, nodeID := range deadNodes {
+		p.sweepNode(ctx, nodeID)
+	}
+
+	roomIDs, err := p.client.SMembers(ctx, presenceRoomsKey).Result()
+	if err != nil {
+		log.Printf("Presence sweep failed: %v", err)
+		return
+	}
+	for _, roomID := range roomIDs {
+		key := presenceRoomKey(roomID)
+		expired, err := p.client.ZRangeByScore(ctx, key, &redis.ZRangeBy{Min: "-inf", Max: "(" + now}).Result()
+		if err != nil {
+			continue
+		}
+		pipe := p.client.Pipeline()
+		for _, member := range expired {
+			userID, connID, ok := parseRoomMember(member)
+			if !ok {
+				pipe.ZRem(ctx, key, member)
+				continue
+			}
+			removeConnection(ctx, pipe, connID, roomID, userID, "")
+		}
+		pipe.Exec(ctx)
+		// 房间已经没有在线连接时从清理列表中移除（键已过期时 ZCARD 为 0）
+		if count, err := p.client.ZCard(ctx, key).Result(); err == nil && count == 0 {
+			p.client.SRem(ctx, presenceRoomsKey, roomID)
+		}
+	}
+}
+
+// sweepNode 删除宕机节点持有的全部连接
+func (p *Presence) sweepNode(ctx context.Context, nodeID string) {
+	connIDs, err := p.client.SMembers(ctx, presenceNodeKey(nodeID)).Result()
+	if err != nil {
+		return
+	}
+	log.Printf("Presence: node %s is down, removing %d connections", nodeID, len(connIDs))
+	for _, connID := range connIDs {
+		values, err := p.client.HMGet(ctx, presenceConnKey(connID), "room_id", "user_id").Result()
+		if err != nil {
+			continue
+		}
+		pipe := p.client.Pipeline()
+		roomID, _ := values[0].(string)
+		userIDText, _ := values[1].(string)
+		userID, _ := strconv.ParseUint(userIDText, 10, 64)
+		if roomID != "" && userID != 0 {
+			removeConnection(ctx, pipe, connID, roomID, uint(userID), nodeID)
+		} else {
+			// 连接信息已过期，房间和用户中的记录由截止时间和过期时间处理
+			pipe.SRem(ctx, presenceNodeKey(nodeID), connID)
+		}
+		pipe.Exec(ctx)
+	}
+	p.client.Del(ctx, presenceNodeKey(nodeID))
+	p.client.ZRem(ctx, presenceNodesKey, nodeID)
+}
+
+// removeConnection 删除连接的所有记录并更新用户最后在线时间；nodeID 为空时保留节点集合中的记录，由所属节点删除
+func removeConnection(ctx context.Context, pipe redis.Pipeliner, connID, roomID string, userID uint, nodeID string) {
+	pipe.ZRem(ctx, presenceRoomKey(roomID), roomMember(userID, connID))
+	pipe.ZRem(ctx, presenceUserKey(userID), connID)
+	pipe.Del(ctx, presenceConnKey(connID))
+	if nodeID != "" {
+		pipe.SRem(ctx, presenceNodeKey(nodeID), connID)
+	}
+	pipe.HSet(ctx, presenceLastSeenKey, strconv.FormatUint(uint64(userID), 10), time.Now().Unix())
+}
+
+func parseRoomMember(member string) (uint, string, bool) {
+	userIDText, connID, ok := strings.Cut(member, ":")
+	if !ok {
+		return 0, "", false
+	}
+	userID, err := strconv.ParseUint(userIDText, 10, 64)
+	if err != nil {
+		return 0, "", false
+	}
+	return uint(userID), connID, true
+}
+
+// liveMembers 截止时间未过的成员
+func liveMembers(ctx context.Context, client *redis.Client, key string) ([]string, error) {
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	return client.ZRangeByScore(ctx, key, &redis.ZRangeBy{Min: now, Max: "+inf"}).Result()
+}
+
+// RoomOnlineUsers 房间内的在线用户，同一用户的多个连接只计一次
+func RoomOnlineUsers(ctx context.Context, client *redis.Client, roomID string) ([]UserInfo, error) {
+	members, err := liveMembers(ctx, client, presenceRoomKey(roomID))
+	if err != nil {
+		return nil, err
+	}
+	// 每个用户取一个连接读取用户名
+	firstConn := make(map[uint]string)
+	var order []uint
+	for _, member := range members {
+		userID, connID, ok := parseRoomMember(member)
+		if !ok {
+			continue
+		}
+		if _, seen := firstConn[userID]; !seen {
+			firstConn[userID] = connID
+			order = append(order, userID)
+		}
+	}
+	pipe := client.Pipeline()
+	names := make([]*redis.StringCmd, len(order))
+	for i, userID := range order {
+		names[i] = pipe.HGet(ctx, presenceConnKey(firstConn[userID]), "username")
+	}
+	if len(order) > 0 {
+		if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+			return nil, err
+		}
+	}
+	users := make([]UserInfo, 0, len(order))
+	for i, userID := range order {
+		users = append(users, UserInfo{UserID: userID, Username: names[i].Val()})
+	}
+	return users, nil
+}
+
+// OnlineCounts 批量统计房间在线人数（按用户去重）
+func OnlineCounts(ctx context.Context, client *redis.Client, roomIDs []string) (map[string]int, error) {
+	counts := make(map[string]int, len(roomIDs))
+	if len(roomIDs) == 0 {
+		return counts, nil
+	}
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	pipe := client.Pipeline()
+	cmds := make([]*redis.StringSliceCmd, len(roomIDs))
+	for i, roomID := range roomIDs {
+		cmds[i] = pipe.ZRangeByScore(ctx, presenceRoomKey(roomID), &redis.ZRangeBy{Min: now, Max: "+inf"})
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+	for i, roomID := range roomIDs {
+		users := make(map[uint]bool)
+		for _, member := range cmds[i].Val() {
+			if userID, _, ok := parseRoomMember(member); ok {
+				users[userID] = true
+			}
+		}
+		counts[roomID] = len(users)
+	}
+	return counts, nil
+}
+
+// UsersPresence 批量查询用户跨房间的在线状态和在线设备
+func UsersPresence(ctx context.Context, client *redis.Client, userIDs []uint) ([]UserPresence, error) {
+	result := make([]UserPresence, len(userIDs))
+	if len(userIDs) == 0 {
+		return result, nil
+	}
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	pipe := client.Pipeline()
+	connCmds := make([]*redis.StringSliceCmd, len(userIDs))
+	fields := make([]string, len(userIDs))
+	for i, userID := range userIDs {
+		connCmds[i] = pipe.ZRangeByScore(ctx, presenceUserKey(userID), &redis.ZRangeBy{Min: now, Max: "+inf"})
+		fields[i] = strconv.FormatUint(uint64(userID), 10)
+	}
+	lastSeenCmd := pipe.HMGet(ctx, presenceLastSeenKey, fields...)
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	pipe = client.Pipeline()
+	deviceCmds := make([][]*redis.StringCmd, len(userIDs))
+	for i := range userIDs {
+		for _, connID := range connCmds[i].Val() {
+			deviceCmds[i] = append(deviceCmds[i], pipe.HGet(ctx, presenceConnKey(connID), "device"))
+		}
+	}
+	if pipe.Len() > 0 {
+		if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+			return nil, err
+		}
+	}
+
+	lastSeen := lastSeenCmd.Val()
+	for i, userID := range userIDs {
+		status := UserPresence{UserID: userID, Online: len(connCmds[i].Val()) > 0, Devices: []string{}}
+		for _, cmd := range deviceCmds[i] {
+			if device := cmd.Val(); device != "" {
+				status.Devices = append(status.Devices, device)
+			}
+		}
+		if !status.Online && i < len(lastSeen) {
+			if text, ok := lastSeen[i].(string); ok {
+				if unix, err := strconv.ParseInt(text, 10, 64); err == nil {
+					t := time.Unix(unix, 0)
+					status.LastSeen = &t
+				}
+			}
+		}
+		result[i] = status
+	}
+	return result, nil
+}
@@ -67,12 +67,11 @@ type UserInfo struct {
 	Color    string `json:"color"`
 }
 
-// GetOnlineUsers 获取指定房间的在线用户
-func (r *RedisClient) GetOnlineUsers(ctx context.Context, roomType string, roomID uint) (map[string]string, error) {
-	key := fmt.Sprintf("%s:room:%d:online_users", roomType, roomID)
-	result, err := r.Client.HGetAll(ctx, key).Result()
+// GetOnlineUsers 获取指定房间的在线用户，roomID 为聊天房间 ID（与 WebSocket 路径中的 roomId 相同）
+func (r *RedisClient) GetOnlineUsers(ctx context.Context, roomID string) ([]UserInfo, error) {
+	users, err := RoomOnlineUsers(ctx, r.Client, roomID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch online users for key %s: %w", key, err)
+		return nil, fmt.Errorf("failed to fetch online users for room %s: %w", roomID, err)
 	}
-	return result, nil
+	return users, nil
 }
@@ -1,11 +1,14 @@
 package server
 
 import (
+	"LiteAdmin/models"
+
 	"github.com/labstack/echo/v4"
 )
 
-func (s *Server) SetupRoutes(authMiddleware echo.MiddlewareFunc, adminMiddleware echo.MiddlewareFunc, limiter echo.MiddlewareFunc) {
+func (s *Server) SetupRoutes(authMiddleware echo.MiddlewareFunc, requirePermission func(permission string) echo.MiddlewareFunc, limiter echo.MiddlewareFunc) {
 	e := s.Echo
+	e.GET("/.well-known/jwks.json", s.AuthHandler.JWKS) // JWT 验签公钥
 	api := e.Group("/api/v1")
 	// Auth routes (unprotected)
 	auth := api.Group("/auth")
@@ -16,10 +19,14 @@ func (s *Server) SetupRoutes(authMiddleware echo.MiddlewareFunc, adminMiddleware
 		auth.POST("/register", s.AuthHandler.Register, limiter)
 		auth.POST("/login", s.AuthHandler.Login, limiter)
 		auth.POST("/refresh", s.AuthHandler.RefreshToken)
+		auth.POST("/device", s.AuthHandler.DeviceLogin, limiter) // 记住我：设备令牌换取访问令牌
 		// OAuth routes
 		auth.GET("/oauth/:provider", s.AuthHandler.OAuthLogin)
 		auth.GET("/oauth/:provider/callback", s.AuthHandler.OAuthCallback)
+		auth.POST("/email/confirm", s.AccountHandler.ConfirmEmailChange, limiter) // 确认新邮箱
 	}
+	// 附件签名下载链接，签名代替登录凭证
+	api.GET("/files/:id", s.AttachmentHandler.DownloadAttachment)
 	// 公开路由
 	public := api.Group("/public")
 	{
@@ -27,38 +34,121 @@ func (s *Server) SetupRoutes(authMiddleware echo.MiddlewareFunc, adminMiddleware
 		public.GET("/categories/all", s.CategoryHandler.GetAllCategories) // 获取所有分类
 		public.GET("/categories/:id", s.CategoryHandler.GetCategoryByID)  // 获取分类详情
 	}
-	// 需要认证
+	// 需要认证（仅接受登录令牌）
 	protected := api.Group("")
 	protected.Use(authMiddleware)
 	{
 		// User routes
 		protected.GET("/user", s.AuthHandler.GetCurrentUser)
+		// 账号与登录凭据管理
+		credentials := protected.Group("/user")
+		{
+			credentials.GET("/devices", s.AuthHandler.ListDevices)                          // 我的设备
+			credentials.DELETE("/devices/:id", s.AuthHandler.RevokeDevice)                  // 吊销设备
+			credentials.GET("/identities", s.AuthHandler.ListIdentities)                    // 已绑定的第三方账号
+			credentials.GET("/identities/:provider/authorize", s.AuthHandler.LinkAuthorize) // 发起绑定授权
+			credentials.POST("/identities/:provider", s.AuthHandler.LinkIdentity)           // 绑定第三方账号
+			credentials.DELETE("/identities/:id", s.AuthHandler.UnlinkIdentity)             // 解绑第三方账号
+			credentials.POST("/api-keys", s.APIKeyHandler.CreateAPIKey)                     // 创建 API 密钥
+			credentials.GET("/api-keys", s.APIKeyHandler.ListAPIKeys)                       // 我的 API 密钥
+			credentials.DELETE("/api-keys/:id", s.APIKeyHandler.RevokeAPIKey)               // 吊销 API 密钥
+			credentials.PUT("/profile", s.AccountHandler.UpdateProfile)                     // 修改资料
+			credentials.PUT("/password", s.AccountHandler.ChangePassword)                   // 修改密码
+			credentials.POST("/email", s.AccountHandler.RequestEmailChange)                 // 申请修改邮箱
+			credentials.DELETE("", s.AccountHandler.DeleteAccount)                          // 注销账号
+		}
 		// Rooms routes
 		rooms := protected.Group("/rooms")
 		{
-			rooms.POST("", s.RoomHandler.CreateRoom)        // 创建房间
-			rooms.GET("", s.RoomHandler.ListRooms)          // 获取房间列表
-			rooms.GET("/:id", s.RoomHandler.GetRoom)        // 获取单个房间
-			rooms.POST("/:id/join", s.RoomHandler.JoinRoom) // 加入房间（验证密码）
-			rooms.DELETE("/:id", s.RoomHandler.DeleteRoom)  // 删除房间
+			rooms.POST("", s.RoomHandler.CreateRoom)                           // 创建房间
+			rooms.GET("", s.RoomHandler.ListRooms)                             // 获取房间列表
+			rooms.GET("/:id", s.RoomHandler.GetRoom)                           // 获取单个房间
+			rooms.POST("/:id/join", s.RoomHandler.JoinRoom)                    // 加入房间（验证密码）
+			rooms.DELETE("/:id", s.RoomHandler.DeleteRoom)                     // 删除房间
+			rooms.POST("/:id/leave", s.RoomHandler.LeaveRoom)                  // 退出房间
+			rooms.GET("/:id/members", s.RoomHandler.ListMembers)               // 成员列表
+			rooms.PUT("/:id/members/:userId", s.RoomHandler.UpdateMemberRole)  // 设置成员角色
+			rooms.DELETE("/:id/members/:userId", s.RoomHandler.RemoveMember)   // 移出成员
+			rooms.POST("/:id/invites", s.RoomHandler.CreateInvite)             // 创建邀请链接
+			rooms.GET("/:id/invites", s.RoomHandler.ListInvites)               // 邀请链接列表
+			rooms.DELETE("/:id/invites/:inviteId", s.RoomHandler.RevokeInvite) // 撤销邀请链接
+			rooms.POST("/invites/:code", s.RoomHandler.AcceptInvite)           // 通过邀请码加入
+
+			// 房间管理（房主或版主）
+			rooms.POST("/:id/moderation/kick", s.RoomHandler.KickUser)                // 踢出聊天室
+			rooms.POST("/:id/moderation/ban", s.RoomHandler.BanUser)                  // 封禁
+			rooms.DELETE("/:id/moderation/ban/:userId", s.RoomHandler.UnbanUser)      // 解除封禁
+			rooms.POST("/:id/moderation/mute", s.RoomHandler.MuteUser)                // 禁言
+			rooms.DELETE("/:id/moderation/mute/:userId", s.RoomHandler.UnmuteUser)    // 解除禁言
+			rooms.PUT("/:id/moderation/slow-mode", s.RoomHandler.SetSlowMode)         // 慢速模式
+			rooms.GET("/:id/moderation/restrictions", s.RoomHandler.ListRestrictions) // 生效中的封禁和禁言
 		}
 		// Chat routes
 		chat := protected.Group("/chat")
 		{
-			chat.GET("/:roomId/messages", s.ChatWebSocketHandler.GetMessages)        // 获取历史消息
-			chat.GET("/:roomId/online-users", s.ChatWebSocketHandler.GetOnlineUsers) // 获取在线用户列表
+			chat.GET("/:roomId/messages", s.ChatWebSocketHandler.GetMessages)          // 获取历史消息
+			chat.GET("/:roomId/messages/:id/thread", s.ChatWebSocketHandler.GetThread) // 获取话题及回复
+			chat.GET("/:roomId/online-users", s.ChatWebSocketHandler.GetOnlineUsers)   // 获取在线用户列表
+			chat.GET("/:roomId/read-states", s.ChatWebSocketHandler.GetReadStates)     // 获取已读位置
+			chat.GET("/presence", s.ChatWebSocketHandler.GetPresence)                  // 批量查询用户在线状态
+			chat.POST("/:roomId/attachments", s.AttachmentHandler.UploadAttachment)    // 上传附件
+			chat.GET("/:roomId/attachments/:id", s.AttachmentHandler.GetAttachment)    // 获取附件及下载链接
 		}
 		protected.GET("/chat/:roomId/ws", s.ChatWebSocketHandler.HandleWebSocket)
 		customer := protected.Group("/customer")
 		{
-			customer.POST("/session", s.CustomerServiceHandler.CreateOrGetSession)             // 用户创建会话
-			customer.GET("/sessions", s.CustomerServiceHandler.GetAllSessions)                 // 管理员获取会话列表
-			customer.PUT("/sessions/:sessionId", s.CustomerServiceHandler.UpdateSessionStatus) // 更新状态
+			customer.POST("/session", s.CustomerServiceHandler.CreateOrGetSession)  // 用户创建会话
+			customer.GET("/session/queue", s.CustomerServiceHandler.GetQueueStatus) // 用户查询排队位置
 		}
-		admin := e.Group("/admin")
-		admin.Use(adminMiddleware)
-		admin.POST("/categories", s.CategoryHandler.CreateCategory)       // 创建分类
-		admin.PUT("/categories/:id", s.CategoryHandler.UpdateCategory)    // 更新分类
-		admin.DELETE("/categories/:id", s.CategoryHandler.DeleteCategory) // 删除分类
+	}
+	// 以下接口都声明了权限，由 requirePermission 认证，也接受 scopes 包含该权限的 API 密钥
+	serviceAgent := requirePermission(models.PermCustomerServiceHandle)
+	agent := api.Group("/customer")
+	{
+		agent.GET("/sessions", s.CustomerServiceHandler.GetAllSessions, serviceAgent)                       // 客服获取会话列表
+		agent.PUT("/sessions/:sessionId", s.CustomerServiceHandler.UpdateSessionStatus, serviceAgent)       // 更新状态
+		agent.PUT("/agent/status", s.CustomerServiceHandler.UpdateAgentStatus, serviceAgent)                // 客服切换在线状态
+		agent.GET("/agents", s.CustomerServiceHandler.ListAgents, serviceAgent)                             // 客服列表及接待数
+		agent.GET("/queue", s.CustomerServiceHandler.GetQueue, serviceAgent)                                // 排队中的会话
+		agent.POST("/sessions/:sessionId/accept", s.CustomerServiceHandler.AcceptSession, serviceAgent)     // 接入排队中的会话
+		agent.POST("/sessions/:sessionId/transfer", s.CustomerServiceHandler.TransferSession, serviceAgent) // 转接
+		agent.POST("/sessions/:sessionId/release", s.CustomerServiceHandler.ReleaseSession, serviceAgent)   // 释放会话
+		agent.GET("/sla/metrics", s.CustomerServiceHandler.GetSLAMetrics, serviceAgent)                     // 各客服 SLA 指标
+		agent.GET("/canned-responses", s.CannedResponseHandler.ListCannedResponses, serviceAgent)           // 快捷回复列表
+		agent.POST("/canned-responses", s.CannedResponseHandler.CreateCannedResponse, serviceAgent)         // 新建快捷回复
+		agent.PUT("/canned-responses/:id", s.CannedResponseHandler.UpdateCannedResponse, serviceAgent)      // 修改快捷回复
+		agent.DELETE("/canned-responses/:id", s.CannedResponseHandler.DeleteCannedResponse, serviceAgent)   // 删除快捷回复
+	}
+	petsWrite := requirePermission(models.PermPetsWrite)
+	merchant := api.Group("/merchant")
+	{
+		merchant.GET("/pets", s.PetHandler.ListPets, petsWrite)      // 本店铺商品列表
+		merchant.POST("/pets", s.PetHandler.CreatePet, petsWrite)    // 新建商品
+		merchant.PUT("/pets/:id", s.PetHandler.UpdatePet, petsWrite) // 修改商品、库存或上下架
+	}
+	admin := e.Group("/admin")
+	{
+		categoryWrite := requirePermission(models.PermCategoryWrite)
+		admin.POST("/categories", s.CategoryHandler.CreateCategory, categoryWrite)       // 创建分类
+		admin.PUT("/categories/:id", s.CategoryHandler.UpdateCategory, categoryWrite)    // 更新分类
+		admin.DELETE("/categories/:id", s.CategoryHandler.DeleteCategory, categoryWrite) // 删除分类
+		// 角色与权限
+		roleManage := requirePermission(models.PermRoleManage)
+		admin.GET("/roles", s.RBACHandler.ListRoles, roleManage)                          // 角色列表
+		admin.GET("/users/:id/permissions", s.RBACHandler.GetUserPermissions, roleManage) // 用户有效权限
+		admin.POST("/users/:id/roles", s.RBACHandler.AssignRole, roleManage)              // 分配角色
+		admin.DELETE("/users/:id/roles/:role", s.RBACHandler.RemoveRole, roleManage)      // 移除角色
+		// 用户管理
+		userManage := requirePermission(models.PermUserManage)
+		admin.GET("/users", s.UserAdminHandler.ListUsers, userManage)               // 搜索用户
+		admin.GET("/users/:id", s.UserAdminHandler.GetUser, userManage)             // 用户详情
+		admin.PUT("/users/:id/type", s.UserAdminHandler.ChangeType, userManage)     // 修改用户类型
+		admin.POST("/users/:id/ban", s.UserAdminHandler.BanUser, userManage)        // 封禁
+		admin.DELETE("/users/:id/ban", s.UserAdminHandler.UnbanUser, userManage)    // 解除封禁
+		admin.POST("/users/:id/logout", s.UserAdminHandler.ForceLogout, userManage) // 强制下线
+		// 审计日志
+		auditRead := requirePermission(models.PermAuditRead)
+		admin.GET("/audit-logs", s.AuditHandler.ListAuditLogs, auditRead)          // 查询审计日志
+		admin.GET("/audit-logs/export", s.AuditHandler.ExportAuditLogs, auditRead) // 导出 CSV
 	}
 }
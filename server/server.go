@@ -8,6 +8,8 @@ import (
 	"LiteAdmin/models"
 	"LiteAdmin/redis"
 	"LiteAdmin/services"
+	"LiteAdmin/storage"
+	"context"
 	"time"
 
 	"github.com/labstack/echo/v4"
@@ -24,8 +26,16 @@ type Server struct {
 	AuthHandler            *handlers.AuthHandler
 	RoomHandler            *handlers.RoomHandler
 	ChatWebSocketHandler   *handlers.ChatWebSocketHandler
+	AttachmentHandler      *handlers.AttachmentHandler
 	CustomerServiceHandler *handlers.CustomerServiceHandler
+	CannedResponseHandler  *handlers.CannedResponseHandler
+	PetHandler             *handlers.PetHandler
 	CategoryHandler        *handlers.CategoryServiceHandler
+	RBACHandler            *handlers.RBACHandler
+	APIKeyHandler          *handlers.APIKeyHandler
+	AccountHandler         *handlers.AccountHandler
+	UserAdminHandler       *handlers.UserAdminHandler
+	AuditHandler           *handlers.AuditHandler
 }
 
 func NewServer() *Server {
@@ -46,6 +56,7 @@ func NewServer() *Server {
 	e := echo.New()
 	e.Use(middleware.Logger())
 	e.Use(middleware.Recover())
+	e.Use(custommiddleware.AuditRequest())
 	e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
 		AllowOrigins:     []string{"http://localhost:5173"},
 		AllowMethods:     []string{echo.GET, echo.POST, echo.PUT, echo.DELETE, echo.PATCH},
@@ -54,14 +65,38 @@ func NewServer() *Server {
 		ExposeHeaders:    []string{echo.HeaderContentLength},
 		MaxAge:           86400,
 	}))
-	authService := services.NewAuthService(db, &cfg.Auth)
-	oauthService := services.NewOAuthService(&cfg.Auth)
-	roomService := services.NewRoomService(db, &cfg.RedisConfig)
-	customerHandler := handlers.NewCustomerServiceHandler(db)
-	authHandler := handlers.NewAuthHandler(authService, oauthService)
+	redisClient := redis.GetRedis(&cfg.RedisConfig).Client
+	keyManager, err := services.NewKeyManager(db, &cfg.Auth)
+	if err != nil {
+		log.Fatal("Failed to initialize signing keys:", err)
+	}
+	go keyManager.Run(context.Background())
+	authService := services.NewAuthService(db, &cfg.Auth, keyManager)
+	oauthService := services.NewOAuthService(&cfg.Auth, redisClient)
+	rbacService := services.NewRBACService(db, redisClient)
+	// 内置角色的权限可能随版本变化，启动时让权限缓存整体失效
+	rbacService.InvalidateAll(context.Background())
+	auditService := services.NewAuditService(db)
+	roomService := services.NewRoomService(db, redisClient, rbacService, auditService)
+	supportService := services.NewSupportService(db, redisClient, auditService, &cfg.Support)
+	go supportService.RunSLA(context.Background())
+	customerHandler := handlers.NewCustomerServiceHandler(db, auditService, supportService)
+	authHandler := handlers.NewAuthHandler(authService, oauthService, keyManager, auditService)
 	roomHandler := handlers.NewRoomHandler(roomService)
-	categoryHandler := handlers.NewCategoryHandler(db)
-	chatWebSocketHandler := handlers.NewChatWebSocketHandler(db, redis.GetRedis(&cfg.RedisConfig).Client)
+	categoryHandler := handlers.NewCategoryHandler(db, auditService)
+	rbacHandler := handlers.NewRBACHandler(db, rbacService, auditService)
+	apiKeyService := services.NewAPIKeyService(db, rbacService)
+	apiKeyHandler := handlers.NewAPIKeyHandler(apiKeyService, auditService)
+	accountService := services.NewAccountService(db, redisClient, authService, services.NewMailer(&cfg.Mail), &cfg.Mail)
+	accountHandler := handlers.NewAccountHandler(accountService, authService, auditService)
+	userAdminHandler := handlers.NewUserAdminHandler(services.NewUserAdminService(db, authService, rbacService, redisClient), auditService)
+	blob, err := storage.New(&cfg.Storage)
+	if err != nil {
+		log.Fatal("Failed to initialize storage:", err)
+	}
+	attachmentService := services.NewAttachmentService(db, blob, &cfg.Storage)
+	cannedService := services.NewCannedResponseService(db, auditService)
+	chatWebSocketHandler := handlers.NewChatWebSocketHandler(db, redisClient, roomService, services.NewMessageService(db), attachmentService, cannedService)
 	s := &Server{
 		Echo:                   e,
 		DB:                     db,
@@ -69,12 +104,20 @@ func NewServer() *Server {
 		AuthHandler:            authHandler,
 		RoomHandler:            roomHandler,
 		ChatWebSocketHandler:   chatWebSocketHandler,
+		AttachmentHandler:      handlers.NewAttachmentHandler(db, attachmentService, roomService),
 		CustomerServiceHandler: customerHandler,
+		CannedResponseHandler:  handlers.NewCannedResponseHandler(cannedService),
+		PetHandler:             handlers.NewPetHandler(services.NewPetService(db, auditService)),
 		CategoryHandler:        categoryHandler,
+		RBACHandler:            rbacHandler,
+		APIKeyHandler:          apiKeyHandler,
+		AccountHandler:         accountHandler,
+		UserAdminHandler:       userAdminHandler,
+		AuditHandler:           handlers.NewAuditHandler(auditService),
 	}
 	// --- 设置路由中间件 ---
 	strategy := &limiter.TokenBucketStrategy{}
-	limitManager := limiter.NewManager(redis.GetRedis(&cfg.RedisConfig).Client, strategy)
+	limitManager := limiter.NewManager(redisClient, strategy)
 	limiterConfig := custommiddleware.RateLimitConfig{
 		Limit:  10,              // 桶容量 / 限制次数
 		Window: 1 * time.Second, // 时间单位
@@ -83,9 +126,11 @@ func NewServer() *Server {
 		},
 	}
 	authMiddleware := custommiddleware.AuthMiddleware(authService)
-	adminMiddleware := custommiddleware.AdminAuthMiddleware()
+	requirePermission := func(permission string) echo.MiddlewareFunc {
+		return custommiddleware.RequirePermission(authService, apiKeyService, rbacService, permission)
+	}
 	limitMiddleware := custommiddleware.NewRateLimitMiddleware(limitManager, limiterConfig)
-	s.SetupRoutes(authMiddleware, adminMiddleware, limitMiddleware)
+	s.SetupRoutes(authMiddleware, requirePermission, limitMiddleware)
 	return s
 }
 
@@ -0,0 +1,303 @@
+package services
+
+import (
+	"LiteAdmin/config"
+	"LiteAdmin/models"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrUsernameTaken      = errors.New("username already taken")
+	ErrEmailTaken         = errors.New("email already in use")
+	ErrInvalidEmail       = errors.New("invalid email address")
+	ErrInvalidPassword    = errors.New("current password is incorrect")
+	ErrWeakPassword       = errors.New("password must be at least 8 characters")
+	ErrNoLocalPassword    = errors.New("account has no local password")
+	ErrEmailChangeInvalid = errors.New("invalid or expired email confirmation")
+	ErrDeleteConfirmation = errors.New("account deletion not confirmed")
+	ErrMerchantAccount    = errors.New("merchant accounts must be closed by support")
+)
+
+const (
+	emailChangeExpiry = 24 * time.Hour
+	minPasswordLength = 8
+)
+
+// AccountService 用户自助管理个人资料、密码、邮箱与注销账号
+type AccountService struct {
+	db              *gorm.DB
+	redis           *redis.Client
+	auth            *AuthService
+	mailer          Mailer
+	emailConfirmURL string
+}
+
+func NewAccountService(db *gorm.DB, redisClient *redis.Client, auth *AuthService, mailer Mailer, cfg *config.MailConfig) *AccountService {
+	return &AccountService{
+		db:              db,
+		redis:           redisClient,
+		auth:            auth,
+		mailer:          mailer,
+		emailConfirmURL: cfg.EmailConfirmURL,
+	}
+}
+
+// ProfileUpdate 可修改的资料字段，nil 表示不修改
+type ProfileUpdate struct {
+	Username *string
+	Avatar   *string
+}
+
+// UpdateProfile 修改用户名与头像
+func (s *AccountService) UpdateProfile(user *models.User, update ProfileUpdate) error {
+	updates := map[string]interface{}{}
+	if update.Username != nil {
+		username := strings.TrimSpace(*update.Username)
+		if username == "" {
+			return errors.New("username cannot be empty")
+		}
+		if username != user.Username {
+			var count int64
+			if err := s.db.Model(&models.User{}).Where("username = ? AND id <> ?", username, user.ID).Count(&count).Error; err != nil {
+				return err
+			}
+			if count > 0 {
+				return ErrUsernameTaken
+			}
+			updates["username"] = username
+		}
+	}
+	if update.Avatar != nil {
+		updates["avatar"] = strings.TrimSpace(*update.Avatar)
+	}
+	if len(updates) == 0 {
+		return nil
+	}
+	return s.db.Model(user).Updates(updates).Error
+}
+
+// ChangePassword 修改本地账号密码，成功后吊销其他所有会话
+func (s *AccountService) ChangePassword(user *models.User, current, newPassword string) error {
+	if user.Password == "" {
+		return ErrNoLocalPassword
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(current)) != nil {
+		return ErrInvalidPassword
+	}
+	if len(newPassword) < minPasswordLength {
+		return ErrWeakPassword
+	}
+	hashed, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(user).Update("password", string(hashed)).Error; err != nil {
+			return err
+		}
+		if err := s.auth.RevokeAllTokens(tx, user.ID); err != nil {
+			return err
+		}
+		return tx.Select("token_version").First(user).Error
+	})
+}
+
+// RequestEmailChange 向新邮箱发送确认链接；本地账号需要验证当前密码
+func (s *AccountService) RequestEmailChange(user *models.User, newEmail, password string) error {
+	addr, err := mail.ParseAddress(strings.TrimSpace(newEmail))
+	if err != nil || addr.Name != "" {
+		return ErrInvalidEmail
+	}
+	newEmail = strings.ToLower(addr.Address)
+	if user.Password != "" && bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)) != nil {
+		return ErrInvalidPassword
+	}
+	if err := s.ensureEmailAvailable(s.db, newEmail, user.ID); err != nil {
+		return err
+	}
+	token, err := newOpaqueToken()
+	if err != nil {
+		return err
+	}
+	request := models.EmailChangeRequest{
+		UserID:    user.ID,
+		NewEmail:  newEmail,
+		TokenHash: HashDeviceToken(token),
+		ExpiresAt: time.Now().Add(emailChangeExpiry),
+	}
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", user.ID).Delete(&models.EmailChangeRequest{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&request).Error
+	})
+	if err != nil {
+		return err
+	}
+	link := s.emailConfirmURL + "?token=" + url.QueryEscape(token)
+	body := fmt.Sprintf("Hi %s,\n\nConfirm your new ShopHub email address by opening the link below within 24 hours:\n\n%s\n\nIf you did not request this change, you can ignore this email.\n", user.Username, link)
+	return s.mailer.Send(newEmail, "Confirm your new email address", body)
+}
+
+// ConfirmEmailChange 使用邮件中的令牌确认邮箱变更，返回原邮箱与更新后的用户
+func (s *AccountService) ConfirmEmailChange(token string) (string, *models.User, error) {
+	var user models.User
+	var oldEmail string
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var request models.EmailChangeRequest
+		if err := tx.Where("token_hash = ?", HashDeviceToken(token)).First(&request).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrEmailChangeInvalid
+			}
+			return err
+		}
+		if err := tx.Delete(&request).Error; err != nil {
+			return err
+		}
+		if time.Now().After(request.ExpiresAt) {
+			return ErrEmailChangeInvalid
+		}
+		if err := s.ensureEmailAvailable(tx, request.NewEmail, request.UserID); err != nil {
+			return err
+		}
+		if err := tx.First(&user, request.UserID).Error; err != nil {
+			return err
+		}
+		oldEmail = user.Email
+		// 新邮箱通过确认链接证明了归属
+		return tx.Model(&user).Updates(map[string]interface{}{"email": request.NewEmail, "email_verified": true}).Error
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	return oldEmail, &user, nil
+}
+
+// accountOwnedData 注销时按 user_id 删除的个人数据。新增以 user_id 归属用户的模型需要加到这里，
+// 否则外键会让删除用户失败
+var accountOwnedData = []interface{}{
+	&models.Cart{},
+	&models.Favorite{},
+	&models.MerchantFollow{},
+	&models.UserCoupon{},
+	&models.DeviceToken{},
+	&models.APIKey{},
+	&models.UserIdentity{},
+	&models.UserRole{},
+	&models.EmailChangeRequest{},
+	&models.RoomMember{},
+	&models.RoomRestriction{},
+	&models.RoomReadState{},
+	&models.MessageReaction{},
+	&models.SupportAgent{},
+}
+
+// accountReference 注销后保留、但去掉用户关联的列
+type accountReference struct {
+	model  interface{}
+	column string
+}
+
+// accountReferences 注销时置为 NULL 的列（房间、消息等保留但不再属于任何用户），新增引用 users 的列需要加到这里
+var accountReferences = []accountReference{
+	{&models.Message{}, "user_id"},
+	{&models.Message{}, "deleted_by"},
+	{&models.Attachment{}, "user_id"},
+	{&models.Room{}, "owner_id"},
+	{&models.RoomInvite{}, "created_by"},
+	{&models.RoomRestriction{}, "created_by"},
+	{&models.CannedResponse{}, "created_by"},
+	{&models.CustomerSession{}, "agent_id"},
+	{&models.CustomerSession{}, "released_by"},
+	{&models.SLABreach{}, "agent_id"},
+}
+
+// DeleteAccount 注销账号：删除购物车、收藏、关注、房间成员、客服会话等个人数据，匿名化聊天消息作者等引用，
+// 吊销所有凭据并断开聊天连接。本地账号需要当前密码，第三方登录账号需要输入用户名确认
+func (s *AccountService) DeleteAccount(ctx context.Context, user *models.User, password, confirm string) error {
+	if user.Password != "" {
+		if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)) != nil {
+			return ErrInvalidPassword
+		}
+	} else if confirm != user.Username {
+		return ErrDeleteConfirmation
+	}
+	// 商家名下还有商品与订单，需要客服协助结算后关闭
+	var merchants int64
+	if err := s.db.Model(&models.MerchantInfo{}).Where("user_id = ?", user.ID).Count(&merchants).Error; err != nil {
+		return err
+	}
+	if merchants > 0 {
+		return ErrMerchantAccount
+	}
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := s.auth.RevokeAllTokens(tx, user.ID); err != nil {
+			return err
+		}
+		// 用户本人的客服会话连同 SLA 记录一起删除
+		sessions := tx.Model(&models.CustomerSession{}).Select("id").Where("user_id = ?", user.ID)
+		if err := tx.Where("session_id IN (?)", sessions).Delete(&models.SLABreach{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", user.ID).Delete(&models.CustomerSession{}).Error; err != nil {
+			return err
+		}
+		// 客服正在接待的会话退回排队
+		if err := tx.Model(&models.CustomerSession{}).
+			Where("agent_id = ? AND status = ?", user.ID, models.SessionStatusActive).
+			Updates(map[string]interface{}{
+				"agent_id":    nil,
+				"assigned_at": nil,
+				"status":      models.SessionStatusPending,
+			}).Error; err != nil {
+			return err
+		}
+		for _, ref := range accountReferences {
+			if err := tx.Model(ref.model).Where(ref.column+" = ?", user.ID).
+				UpdateColumn(ref.column, nil).Error; err != nil {
+				return err
+			}
+		}
+		for _, model := range accountOwnedData {
+			if err := tx.Unscoped().Where("user_id = ?", user.ID).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Delete(&models.User{}, user.ID).Error
+	})
+	if err != nil {
+		return err
+	}
+	// 已建立的 WebSocket 连接不会重新校验令牌，需要主动断开
+	err = PublishChatControl(ctx, s.redis, ChatControlEvent{
+		Action: ChatControlDisconnectUser,
+		UserID: user.ID,
+		Reason: "account deleted",
+	})
+	if err != nil {
+		log.Printf("Failed to publish chat disconnect for deleted user %d: %v", user.ID, err)
+	}
+	return nil
+}
+
+func (s *AccountService) ensureEmailAvailable(tx *gorm.DB, email string, userID uint) error {
+	var count int64
+	if err := tx.Model(&models.User{}).Where("LOWER(email) = ? AND id <> ?", email, userID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrEmailTaken
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package services
+
+import (
+	"LiteAdmin/config"
+	"LiteAdmin/models"
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// TestDeleteAccountNullsReferences 注销后保留的房间、消息等引用置为 NULL 而不是 0，并通知聊天服务断开连接
+func TestDeleteAccountNullsReferences(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "account.db")), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := models.AutoMigrateAll(db); err != nil {
+		t.Fatal(err)
+	}
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	control := rdb.Subscribe(context.Background(), ChatControlChannel)
+	t.Cleanup(func() { control.Close() })
+	if _, err := control.Receive(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret-password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &models.User{Username: "leaving", Email: "leaving@example.com", Password: string(hash), Type: models.RoleClient}
+	other := &models.User{Username: "staying", Email: "staying@example.com", Type: models.RoleClient}
+	for _, u := range []*models.User{user, other} {
+		if err := db.Create(u).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	room := &models.Room{Name: "lobby", OwnerID: user.ID}
+	if err := db.Create(room).Error; err != nil {
+		t.Fatal(err)
+	}
+	messages := []*models.Message{
+		{RoomID: "1", UserID: user.ID, Content: "bye"},
+		{RoomID: "1", UserID: other.ID, Content: "removed", DeletedBy: user.ID},
+	}
+	for _, message := range messages {
+		if err := db.Create(message).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	auth := &AuthService{Db: db}
+	accounts := NewAccountService(db, rdb, auth, nil, &config.MailConfig{})
+	if err := accounts.DeleteAccount(context.Background(), user, "secret-password", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		table, column string
+		id            uint
+	}{
+		{"rooms", "owner_id", room.ID},
+		{"messages", "user_id", messages[0].ID},
+		{"messages", "deleted_by", messages[1].ID},
+	}
+	for _, tt := range tests {
+		var value *uint
+		if err := db.Table(tt.table).Select(tt.column).Where("id = ?", tt.id).Scan(&value).Error; err != nil {
+			t.Fatal(err)
+		}
+		if value != nil {
+			t.Fatalf("%s.%s = %d, want NULL", tt.table, tt.column, *value)
+		}
+	}
+	var author uint
+	if err := db.Table("messages").Select("user_id").Where("id = ?", messages[1].ID).Scan(&author).Error; err != nil {
+		t.Fatal(err)
+	}
+	if author != other.ID {
+		t.Fatalf("other user's message author = %d, want %d", author, other.ID)
+	}
+
+	msg, err := control.ReceiveTimeout(context.Background(), 5*time.Second)
+	if err != nil {
+		t.Fatalf("no chat control event: %v", err)
+	}
+	var event ChatControlEvent
+	if err := json.Unmarshal([]byte(msg.(*redis.Message).Payload), &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.Action != ChatControlDisconnectUser || event.UserID != user.ID || event.RoomID != "" {
+		t.Fatalf("chat control event %+v, want disconnect of user %d from all rooms", event, user.ID)
+	}
+}
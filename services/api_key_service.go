@@ -0,0 +1,170 @@
+package services
+
+import (
+	"LiteAdmin/models"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrAPIKeyInvalid    = errors.New("invalid api key")
+	ErrAPIKeyNotFound   = errors.New("api key not found")
+	ErrScopeNotAllowed  = errors.New("scope not granted to user")
+	ErrAPIKeyScopesNone = errors.New("at least one scope is required")
+)
+
+const (
+	apiKeyTag = "shk_"
+	// prefix 是唯一索引，16 位十六进制（64 位随机数）下碰撞可以忽略；早期签发的密钥为 8 位，仍然可以使用
+	apiKeyPrefixLen       = 16
+	legacyAPIKeyPrefixLen = 8
+	// last_used_at 的最小更新间隔，避免每个请求都写库
+	apiKeyTouchInterval = time.Minute
+)
+
+type APIKeyService struct {
+	db   *gorm.DB
+	rbac *RBACService
+}
+
+func NewAPIKeyService(db *gorm.DB, rbac *RBACService) *APIKeyService {
+	return &APIKeyService{db: db, rbac: rbac}
+}
+
+// CreateAPIKey 创建 API 密钥，scopes 必须是用户当前拥有的权限；返回的明文密钥只在此时可见
+func (s *APIKeyService) CreateAPIKey(ctx context.Context, user *models.User, name string, scopes []string, expiresAt *time.Time) (string, *models.APIKey, error) {
+	scopes = normalizeScopes(scopes)
+	if len(scopes) == 0 {
+		return "", nil, ErrAPIKeyScopesNone
+	}
+	perms, err := s.rbac.UserPermissions(ctx, user)
+	if err != nil {
+		return "", nil, err
+	}
+	granted := make(map[string]struct{}, len(perms))
+	for _, perm := range perms {
+		granted[perm] = struct{}{}
+	}
+	for _, scope := range scopes {
+		if _, ok := granted[scope]; !ok {
+			return "", nil, ErrScopeNotAllowed
+		}
+	}
+
+	prefixBytes := make([]byte, apiKeyPrefixLen/2)
+	if _, err := rand.Read(prefixBytes); err != nil {
+		return "", nil, err
+	}
+	secret, err := newOpaqueToken()
+	if err != nil {
+		return "", nil, err
+	}
+	prefix := hex.EncodeToString(prefixBytes)
+	raw := apiKeyTag + prefix + "_" + secret
+	key := &models.APIKey{
+		UserID:    user.ID,
+		Name:      name,
+		Prefix:    prefix,
+		KeyHash:   HashDeviceToken(raw),
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+	}
+	if err := s.db.Create(key).Error; err != nil {
+		return "", nil, err
+	}
+	return raw, key, nil
+}
+
+// Authenticate 校验 X-API-Key，返回密钥所属用户
+func (s *APIKeyService) Authenticate(raw string) (*models.User, *models.APIKey, error) {
+	prefix, ok := parseAPIKeyPrefix(raw)
+	if !ok {
+		return nil, nil, ErrAPIKeyInvalid
+	}
+	var key models.APIKey
+	if err := s.db.Where("prefix = ? AND revoked_at IS NULL", prefix).First(&key).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, ErrAPIKeyInvalid
+		}
+		return nil, nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(key.KeyHash), []byte(HashDeviceToken(raw))) != 1 {
+		return nil, nil, ErrAPIKeyInvalid
+	}
+	now := time.Now()
+	if key.ExpiresAt != nil && now.After(*key.ExpiresAt) {
+		return nil, nil, ErrAPIKeyInvalid
+	}
+	var user models.User
+	if err := s.db.First(&user, key.UserID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, ErrAPIKeyInvalid
+		}
+		return nil, nil, err
+	}
+	if user.BannedAt != nil {
+		return nil, nil, ErrUserBanned
+	}
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) > apiKeyTouchInterval {
+		s.db.Model(&key).UpdateColumn("last_used_at", now)
+		key.LastUsedAt = &now
+	}
+	return &user, &key, nil
+}
+
+// ListAPIKeys 用户的 API 密钥（含已吊销的，便于审计）
+func (s *APIKeyService) ListAPIKeys(userID uint) ([]models.APIKey, error) {
+	var keys []models.APIKey
+	err := s.db.Where("user_id = ?", userID).Order("created_at DESC").Find(&keys).Error
+	return keys, err
+}
+
+// RevokeAPIKey 吊销用户的某个 API 密钥
+func (s *APIKeyService) RevokeAPIKey(userID, keyID uint) error {
+	result := s.db.Model(&models.APIKey{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", keyID, userID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+func parseAPIKeyPrefix(raw string) (string, bool) {
+	rest, ok := strings.CutPrefix(raw, apiKeyTag)
+	if !ok {
+		return "", false
+	}
+	// prefix 为十六进制，不含 _
+	prefix, secret, ok := strings.Cut(rest, "_")
+	if !ok || secret == "" || (len(prefix) != apiKeyPrefixLen && len(prefix) != legacyAPIKeyPrefixLen) {
+		return "", false
+	}
+	return prefix, true
+}
+
+func normalizeScopes(scopes []string) []string {
+	set := make(map[string]struct{}, len(scopes))
+	for _, scope := range scopes {
+		if scope = strings.TrimSpace(scope); scope != "" {
+			set[scope] = struct{}{}
+		}
+	}
+	out := make([]string, 0, len(set))
+	for scope := range set {
+		out = append(out, scope)
+	}
+	sort.Strings(out)
+	return out
+}
@@ -0,0 +1,279 @@
+package services
+
+import (
+	"LiteAdmin/config"
+	"LiteAdmin/models"
+	"LiteAdmin/storage"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrFileTooLarge        = errors.New("file is too large")
+	ErrUnsupportedFileType = errors.New("unsupported file type")
+	ErrAttachmentNotFound  = errors.New("attachment not found")
+	ErrAttachmentInUse     = errors.New("attachment has already been sent")
+	ErrInvalidDownloadURL  = errors.New("download link is invalid or expired")
+)
+
+const (
+	defaultMaxUploadMB = 20
+	defaultURLExpiry   = time.Hour
+
+	// 下载变体：原文件或缩略图
+	AttachmentOriginal  = "original"
+	AttachmentThumbnail = "thumb"
+)
+
+// AttachmentView 返回给客户端的附件信息，URL 为当前用户专属的签名下载链接
+type AttachmentView struct {
+	models.Attachment
+	URL          string    `json:"url"`
+	ThumbnailURL string    `json:"thumbnail_url,omitempty"`
+	URLExpiresAt time.Time `json:"url_expires_at"`
+}
+
+// AttachmentService 聊天附件的上传、签名下载链接和读取
+type AttachmentService struct {
+	db     *gorm.DB
+	blob   storage.Blob
+	cfg    *config.StorageConfig
+	secret []byte
+}
+
+func NewAttachmentService(db *gorm.DB, blob storage.Blob, cfg *config.StorageConfig) *AttachmentService {
+	secret := []byte(cfg.URLSecret)
+	if len(secret) == 0 {
+		// 未配置时使用随机密钥：重启或多节点部署时已签发的链接会失效
+		log.Println("storage.url_secret is not set, attachment download links are only valid on this process")
+		secret = make([]byte, 32)
+		rand.Read(secret)
+	}
+	return &AttachmentService{db: db, blob: blob, cfg: cfg, secret: secret}
+}
+
+// MaxUploadSize 单个附件的最大字节数
+func (s *AttachmentService) MaxUploadSize() int64 {
+	mb := s.cfg.MaxUploadSize
+	if mb <= 0 {
+		mb = defaultMaxUploadMB
+	}
+	return int64(mb) << 20
+}
+
+// Upload 校验大小和类型后保存附件，图片同时生成缩略图；附件在随消息发送前不会出现在房间中
+func (s *AttachmentService) Upload(ctx context.Context, chatRoomID string, userID uint, filename, declaredType string, r io.Reader) (*models.Attachment, error) {
+	data, err := io.ReadAll(io.LimitReader(r, s.MaxUploadSize()+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > s.MaxUploadSize() {
+		return nil, ErrFileTooLarge
+	}
+	if len(data) == 0 {
+		return nil, ErrUnsupportedFileType
+	}
+	kind, contentType := detectAttachmentType(data, declaredType)
+	if kind == "" {
+		return nil, ErrUnsupportedFileType
+	}
+
+	id := uuid.New().String()
+	attachment := &models.Attachment{
+		RoomID:      chatRoomID,
+		UserID:      userID,
+		Kind:        kind,
+		Filename:    cleanFilename(filename),
+		ContentType: contentType,
+		Size:        int64(len(data)),
+		StorageKey:  fmt.Sprintf("chat/%s/%s", chatRoomID, id),
+	}
+	if err := s.blob.Put(ctx, attachment.StorageKey, bytes.NewReader(data), attachment.Size, contentType); err != nil {
+		return nil, err
+	}
+	if kind == models.AttachmentImage {
+		thumb, width, height, err := makeThumbnail(data)
+		attachment.Width, attachment.Height = width, height
+		if err == nil {
+			thumbKey := attachment.StorageKey + "_thumb.jpg"
+			if err := s.blob.Put(ctx, thumbKey, bytes.NewReader(thumb), int64(len(thumb)), "image/jpeg"); err != nil {
+				log.Printf("Failed to store thumbnail for %s: %v", attachment.StorageKey, err)
+			} else {
+				attachment.ThumbnailKey = thumbKey
+			}
+		}
+	}
+	if err := s.db.Create(attachment).Error; err != nil {
+		s.removeBlobs(ctx, attachment)
+		return nil, err
+	}
+	return attachment, nil
+}
+
+// GetAttachment 房间内的附件
+func (s *AttachmentService) GetAttachment(chatRoomID string, id uint) (*models.Attachment, error) {
+	var attachment models.Attachment
+	if err := s.db.Where("id = ? AND room_id = ?", id, chatRoomID).First(&attachment).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrAttachmentNotFound
+		}
+		return nil, err
+	}
+	return &attachment, nil
+}
+
+// Attachments 批量获取附件
+func (s *AttachmentService) Attachments(ids []uint) (map[uint]*models.Attachment, error) {
+	result := make(map[uint]*models.Attachment, len(ids))
+	if len(ids) == 0 {
+		return result, nil
+	}
+	var attachments []models.Attachment
+	if err := s.db.Where("id IN ?", ids).Find(&attachments).Error; err != nil {
+		return nil, err
+	}
+	for i := range attachments {
+		result[attachments[i].ID] = &attachments[i]
+	}
+	return result, nil
+}
+
+// View 生成带签名下载链接的附件信息，链接只对 userID 有效
+func (s *AttachmentService) View(attachment *models.Attachment, userID uint) AttachmentView {
+	expiresAt := time.Now().Add(s.urlExpiry()).Truncate(time.Second)
+	view := AttachmentView{
+		Attachment:   *attachment,
+		URL:          s.signedURL(attachment.ID, AttachmentOriginal, userID, expiresAt),
+		URLExpiresAt: expiresAt,
+	}
+	if attachment.ThumbnailKey != "" {
+		view.ThumbnailURL = s.signedURL(attachment.ID, AttachmentThumbnail, userID, expiresAt)
+	}
+	return view
+}
+
+// VerifyDownload 校验签名下载链接，返回附件和链接所属用户
+func (s *AttachmentService) VerifyDownload(id uint, variant string, userID uint, expires int64, signature string) (*models.Attachment, error) {
+	if variant != AttachmentOriginal && variant != AttachmentThumbnail {
+		return nil, ErrInvalidDownloadURL
+	}
+	if time.Now().Unix() > expires {
+		return nil, ErrInvalidDownloadURL
+	}
+	expected := s.sign(id, variant, userID, expires)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return nil, ErrInvalidDownloadURL
+	}
+	var attachment models.Attachment
+	if err := s.db.First(&attachment, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrAttachmentNotFound
+		}
+		return nil, err
+	}
+	if variant == AttachmentThumbnail && attachment.ThumbnailKey == "" {
+		return nil, ErrAttachmentNotFound
+	}
+	return &attachment, nil
+}
+
+// Open 读取附件内容，返回内容和 Content-Type
+func (s *AttachmentService) Open(ctx context.Context, attachment *models.Attachment, variant string) (io.ReadCloser, string, error) {
+	key, contentType := attachment.StorageKey, attachment.ContentType
+	if variant == AttachmentThumbnail {
+		key, contentType = attachment.ThumbnailKey, "image/jpeg"
+	}
+	body, err := s.blob.Get(ctx, key)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, "", ErrAttachmentNotFound
+	}
+	return body, contentType, err
+}
+
+func (s *AttachmentService) removeBlobs(ctx context.Context, attachment *models.Attachment) {
+	for _, key := range []string{attachment.StorageKey, attachment.ThumbnailKey} {
+		if key == "" {
+			continue
+		}
+		if err := s.blob.Delete(ctx, key); err != nil {
+			log.Printf("Failed to delete blob %s: %v", key, err)
+		}
+	}
+}
+
+func (s *AttachmentService) urlExpiry() time.Duration {
+	if s.cfg.URLExpiry > 0 {
+		return time.Duration(s.cfg.URLExpiry) * time.Minute
+	}
+	return defaultURLExpiry
+}
+
+func (s *AttachmentService) signedURL(id uint, variant string, userID uint, expiresAt time.Time) string {
+	query := url.Values{}
+	query.Set("variant", variant)
+	query.Set("uid", strconv.FormatUint(uint64(userID), 10))
+	query.Set("exp", strconv.FormatInt(expiresAt.Unix(), 10))
+	query.Set("sig", s.sign(id, variant, userID, expiresAt.Unix()))
+	return fmt.Sprintf("%s/api/v1/files/%d?%s", strings.TrimSuffix(s.cfg.PublicBaseURL, "/"), id, query.Encode())
+}
+
+func (s *AttachmentService) sign(id uint, variant string, userID uint, expires int64) string {
+	mac := hmac.New(sha256.New, s.secret)
+	fmt.Fprintf(mac, "%d:%s:%d:%d", id, variant, userID, expires)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// detectAttachmentType 按文件内容判断类型，不信任客户端声明的 Content-Type；
+// 浏览器录音常见的 webm/ogg/mp4 容器只有在声明为 audio/* 时才作为语音接受
+func detectAttachmentType(data []byte, declared string) (kind, contentType string) {
+	sniffed := http.DetectContentType(data)
+	switch sniffed {
+	case "image/jpeg", "image/png", "image/gif", "image/webp":
+		return models.AttachmentImage, sniffed
+	case "application/pdf":
+		return models.AttachmentFile, sniffed
+	case "text/plain; charset=utf-8":
+		return models.AttachmentFile, "text/plain; charset=utf-8"
+	case "audio/mpeg", "audio/wave", "audio/aiff":
+		return models.AttachmentAudio, sniffed
+	case "application/ogg", "video/webm", "video/mp4":
+		declared = strings.ToLower(strings.TrimSpace(strings.SplitN(declared, ";", 2)[0]))
+		switch declared {
+		case "audio/ogg", "audio/webm", "audio/mp4", "audio/x-m4a", "audio/aac":
+			return models.AttachmentAudio, declared
+		}
+	}
+	return "", ""
+}
+
+// cleanFilename 只保留文件名部分并限制长度
+func cleanFilename(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	if name == "." || name == "/" {
+		name = "file"
+	}
+	name = strings.Map(func(r rune) rune {
+		if r < ' ' || r == '"' {
+			return -1
+		}
+		return r
+	}, name)
+	return truncateRunes(name, 200)
+}
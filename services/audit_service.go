@@ -0,0 +1,296 @@
+package services
+
+import (
+	"LiteAdmin/models"
+	"context"
+	"encoding/json"
+	"log"
+	"reflect"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 审计动作，格式为 资源.操作
+const (
+	AuditCategoryCreate   = "category.create"
+	AuditCategoryUpdate   = "category.update"
+	AuditCategoryDelete   = "category.delete"
+	AuditRoomDelete       = "room.delete"
+	AuditRoomMemberRole   = "room.member_role"
+	AuditRoomMemberRemove = "room.member_remove"
+	AuditRoomKick         = "room.kick"
+	AuditRoomBan          = "room.ban"
+	AuditRoomUnban        = "room.unban"
+	AuditRoomMute         = "room.mute"
+	AuditRoomUnmute       = "room.unmute"
+	AuditRoomSlowMode     = "room.slow_mode"
+	AuditSessionStatus    = "customer_session.status"
+	AuditSessionAssign    = "customer_session.assign"
+	AuditSessionTransfer  = "customer_session.transfer"
+	AuditSessionRelease   = "customer_session.release"
+	AuditSessionEscalate  = "customer_session.escalate"
+	AuditCannedCreate     = "canned_response.create"
+	AuditCannedUpdate     = "canned_response.update"
+	AuditCannedDelete     = "canned_response.delete"
+	AuditCannedUse        = "canned_response.use"
+	AuditPetCreate        = "pet.create"
+	AuditPetUpdate        = "pet.update"
+	AuditLogin            = "auth.login"
+	AuditLoginFailed      = "auth.login_failed"
+	AuditDeviceLogin      = "auth.device_login"
+	AuditOAuthLogin       = "auth.oauth_login"
+	AuditIdentityLink     = "auth.identity_link"
+	AuditIdentityUnlink   = "auth.identity_unlink"
+	AuditPasswordChange   = "account.password_change"
+	AuditEmailChange      = "account.email_change"
+	AuditAccountDelete    = "account.delete"
+	AuditAPIKeyCreate     = "api_key.create"
+	AuditAPIKeyRevoke     = "api_key.revoke"
+	AuditUserTypeChange   = "user.type_change"
+	AuditUserBan          = "user.ban"
+	AuditUserUnban        = "user.unban"
+	AuditUserForceLogout  = "user.force_logout"
+	AuditRoleAssign       = "role.assign"
+	AuditRoleRemove       = "role.remove"
+)
+
+const (
+	defaultAuditPageSize = 50
+	maxAuditPageSize     = 500
+	auditExportBatchSize = 1000
+	auditExportMaxRows   = 100000
+	// 每次更新都会变化，不计入差异
+	auditIgnoredField = "updated_at"
+)
+
+const (
+	auditActorContextKey   = auditContextKey("actor")
+	auditRequestContextKey = auditContextKey("request")
+)
+
+type auditContextKey string
+
+// AuditRequest 请求来源，由中间件写入 context
+type AuditRequest struct {
+	IP        string
+	UserAgent string
+}
+
+// WithAuditRequest 把请求来源写入 context
+func WithAuditRequest(ctx context.Context, req AuditRequest) context.Context {
+	return context.WithValue(ctx, auditRequestContextKey, req)
+}
+
+// WithAuditActor 把当前操作者写入 context
+func WithAuditActor(ctx context.Context, user *models.User) context.Context {
+	return context.WithValue(ctx, auditActorContextKey, user)
+}
+
+// AuditEntry 一条待写入的审计记录，Actor 为空时从 context 中获取
+type AuditEntry struct {
+	Actor      *models.User
+	Action     string
+	TargetType string
+	TargetID   interface{}
+	Before     interface{} // 变更前的对象，创建时为 nil
+	After      interface{} // 变更后的对象，删除时为 nil
+}
+
+// AuditFilter 审计日志查询条件
+type AuditFilter struct {
+	ActorID    uint
+	Action     string
+	TargetType string
+	TargetID   string
+	From       *time.Time
+	To         *time.Time
+	Page       int
+	PageSize   int
+}
+
+type AuditService struct {
+	db *gorm.DB
+}
+
+func NewAuditService(db *gorm.DB) *AuditService {
+	return &AuditService{db: db}
+}
+
+// Record 写入审计记录，失败只记录日志，不影响业务操作
+func (s *AuditService) Record(ctx context.Context, entry AuditEntry) {
+	if err := s.RecordTx(ctx, s.db, entry); err != nil {
+		log.Printf("Failed to write audit log %s: %v", entry.Action, err)
+	}
+}
+
+// RecordTx 在业务事务中写入审计记录，写入失败时整个事务回滚
+func (s *AuditService) RecordTx(ctx context.Context, tx *gorm.DB, entry AuditEntry) error {
+	row := buildAuditLog(ctx, entry)
+	return tx.Create(row).Error
+}
+
+// Query 分页查询审计日志，按时间倒序
+func (s *AuditService) Query(filter AuditFilter) ([]models.AuditLog, int64, error) {
+	query := s.filtered(filter)
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	page, pageSize := filter.Page, filter.PageSize
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 {
+		pageSize = defaultAuditPageSize
+	}
+	if pageSize > maxAuditPageSize {
+		pageSize = maxAuditPageSize
+	}
+	var logs []models.AuditLog
+	err := query.Order("id DESC").Offset((page - 1) * pageSize).Limit(pageSize).Find(&logs).Error
+	return logs, total, err
+}
+
+// Export 按 id 倒序分批遍历符合条件的审计日志（最多 auditExportMaxRows 条），用于导出
+func (s *AuditService) Export(filter AuditFilter, fn func([]models.AuditLog) error) error {
+	var lastID uint
+	for exported := 0; exported < auditExportMaxRows; {
+		limit := auditExportBatchSize
+		if remaining := auditExportMaxRows - exported; remaining < limit {
+			limit = remaining
+		}
+		query := s.filtered(filter)
+		if lastID != 0 {
+			query = query.Where("id < ?", lastID)
+		}
+		var rows []models.AuditLog
+		if err := query.Order("id DESC").Limit(limit).Find(&rows).Error; err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+		if err := fn(rows); err != nil {
+			return err
+		}
+		exported += len(rows)
+		lastID = rows[len(rows)-1].ID
+		if len(rows) < limit {
+			return nil
+		}
+	}
+	return nil
+}
+
+func (s *AuditService) filtered(filter AuditFilter) *gorm.DB {
+	query := s.db.Model(&models.AuditLog{})
+	if filter.ActorID != 0 {
+		query = query.Where("actor_id = ?", filter.ActorID)
+	}
+	if filter.Action != "" {
+		query = query.Where("action = ?", filter.Action)
+	}
+	if filter.TargetType != "" {
+		query = query.Where("target_type = ?", filter.TargetType)
+	}
+	if filter.TargetID != "" {
+		query = query.Where("target_id = ?", filter.TargetID)
+	}
+	if filter.From != nil {
+		query = query.Where("created_at >= ?", *filter.From)
+	}
+	if filter.To != nil {
+		query = query.Where("created_at < ?", *filter.To)
+	}
+	return query
+}
+
+func buildAuditLog(ctx context.Context, entry AuditEntry) *models.AuditLog {
+	row := &models.AuditLog{
+		Action:     entry.Action,
+		TargetType: entry.TargetType,
+		TargetID:   auditTargetID(entry.TargetID),
+	}
+	actor := entry.Actor
+	if actor == nil {
+		actor, _ = ctx.Value(auditActorContextKey).(*models.User)
+	}
+	if actor != nil {
+		row.ActorID = actor.ID
+		row.ActorName = actor.Email
+		if row.ActorName == "" {
+			row.ActorName = actor.Username
+		}
+	}
+	if req, ok := ctx.Value(auditRequestContextKey).(AuditRequest); ok {
+		row.IP = req.IP
+		row.UserAgent = req.UserAgent
+	}
+	row.Before, row.After = auditDiff(entry.Before, entry.After)
+	return row
+}
+
+// auditDiff 只保留变化的字段；创建/删除时分别记录完整的 after/before
+func auditDiff(before, after interface{}) (map[string]interface{}, map[string]interface{}) {
+	b := auditFields(before)
+	a := auditFields(after)
+	if b == nil || a == nil {
+		return b, a
+	}
+	changedBefore := map[string]interface{}{}
+	changedAfter := map[string]interface{}{}
+	for key, value := range a {
+		if key == auditIgnoredField {
+			continue
+		}
+		if old, ok := b[key]; !ok || !reflect.DeepEqual(old, value) {
+			changedBefore[key] = b[key]
+			changedAfter[key] = value
+		}
+	}
+	for key, old := range b {
+		if _, ok := a[key]; !ok && key != auditIgnoredField {
+			changedBefore[key] = old
+		}
+	}
+	return changedBefore, changedAfter
+}
+
+// auditFields 通过 JSON 序列化得到字段表，json:"-" 的敏感字段（如密码）不会进入日志
+func auditFields(v interface{}) map[string]interface{} {
+	if v == nil {
+		return nil
+	}
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Ptr && rv.IsNil() {
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return map[string]interface{}{"value": json.RawMessage(data)}
+	}
+	return fields
+}
+
+func auditTargetID(id interface{}) string {
+	switch v := id.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case uint:
+		return strconv.FormatUint(uint64(v), 10)
+	case uint64:
+		return strconv.FormatUint(v, 10)
+	case int:
+		return strconv.Itoa(v)
+	default:
+		data, _ := json.Marshal(v)
+		return string(data)
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"LiteAdmin/config"
 	"LiteAdmin/models"
 	"errors"
+	"log"
+	"strconv"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -11,56 +13,87 @@ import (
 	"gorm.io/gorm"
 )
 
+var (
+	ErrEmailAlreadyRegistered  = errors.New("email already registered, sign in and link this provider from your account")
+	ErrIdentityLinkedElsewhere = errors.New("this provider account is linked to another user")
+	ErrIdentityNotFound        = errors.New("identity not found")
+	ErrLastLoginMethod         = errors.New("cannot unlink the last login method")
+	ErrTokenRevoked            = errors.New("token has been revoked")
+	ErrUserBanned              = errors.New("account is banned")
+)
+
+// 令牌签发方，供其他服务校验 iss
+const tokenIssuer = "shophub"
+
 type AuthService struct {
 	Db            *gorm.DB
-	jwtSecret     []byte
+	keys          *KeyManager
+	jwtSecret     []byte    // 仅用于校验切换前签发的 HS256 旧令牌
+	legacyCutover time.Time // 零值表示不接受 HS256 令牌
 	tokenExpiry   time.Duration
 	refreshExpiry time.Duration
+	deviceExpiry  time.Duration
 }
 
-func NewAuthService(db *gorm.DB, config *config.AuthConfig) *AuthService {
-	return &AuthService{
+func NewAuthService(db *gorm.DB, config *config.AuthConfig, keys *KeyManager) *AuthService {
+	service := &AuthService{
 		Db:            db,
+		keys:          keys,
 		jwtSecret:     []byte(config.JWTSecret),
 		tokenExpiry:   time.Duration(config.TokenExpiry) * time.Hour,
 		refreshExpiry: time.Duration(config.RefreshExpiry) * time.Hour,
+		deviceExpiry:  time.Duration(config.DeviceExpiry) * time.Hour,
+	}
+	if config.LegacyHS256Cutover != "" {
+		cutover, err := time.Parse(time.RFC3339, config.LegacyHS256Cutover)
+		if err != nil {
+			log.Printf("Invalid legacy_hs256_cutover %q, HS256 tokens will be rejected: %v", config.LegacyHS256Cutover, err)
+		} else {
+			service.legacyCutover = cutover
+		}
 	}
+	return service
 }
 
 type Claims struct {
-	UserID   uint   `json:"user_id"`
-	Email    string `json:"email"`
-	Username string `json:"username"`
+	UserID       uint   `json:"user_id"`
+	Email        string `json:"email"`
+	Username     string `json:"username"`
+	TokenVersion uint   `json:"tv,omitempty"` // 与 users.token_version 不一致即视为已吊销
 	jwt.RegisteredClaims
 }
 
 func (s *AuthService) GenerateTokens(user *models.User) (*models.AuthResponse, error) {
 	// Access Token
 	accessClaims := &Claims{
-		UserID:   user.ID,
-		Email:    user.Email,
-		Username: user.Username,
+		UserID:       user.ID,
+		Email:        user.Email,
+		Username:     user.Username,
+		TokenVersion: user.TokenVersion,
 		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    tokenIssuer,
+			Subject:   strconv.FormatUint(uint64(user.ID), 10),
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(s.tokenExpiry)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 		},
 	}
-	accessToken := jwt.NewWithClaims(jwt.SigningMethodHS256, accessClaims)
-	accessTokenString, err := accessToken.SignedString(s.jwtSecret)
+	accessTokenString, err := s.keys.Sign(accessClaims)
 	if err != nil {
 		return nil, err
 	}
 
 	// Refresh Token
 	refreshClaims := &Claims{
-		UserID: user.ID,
+		UserID:       user.ID,
+		TokenVersion: user.TokenVersion,
 		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    tokenIssuer,
+			Subject:   strconv.FormatUint(uint64(user.ID), 10),
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(s.refreshExpiry)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 		},
 	}
-	refreshToken := jwt.NewWithClaims(jwt.SigningMethodHS256, refreshClaims)
-	refreshTokenString, err := refreshToken.SignedString(s.jwtSecret)
+	refreshTokenString, err := s.keys.Sign(refreshClaims)
 	if err != nil {
 		return nil, err
 	}
@@ -75,9 +108,21 @@ func (s *AuthService) GenerateTokens(user *models.User) (*models.AuthResponse, e
 }
 
 func (s *AuthService) ValidateToken(tokenString string) (*Claims, error) {
+	methods := s.keys.Algorithms()
+	if s.acceptsLegacyTokens() {
+		methods = append(methods, jwt.SigningMethodHS256.Alg())
+	}
 	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		return s.jwtSecret, nil
-	})
+		// 兼容切换到非对称签名前签发的 HS256 令牌：只认切换前签发的，且过了一个刷新令牌有效期后不再接受
+		if token.Method == jwt.SigningMethodHS256 {
+			issuedAt, err := token.Claims.GetIssuedAt()
+			if err != nil || issuedAt == nil || issuedAt.After(s.legacyCutover) {
+				return nil, errors.New("legacy HS256 token issued after cutover")
+			}
+			return s.jwtSecret, nil
+		}
+		return s.keys.PublicKey(token)
+	}, jwt.WithValidMethods(methods))
 
 	if err != nil {
 		return nil, err
@@ -90,6 +135,48 @@ func (s *AuthService) ValidateToken(tokenString string) (*Claims, error) {
 	return nil, errors.New("invalid token")
 }
 
+// acceptsLegacyTokens 配置了切换时间且仍在刷新令牌有效期内时才接受 HS256 旧令牌
+func (s *AuthService) acceptsLegacyTokens() bool {
+	return !s.legacyCutover.IsZero() && len(s.jwtSecret) > 0 &&
+		time.Now().Before(s.legacyCutover.Add(s.refreshExpiry))
+}
+
+// AuthenticateToken 校验令牌并加载用户，令牌版本落后于用户当前版本时返回 ErrTokenRevoked
+func (s *AuthService) AuthenticateToken(tokenString string) (*models.User, error) {
+	claims, err := s.ValidateToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	var user models.User
+	if err := s.Db.First(&user, claims.UserID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+	if claims.TokenVersion != user.TokenVersion {
+		return nil, ErrTokenRevoked
+	}
+	if user.BannedAt != nil {
+		return nil, ErrUserBanned
+	}
+	return &user, nil
+}
+
+// RevokeAllTokens 吊销用户的全部凭据：已签发的 JWT、设备令牌和 API 密钥
+func (s *AuthService) RevokeAllTokens(tx *gorm.DB, userID uint) error {
+	if err := tx.Model(&models.User{}).Where("id = ?", userID).
+		UpdateColumn("token_version", gorm.Expr("token_version + 1")).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("user_id = ?", userID).Delete(&models.DeviceToken{}).Error; err != nil {
+		return err
+	}
+	return tx.Model(&models.APIKey{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error
+}
+
 func (s *AuthService) RegisterLocal(email, username, password string) (*models.User, error) {
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
@@ -119,37 +206,169 @@ func (s *AuthService) LoginLocal(email, password string) (*models.User, error) {
 	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
 		return nil, errors.New("invalid credentials")
 	}
+	if user.BannedAt != nil {
+		return nil, ErrUserBanned
+	}
 
 	return &user, nil
 }
 
+// FindOrCreateOAuthUser 根据第三方身份查找或创建用户
+// 自动关联策略：只有 provider 声明邮箱已验证、且已有用户的邮箱也经过验证时，才会把新身份绑定到同邮箱的已有用户；
+// 否则返回 ErrEmailAlreadyRegistered，需要用户先登录原账号再手动绑定。
+// 本地注册不验证邮箱，若直接关联，攻击者可以先用受害者邮箱注册，等受害者第三方登录后进入攻击者设好密码的账号
 func (s *AuthService) FindOrCreateOAuthUser(userInfo *OAuthUserInfo, userType string) (*models.User, error) {
 	var user models.User
 
-	// Try to find existing user
-	err := s.Db.Where("provider = ? AND provider_id = ?", userInfo.Provider, userInfo.ID).First(&user).Error
+	// 1. 已绑定的身份
+	var identity models.UserIdentity
+	err := s.Db.Where("provider = ? AND provider_id = ?", userInfo.Provider, userInfo.ID).First(&identity).Error
+	if err == nil {
+		if err := s.Db.First(&user, identity.UserID).Error; err != nil {
+			return nil, err
+		}
+		s.refreshIdentity(&identity, &user, userInfo)
+		return &user, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
 
+	// 2. 旧数据：身份信息直接记录在 users 表上，补建身份记录
+	err = s.Db.Where("provider = ? AND provider_id = ?", userInfo.Provider, userInfo.ID).First(&user).Error
 	if err == nil {
-		// User exists, update info
-		user.Email = userInfo.Email
-		user.Avatar = userInfo.Avatar
-		s.Db.Save(&user)
+		if err := s.Db.Create(newIdentity(user.ID, userInfo)).Error; err != nil {
+			return nil, err
+		}
 		return &user, nil
 	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
 
-	// Create new user
-	user = models.User{
-		Email:      userInfo.Email,
-		Username:   userInfo.Name,
-		Provider:   userInfo.Provider,
-		ProviderID: userInfo.ID,
-		Avatar:     userInfo.Avatar,
-		Type:       userType,
+	// 3. 同邮箱的已有用户
+	if userInfo.Email != "" {
+		err = s.Db.Where("email = ?", userInfo.Email).First(&user).Error
+		if err == nil {
+			if !userInfo.EmailVerified || !user.EmailVerified {
+				return nil, ErrEmailAlreadyRegistered
+			}
+			if err := s.Db.Create(newIdentity(user.ID, userInfo)).Error; err != nil {
+				return nil, err
+			}
+			return &user, nil
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
 	}
 
-	if err := s.Db.Create(&user).Error; err != nil {
+	// 4. 创建新用户
+	user = models.User{
+		Email:         userInfo.Email,
+		EmailVerified: userInfo.Email != "" && userInfo.EmailVerified,
+		Username:      userInfo.Name,
+		Provider:      userInfo.Provider,
+		ProviderID:    userInfo.ID,
+		Avatar:        userInfo.Avatar,
+		Type:          userType,
+	}
+	err = s.Db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+		return tx.Create(newIdentity(user.ID, userInfo)).Error
+	})
+	if err != nil {
 		return nil, err
 	}
 
 	return &user, nil
 }
+
+// refreshIdentity 登录时同步 provider 返回的最新资料
+func (s *AuthService) refreshIdentity(identity *models.UserIdentity, user *models.User, userInfo *OAuthUserInfo) {
+	identity.Email = userInfo.Email
+	identity.EmailVerified = userInfo.EmailVerified
+	identity.Name = userInfo.Name
+	identity.Avatar = userInfo.Avatar
+	s.Db.Save(identity)
+	if user.Avatar == "" && userInfo.Avatar != "" {
+		user.Avatar = userInfo.Avatar
+		s.Db.Model(user).Update("avatar", user.Avatar)
+	}
+}
+
+// LinkIdentity 将第三方身份绑定到当前登录用户
+func (s *AuthService) LinkIdentity(userID uint, userInfo *OAuthUserInfo) (*models.UserIdentity, error) {
+	var identity models.UserIdentity
+	err := s.Db.Where("provider = ? AND provider_id = ?", userInfo.Provider, userInfo.ID).First(&identity).Error
+	if err == nil {
+		if identity.UserID != userID {
+			return nil, ErrIdentityLinkedElsewhere
+		}
+		return &identity, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	// 旧数据中该身份作为另一个用户的主身份存在
+	var count int64
+	s.Db.Model(&models.User{}).
+		Where("provider = ? AND provider_id = ? AND id <> ?", userInfo.Provider, userInfo.ID, userID).
+		Count(&count)
+	if count > 0 {
+		return nil, ErrIdentityLinkedElsewhere
+	}
+	identity = *newIdentity(userID, userInfo)
+	if err := s.Db.Create(&identity).Error; err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
+
+// ListIdentities 用户已绑定的第三方身份
+func (s *AuthService) ListIdentities(userID uint) ([]models.UserIdentity, error) {
+	var identities []models.UserIdentity
+	err := s.Db.Where("user_id = ?", userID).Order("id ASC").Find(&identities).Error
+	return identities, err
+}
+
+// UnlinkIdentity 解绑第三方身份，至少保留一种登录方式
+func (s *AuthService) UnlinkIdentity(user *models.User, identityID uint) error {
+	return s.Db.Transaction(func(tx *gorm.DB) error {
+		var identity models.UserIdentity
+		if err := tx.Where("id = ? AND user_id = ?", identityID, user.ID).First(&identity).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrIdentityNotFound
+			}
+			return err
+		}
+		var count int64
+		if err := tx.Model(&models.UserIdentity{}).Where("user_id = ?", user.ID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count <= 1 && user.Password == "" {
+			return ErrLastLoginMethod
+		}
+		// 旧数据把主身份记录在 users 表上，一并清除，避免下次登录时被重新关联
+		if user.Provider == identity.Provider && user.ProviderID == identity.ProviderID {
+			if err := tx.Model(user).Update("provider_id", "").Error; err != nil {
+				return err
+			}
+		}
+		return tx.Delete(&identity).Error
+	})
+}
+
+func newIdentity(userID uint, userInfo *OAuthUserInfo) *models.UserIdentity {
+	return &models.UserIdentity{
+		UserID:        userID,
+		Provider:      userInfo.Provider,
+		ProviderID:    userInfo.ID,
+		Email:         userInfo.Email,
+		EmailVerified: userInfo.EmailVerified,
+		Name:          userInfo.Name,
+		Avatar:        userInfo.Avatar,
+	}
+}
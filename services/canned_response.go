@@ -0,0 +1,317 @@
+package services
+
+import (
+	"LiteAdmin/models"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrCannedResponseNotFound = errors.New("canned response not found")
+	ErrInvalidShortcut        = errors.New("shortcut must be 1-32 lowercase letters, digits, '-' or '_'")
+	ErrInvalidCannedResponse  = errors.New("title (max 100 characters) and content are required")
+	ErrShortcutTaken          = errors.New("shortcut is already in use")
+	ErrMissingVariables       = errors.New("missing template variables")
+	ErrNotCustomerService     = errors.New("templates can only be sent by agents in customer service rooms")
+)
+
+const maxCannedTitleLength = 100
+
+var (
+	shortcutPattern = regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+	// templateVariable 匹配 {{ name }}，变量名为小写字母、数字和下划线
+	templateVariable = regexp.MustCompile(`\{\{\s*([a-z_][a-z0-9_]*)\s*\}\}`)
+)
+
+// CannedResponseInput 创建/更新快捷回复
+type CannedResponseInput struct {
+	Shortcut string `json:"shortcut"` // 可带前导 /，保存时去掉并转为小写
+	Title    string `json:"title"`
+	Content  string `json:"content"`
+}
+
+// CannedResponseService 客服快捷回复：商家账号只能看到和维护本店铺的模板（以及只读的全局模板），
+// 其他客服维护全局模板；同一快捷码店铺模板优先于全局模板
+type CannedResponseService struct {
+	db    *gorm.DB
+	audit *AuditService
+}
+
+func NewCannedResponseService(db *gorm.DB, audit *AuditService) *CannedResponseService {
+	return &CannedResponseService{db: db, audit: audit}
+}
+
+// List 用户可用的模板，query 非空时按快捷码或标题前缀过滤
+func (s *CannedResponseService) List(user *models.User, query string) ([]models.CannedResponse, error) {
+	merchantID, err := s.merchantOf(user)
+	if err != nil {
+		return nil, err
+	}
+	db := visibleTemplates(s.db, merchantID)
+	if kw := normalizeShortcut(query); kw != "" {
+		like := kw + "%"
+		db = db.Where("shortcut LIKE ? OR LOWER(title) LIKE ?", like, like)
+	}
+	var templates []models.CannedResponse
+	err = db.Order("merchant_id IS NULL ASC, shortcut ASC").Find(&templates).Error
+	return templates, err
+}
+
+// Create 新建模板，范围由创建者决定：商家账号创建店铺模板，其他客服创建全局模板
+func (s *CannedResponseService) Create(ctx context.Context, user *models.User, input CannedResponseInput) (*models.CannedResponse, error) {
+	merchantID, err := s.merchantOf(user)
+	if err != nil {
+		return nil, err
+	}
+	template := &models.CannedResponse{MerchantID: merchantID, CreatedBy: user.ID}
+	if err := s.apply(template, input); err != nil {
+		return nil, err
+	}
+	if err := s.db.Create(template).Error; err != nil {
+		return nil, err
+	}
+	s.audit.Record(ctx, AuditEntry{
+		Actor:      user,
+		Action:     AuditCannedCreate,
+		TargetType: "canned_response",
+		TargetID:   template.ID,
+		After:      template,
+	})
+	return template, nil
+}
+
+// Update 修改模板，只能修改自己范围内的模板
+func (s *CannedResponseService) Update(ctx context.Context, user *models.User, id uint, input CannedResponseInput) (*models.CannedResponse, error) {
+	template, err := s.editable(user, id)
+	if err != nil {
+		return nil, err
+	}
+	before := *template
+	if err := s.apply(template, input); err != nil {
+		return nil, err
+	}
+	if err := s.db.Save(template).Error; err != nil {
+		return nil, err
+	}
+	s.audit.Record(ctx, AuditEntry{
+		Actor:      user,
+		Action:     AuditCannedUpdate,
+		TargetType: "canned_response",
+		TargetID:   template.ID,
+		Before:     before,
+		After:      template,
+	})
+	return template, nil
+}
+
+// Delete 删除模板，只能删除自己范围内的模板
+func (s *CannedResponseService) Delete(ctx context.Context, user *models.User, id uint) error {
+	template, err := s.editable(user, id)
+	if err != nil {
+		return err
+	}
+	if err := s.db.Delete(template).Error; err != nil {
+		return err
+	}
+	s.audit.Record(ctx, AuditEntry{
+		Actor:      user,
+		Action:     AuditCannedDelete,
+		TargetType: "canned_response",
+		TargetID:   template.ID,
+		Before:     template,
+	})
+	return nil
+}
+
+// Render 展开模板：按 ID 或快捷码查找客服可用的模板，替换变量后返回消息内容。
+// customer_name、agent_name、shop_name 由服务端填充，其余变量（如 pet_name）取自 variables，缺少时返回 ErrMissingVariables
+func (s *CannedResponseService) Render(agent *models.User, chatRoomID string, templateID uint, shortcut string, variables map[string]string) (*models.CannedResponse, string, error) {
+	if !strings.HasPrefix(chatRoomID, CustomerServiceRoomPrefix) {
+		return nil, "", ErrNotCustomerService
+	}
+	var session models.CustomerSession
+	err := s.db.Preload("User").
+		Where("room_id = ?", strings.TrimPrefix(chatRoomID, CustomerServiceRoomPrefix)).
+		First(&session).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, "", ErrRoomNotFound
+		}
+		return nil, "", err
+	}
+	if session.UserID == agent.ID {
+		return nil, "", ErrNotCustomerService
+	}
+
+	var merchant models.MerchantInfo
+	err = s.db.Where("user_id = ?", agent.ID).First(&merchant).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, "", err
+	}
+	var merchantID *uint
+	if merchant.ID != 0 {
+		merchantID = &merchant.ID
+	}
+	template, err := s.find(merchantID, templateID, shortcut)
+	if err != nil {
+		return nil, "", err
+	}
+
+	values := make(map[string]string, len(variables)+3)
+	for name, value := range variables {
+		values[name] = value
+	}
+	values["customer_name"] = session.User.Username
+	values["agent_name"] = agent.Username
+	if merchantID != nil {
+		values["shop_name"] = merchant.ShopName
+	}
+	content, err := expandTemplate(template.Content, values)
+	if err != nil {
+		return nil, "", err
+	}
+	return template, content, nil
+}
+
+// RecordUse 记录客服在哪条消息中使用了模板，并累加使用次数
+func (s *CannedResponseService) RecordUse(ctx context.Context, agent *models.User, template *models.CannedResponse, chatRoomID string, messageID uint) {
+	if err := s.db.Model(template).UpdateColumn("usage_count", gorm.Expr("usage_count + 1")).Error; err != nil {
+		log.Printf("Failed to update usage count of canned response %d: %v", template.ID, err)
+	}
+	s.audit.Record(ctx, AuditEntry{
+		Actor:      agent,
+		Action:     AuditCannedUse,
+		TargetType: "canned_response",
+		TargetID:   template.ID,
+		After: map[string]interface{}{
+			"shortcut":   template.Shortcut,
+			"room_id":    chatRoomID,
+			"message_id": messageID,
+		},
+	})
+}
+
+// find 按 ID 或快捷码查找可用模板；按快捷码查找时店铺模板优先
+func (s *CannedResponseService) find(merchantID *uint, templateID uint, shortcut string) (*models.CannedResponse, error) {
+	var template models.CannedResponse
+	db := visibleTemplates(s.db, merchantID)
+	if templateID != 0 {
+		db = db.Where("id = ?", templateID)
+	} else {
+		db = db.Where("shortcut = ?", normalizeShortcut(shortcut)).Order("merchant_id IS NULL ASC")
+	}
+	if err := db.First(&template).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrCannedResponseNotFound
+		}
+		return nil, err
+	}
+	return &template, nil
+}
+
+// editable 查找用户有权修改的模板：商家账号只能修改本店铺模板，其他客服只能修改全局模板
+func (s *CannedResponseService) editable(user *models.User, id uint) (*models.CannedResponse, error) {
+	merchantID, err := s.merchantOf(user)
+	if err != nil {
+		return nil, err
+	}
+	var template models.CannedResponse
+	if err := s.db.First(&template, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrCannedResponseNotFound
+		}
+		return nil, err
+	}
+	switch {
+	case template.MerchantID != nil && merchantID != nil && *template.MerchantID == *merchantID:
+		return &template, nil
+	case template.MerchantID == nil && merchantID == nil:
+		return &template, nil
+	case template.MerchantID == nil:
+		// 商家能看到全局模板但不能修改
+		return nil, ErrAccessDenied
+	default:
+		// 其他店铺的模板不可见
+		return nil, ErrCannedResponseNotFound
+	}
+}
+
+// apply 校验输入并写入模板，快捷码在同一范围内唯一
+func (s *CannedResponseService) apply(template *models.CannedResponse, input CannedResponseInput) error {
+	shortcut := normalizeShortcut(input.Shortcut)
+	if !shortcutPattern.MatchString(shortcut) {
+		return ErrInvalidShortcut
+	}
+	title := strings.TrimSpace(input.Title)
+	if title == "" || utf8.RuneCountInString(title) > maxCannedTitleLength ||
+		strings.TrimSpace(input.Content) == "" || utf8.RuneCountInString(input.Content) > maxMessageLength {
+		return ErrInvalidCannedResponse
+	}
+	query := s.db.Model(&models.CannedResponse{}).Where("shortcut = ? AND id <> ?", shortcut, template.ID)
+	if template.MerchantID == nil {
+		query = query.Where("merchant_id IS NULL")
+	} else {
+		query = query.Where("merchant_id = ?", *template.MerchantID)
+	}
+	var count int64
+	if err := query.Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrShortcutTaken
+	}
+	template.Shortcut, template.Title, template.Content = shortcut, title, input.Content
+	return nil
+}
+
+// merchantOf 商家账号对应的店铺 ID，非商家返回 nil
+func (s *CannedResponseService) merchantOf(user *models.User) (*uint, error) {
+	var merchant models.MerchantInfo
+	err := s.db.Select("id").Where("user_id = ?", user.ID).First(&merchant).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &merchant.ID, nil
+}
+
+// visibleTemplates 全局模板加上本店铺的模板
+func visibleTemplates(db *gorm.DB, merchantID *uint) *gorm.DB {
+	if merchantID == nil {
+		return db.Where("merchant_id IS NULL")
+	}
+	return db.Where("merchant_id IS NULL OR merchant_id = ?", *merchantID)
+}
+
+// expandTemplate 替换 {{变量}}，缺少的变量一并报告
+func expandTemplate(content string, values map[string]string) (string, error) {
+	var missing []string
+	expanded := templateVariable.ReplaceAllStringFunc(content, func(match string) string {
+		name := templateVariable.FindStringSubmatch(match)[1]
+		value, ok := values[name]
+		if !ok {
+			missing = append(missing, name)
+			return match
+		}
+		return value
+	})
+	if len(missing) > 0 {
+		slices.Sort(missing)
+		return "", fmt.Errorf("%w: %s", ErrMissingVariables, strings.Join(slices.Compact(missing), ", "))
+	}
+	return expanded, nil
+}
+
+func normalizeShortcut(shortcut string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.TrimSpace(shortcut), "/"))
+}
@@ -0,0 +1,47 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ChatControlChannel 聊天服务的控制频道，所有节点订阅后处理针对在线连接的管理操作
+const ChatControlChannel = "chat:control"
+
+// 控制指令
+const (
+	ChatControlDisconnectUser = "disconnect_user" // 断开用户的连接（指定 RoomID 时只断开该房间）
+	ChatControlSystemMessage  = "system_message"  // 向房间广播系统消息
+	ChatControlMuteUser       = "mute_user"       // 禁言用户，Until 为空表示永久
+	ChatControlUnmuteUser     = "unmute_user"     // 解除禁言
+	ChatControlSlowMode       = "slow_mode"       // 更新房间慢速模式
+	ChatControlQueueUpdate    = "queue_update"    // 向客服会话中的客户推送排队/接入状态
+)
+
+// ChatControlEvent 通过 Redis pub/sub 广播的控制指令
+type ChatControlEvent struct {
+	Action string `json:"action"`
+	UserID uint   `json:"user_id,omitempty"`
+	RoomID string `json:"room_id,omitempty"` // 为空表示所有房间
+	Reason string `json:"reason,omitempty"`
+
+	Content string       `json:"content,omitempty"` // system_message
+	Until   *time.Time   `json:"until,omitempty"`   // mute_user
+	Seconds int          `json:"seconds,omitempty"` // slow_mode
+	Queue   *QueueStatus `json:"queue,omitempty"`   // queue_update，只发给 UserID
+}
+
+// PublishChatControl 广播控制指令到所有聊天节点
+func PublishChatControl(ctx context.Context, client *redis.Client, event ChatControlEvent) error {
+	if client == nil {
+		return nil
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return client.Publish(ctx, ChatControlChannel, data).Err()
+}
@@ -0,0 +1,152 @@
+package services
+
+import (
+	"LiteAdmin/models"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrDeviceTokenInvalid = errors.New("invalid device token")
+	ErrDeviceNotFound     = errors.New("device not found")
+)
+
+// 未配置 device_expiry 时的默认有效期
+const defaultDeviceExpiry = 30 * 24 * time.Hour
+
+// DeviceMeta 签发设备令牌时记录的设备信息
+type DeviceMeta struct {
+	DeviceName string
+	UserAgent  string
+	IP         string
+}
+
+// DeviceTokenExpiry 设备令牌有效期
+func (s *AuthService) DeviceTokenExpiry() time.Duration {
+	if s.deviceExpiry <= 0 {
+		return defaultDeviceExpiry
+	}
+	return s.deviceExpiry
+}
+
+// HashDeviceToken 计算设备令牌的存储哈希
+func HashDeviceToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func newOpaqueToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// IssueDeviceToken 为用户签发一个新的设备令牌，返回明文令牌（只在此时可见）
+func (s *AuthService) IssueDeviceToken(user *models.User, meta DeviceMeta) (string, *models.DeviceToken, error) {
+	return s.issueDeviceToken(s.Db, user.ID, meta)
+}
+
+func (s *AuthService) issueDeviceToken(tx *gorm.DB, userID uint, meta DeviceMeta) (string, *models.DeviceToken, error) {
+	raw, err := newOpaqueToken()
+	if err != nil {
+		return "", nil, err
+	}
+	now := time.Now()
+	device := &models.DeviceToken{
+		UserID:     userID,
+		TokenHash:  HashDeviceToken(raw),
+		DeviceName: meta.DeviceName,
+		UserAgent:  meta.UserAgent,
+		IP:         meta.IP,
+		LastUsedAt: now,
+		ExpiresAt:  now.Add(s.DeviceTokenExpiry()),
+	}
+	if err := tx.Create(device).Error; err != nil {
+		return "", nil, err
+	}
+	return raw, device, nil
+}
+
+// ExchangeDeviceToken 用设备令牌换取用户，同时轮换设备令牌（旧令牌立即失效）
+// 令牌与签发时的 User-Agent 绑定，不一致时视为被盗用并吊销
+func (s *AuthService) ExchangeDeviceToken(raw string, meta DeviceMeta) (*models.User, string, error) {
+	if raw == "" {
+		return nil, "", ErrDeviceTokenInvalid
+	}
+	var device models.DeviceToken
+	if err := s.Db.Where("token_hash = ?", HashDeviceToken(raw)).First(&device).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, "", ErrDeviceTokenInvalid
+		}
+		return nil, "", err
+	}
+	if time.Now().After(device.ExpiresAt) || device.UserAgent != meta.UserAgent {
+		s.Db.Delete(&device)
+		return nil, "", ErrDeviceTokenInvalid
+	}
+	var user models.User
+	if err := s.Db.First(&user, device.UserID).Error; err != nil {
+		return nil, "", ErrDeviceTokenInvalid
+	}
+	if user.BannedAt != nil {
+		return nil, "", ErrUserBanned
+	}
+	if meta.DeviceName == "" {
+		meta.DeviceName = device.DeviceName
+	}
+
+	var newToken string
+	err := s.Db.Transaction(func(tx *gorm.DB) error {
+		// 并发使用同一令牌时只有一个请求能删除成功
+		result := tx.Delete(&device)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrDeviceTokenInvalid
+		}
+		token, rotated, err := s.issueDeviceToken(tx, user.ID, meta)
+		if err != nil {
+			return err
+		}
+		// 轮换后保留首次登录时间，便于用户辨认设备
+		if err := tx.Model(rotated).Update("created_at", device.CreatedAt).Error; err != nil {
+			return err
+		}
+		newToken = token
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return &user, newToken, nil
+}
+
+// ListDevices 列出用户所有未过期的设备
+func (s *AuthService) ListDevices(userID uint) ([]models.DeviceToken, error) {
+	var devices []models.DeviceToken
+	err := s.Db.Where("user_id = ? AND expires_at > ?", userID, time.Now()).
+		Order("last_used_at DESC").
+		Find(&devices).Error
+	return devices, err
+}
+
+// RevokeDevice 吊销用户的某个设备
+func (s *AuthService) RevokeDevice(userID, deviceID uint) error {
+	result := s.Db.Where("id = ? AND user_id = ?", deviceID, userID).Delete(&models.DeviceToken{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrDeviceNotFound
+	}
+	return nil
+}
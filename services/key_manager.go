@@ -0,0 +1,288 @@
+package services
+
+import (
+	"LiteAdmin/config"
+	"LiteAdmin/models"
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultSigningAlgorithm = "RS256"
+	defaultKeyRotation      = 30 * 24 * time.Hour
+	// 新密钥提前发布到 JWKS 的时间，给其他服务刷新缓存留出余量
+	keyPrepublish = 24 * time.Hour
+	// 各节点从数据库同步密钥的间隔
+	keyReloadInterval = time.Minute
+	// 多节点同时轮换时用的 Postgres advisory lock
+	keyRotationLockID = 31031
+)
+
+// JWK JWKS 中的一把公钥
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type loadedKey struct {
+	kid         string
+	method      jwt.SigningMethod
+	private     crypto.Signer
+	activatesAt time.Time
+}
+
+// KeyManager 管理 JWT 非对称签名密钥的生成、轮换与发布
+type KeyManager struct {
+	db        *gorm.DB
+	algorithm string
+	rotation  time.Duration
+	overlap   time.Duration // 被替换的密钥继续验签的时长，不短于最长令牌有效期
+
+	mu      sync.RWMutex
+	signing *loadedKey
+	keys    map[string]*loadedKey
+}
+
+func NewKeyManager(db *gorm.DB, cfg *config.AuthConfig) (*KeyManager, error) {
+	m := &KeyManager{
+		db:        db,
+		algorithm: cfg.SigningAlgorithm,
+		rotation:  time.Duration(cfg.KeyRotation) * time.Hour,
+		overlap:   time.Duration(cfg.RefreshExpiry+cfg.TokenExpiry) * time.Hour,
+	}
+	if m.algorithm == "" {
+		m.algorithm = defaultSigningAlgorithm
+	}
+	if m.algorithm != "RS256" && m.algorithm != "EdDSA" {
+		return nil, fmt.Errorf("unsupported signing algorithm: %s", m.algorithm)
+	}
+	if m.rotation <= keyPrepublish {
+		m.rotation = defaultKeyRotation
+	}
+	if err := m.rotateIfNeeded(); err != nil {
+		return nil, err
+	}
+	if err := m.reload(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Run 定期同步密钥并按计划轮换，直到 ctx 结束
+func (m *KeyManager) Run(ctx context.Context) {
+	ticker := time.NewTicker(keyReloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := m.rotateIfNeeded(); err != nil {
+				log.Printf("Signing key rotation failed: %v", err)
+			}
+			if err := m.reload(); err != nil {
+				log.Printf("Signing key reload failed: %v", err)
+			}
+		}
+	}
+}
+
+// Sign 使用当前签名密钥签发令牌，header 中带 kid
+func (m *KeyManager) Sign(claims jwt.Claims) (string, error) {
+	m.mu.RLock()
+	key := m.signing
+	m.mu.RUnlock()
+	if key == nil {
+		return "", errors.New("no active signing key")
+	}
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.kid
+	return token.SignedString(key.private)
+}
+
+// PublicKey 按 kid 返回验签公钥，算法必须与密钥一致
+func (m *KeyManager) PublicKey(token *jwt.Token) (crypto.PublicKey, error) {
+	kid, _ := token.Header["kid"].(string)
+	m.mu.RLock()
+	key, ok := m.keys[kid]
+	m.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+	}
+	return key.private.Public(), nil
+}
+
+// Algorithms 当前可能出现的签名算法
+func (m *KeyManager) Algorithms() []string {
+	return []string{"RS256", "EdDSA"}
+}
+
+// JWKS 所有可用于验签的公钥（包括已发布但尚未启用的下一把密钥）
+func (m *KeyManager) JWKS() []JWK {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	keys := make([]JWK, 0, len(m.keys))
+	for _, key := range m.keys {
+		jwk := JWK{Kid: key.kid, Use: "sig", Alg: key.method.Alg()}
+		switch pub := key.private.Public().(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+		default:
+			continue
+		}
+		keys = append(keys, jwk)
+	}
+	return keys
+}
+
+// reload 从数据库加载未过期的密钥
+func (m *KeyManager) reload() error {
+	var rows []models.SigningKey
+	now := time.Now()
+	if err := m.db.Where("expires_at IS NULL OR expires_at > ?", now).
+		Order("activates_at ASC").
+		Find(&rows).Error; err != nil {
+		return err
+	}
+	keys := make(map[string]*loadedKey, len(rows))
+	var signing *loadedKey
+	for _, row := range rows {
+		key, err := parseSigningKey(row)
+		if err != nil {
+			log.Printf("Skip signing key %s: %v", row.Kid, err)
+			continue
+		}
+		keys[key.kid] = key
+		if !key.activatesAt.After(now) {
+			signing = key
+		}
+	}
+	if signing == nil {
+		return errors.New("no active signing key")
+	}
+	m.mu.Lock()
+	m.keys = keys
+	m.signing = signing
+	m.mu.Unlock()
+	return nil
+}
+
+// rotateIfNeeded 在当前密钥到期前生成下一把密钥，并为被替换的密钥设置验签截止时间
+func (m *KeyManager) rotateIfNeeded() error {
+	return m.db.Transaction(func(tx *gorm.DB) error {
+		if tx.Dialector.Name() == "postgres" {
+			if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", keyRotationLockID).Error; err != nil {
+				return err
+			}
+		}
+		now := time.Now()
+		var latest models.SigningKey
+		err := tx.Where("expires_at IS NULL").Order("activates_at DESC").First(&latest).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			_, err = m.createKey(tx, now)
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		nextActivation := latest.ActivatesAt.Add(m.rotation)
+		if now.Before(nextActivation.Add(-keyPrepublish)) {
+			return nil
+		}
+		// 长时间停机后直接启用新密钥
+		if nextActivation.Before(now) {
+			nextActivation = now
+		}
+		if _, err := m.createKey(tx, nextActivation); err != nil {
+			return err
+		}
+		expiresAt := nextActivation.Add(m.overlap)
+		return tx.Model(&latest).Update("expires_at", expiresAt).Error
+	})
+}
+
+func (m *KeyManager) createKey(tx *gorm.DB, activatesAt time.Time) (*models.SigningKey, error) {
+	var private crypto.Signer
+	var err error
+	switch m.algorithm {
+	case "EdDSA":
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		private, err = rsa.GenerateKey(rand.Reader, 2048)
+	}
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return nil, err
+	}
+	kid, err := newOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+	row := &models.SigningKey{
+		Kid:         kid[:16],
+		Algorithm:   m.algorithm,
+		PrivateKey:  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		ActivatesAt: activatesAt,
+	}
+	if err := tx.Create(row).Error; err != nil {
+		return nil, err
+	}
+	log.Printf("Created signing key %s (%s), active from %s", row.Kid, row.Algorithm, activatesAt.Format(time.RFC3339))
+	return row, nil
+}
+
+func parseSigningKey(row models.SigningKey) (*loadedKey, error) {
+	block, _ := pem.Decode([]byte(row.PrivateKey))
+	if block == nil {
+		return nil, errors.New("invalid PEM")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key := &loadedKey{kid: row.Kid, activatesAt: row.ActivatesAt}
+	switch private := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.method = jwt.SigningMethodRS256
+		key.private = private
+	case ed25519.PrivateKey:
+		key.method = jwt.SigningMethodEdDSA
+		key.private = private
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", parsed)
+	}
+	return key, nil
+}
@@ -0,0 +1,59 @@
+package services
+
+import (
+	"LiteAdmin/config"
+	"fmt"
+	"log"
+	"net/mail"
+	"net/smtp"
+	"strings"
+)
+
+// Mailer 发送通知邮件
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+// NewMailer 根据配置创建 SMTP 发信；未配置 host 时返回只记录收件人和主题的实现
+func NewMailer(cfg *config.MailConfig) Mailer {
+	if cfg.Host == "" {
+		return logMailer{}
+	}
+	return &smtpMailer{cfg: cfg}
+}
+
+type smtpMailer struct {
+	cfg *config.MailConfig
+}
+
+func (m *smtpMailer) Send(to, subject, body string) error {
+	from, err := mail.ParseAddress(m.cfg.From)
+	if err != nil {
+		return fmt.Errorf("invalid from address: %w", err)
+	}
+	port := m.cfg.Port
+	if port == 0 {
+		port = 587
+	}
+	addr := fmt.Sprintf("%s:%d", m.cfg.Host, port)
+	var auth smtp.Auth
+	if m.cfg.Username != "" {
+		auth = smtp.PlainAuth("", m.cfg.Username, m.cfg.Password, m.cfg.Host)
+	}
+	var msg strings.Builder
+	msg.WriteString("From: " + from.String() + "\r\n")
+	msg.WriteString("To: " + to + "\r\n")
+	msg.WriteString("Subject: " + subject + "\r\n")
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	msg.WriteString(body)
+	return smtp.SendMail(addr, auth, from.Address, []string{to}, []byte(msg.String()))
+}
+
+// logMailer 只记录收件人和主题，正文含确认链接等一次性令牌，不写入日志
+type logMailer struct{}
+
+func (logMailer) Send(to, subject, body string) error {
+	log.Printf("Mail to %s: %s (SMTP not configured, not sent)", to, subject)
+	return nil
+}
@@ -0,0 +1,332 @@
+package services
+
+import (
+	"LiteAdmin/models"
+	"errors"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrMessageNotFound  = errors.New("message not found")
+	ErrMessageDeleted   = errors.New("message has been deleted")
+	ErrNotMessageAuthor = errors.New("only the author can edit this message")
+	ErrInvalidContent   = errors.New("message content is empty or too long")
+	ErrInvalidReaction  = errors.New("invalid reaction")
+)
+
+const (
+	maxMessageLength = 4000 // 单条消息最大字符数
+	maxReactionBytes = 32   // 与 message_reactions.emoji 列长度一致
+	maxQuoteLength   = 200  // 引用内容截取的字符数
+)
+
+// ReactionCount 某个表情的回应人数，Reacted 表示当前用户是否回应过
+type ReactionCount struct {
+	Emoji   string `json:"emoji"`
+	Count   int64  `json:"count"`
+	Reacted bool   `json:"reacted"`
+}
+
+// MessageQuote 回复时引用的原消息摘要
+type MessageQuote struct {
+	ID         uint   `json:"id"`
+	UserID     uint   `json:"user_id"`
+	Username   string `json:"username"`
+	Content    string `json:"content"`
+	Deleted    bool   `json:"deleted"`
+	ReplyCount int    `json:"reply_count"`
+}
+
+// MessageService 聊天消息的保存、编辑、删除和表情回应
+type MessageService struct {
+	db *gorm.DB
+}
+
+func NewMessageService(db *gorm.DB) *MessageService {
+	return &MessageService{db: db}
+}
+
+// CreateMessage 保存一条消息，parentID 不为 0 时作为话题回复；
+// 回复话题中的回复时归入同一话题（话题只有一层），并更新首条消息的回复数。
+// attachmentID 不为 0 时发送之前上传的附件，消息类型为附件类型，content 作为说明文字可以为空
+func (s *MessageService) CreateMessage(chatRoomID string, userID uint, content string, parentID, attachmentID uint) (*models.Message, error) {
+	if attachmentID == 0 || content != "" {
+		if err := validateContent(content); err != nil {
+			return nil, err
+		}
+	}
+	message := &models.Message{
+		RoomID:  chatRoomID,
+		UserID:  userID,
+		Content: content,
+		Type:    "text",
+	}
+
+	var rootID uint
+	if parentID != 0 {
+		parent, err := s.findMessage(chatRoomID, parentID)
+		if err != nil {
+			return nil, err
+		}
+		rootID = parent.ID
+		if parent.ParentID != nil {
+			rootID = *parent.ParentID
+		}
+		message.ParentID = &rootID
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var attachment models.Attachment
+		if attachmentID != 0 {
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+				Where("id = ? AND room_id = ? AND user_id = ?", attachmentID, chatRoomID, userID).
+				First(&attachment).Error; err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					return ErrAttachmentNotFound
+				}
+				return err
+			}
+			if attachment.MessageID != nil {
+				return ErrAttachmentInUse
+			}
+			message.Type = attachment.Kind
+			message.AttachmentID = &attachment.ID
+		}
+		if err := tx.Create(message).Error; err != nil {
+			return err
+		}
+		if attachmentID != 0 {
+			if err := tx.Model(&attachment).Update("message_id", message.ID).Error; err != nil {
+				return err
+			}
+		}
+		if rootID == 0 {
+			return nil
+		}
+		return tx.Model(&models.Message{}).Where("id = ?", rootID).Updates(map[string]interface{}{
+			"reply_count":   gorm.Expr("reply_count + 1"),
+			"last_reply_at": message.CreatedAt,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+// ThreadRoot 房间内消息所属话题的首条消息，传入首条消息时返回其本身
+func (s *MessageService) ThreadRoot(chatRoomID string, messageID uint) (*models.Message, error) {
+	var message models.Message
+	if err := s.db.Where("id = ? AND room_id = ?", messageID, chatRoomID).First(&message).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrMessageNotFound
+		}
+		return nil, err
+	}
+	if message.ParentID != nil {
+		return s.ThreadRoot(chatRoomID, *message.ParentID)
+	}
+	return &message, nil
+}
+
+// ThreadParticipants 话题参与者：首条消息作者和所有回复者
+func (s *MessageService) ThreadParticipants(rootID uint) ([]uint, error) {
+	var userIDs []uint
+	err := s.db.Model(&models.Message{}).
+		Where("(id = ? OR parent_id = ?) AND user_id <> 0", rootID, rootID).
+		Distinct().
+		Pluck("user_id", &userIDs).Error
+	return userIDs, err
+}
+
+// Quotes 批量获取被引用消息的摘要
+func (s *MessageService) Quotes(messageIDs []uint) (map[uint]*MessageQuote, error) {
+	result := make(map[uint]*MessageQuote, len(messageIDs))
+	if len(messageIDs) == 0 {
+		return result, nil
+	}
+	var rows []struct {
+		models.Message
+		Username string
+	}
+	err := s.db.Model(&models.Message{}).
+		Select("messages.*, users.username").
+		Joins("LEFT JOIN users ON users.id = messages.user_id").
+		Where("messages.id IN ?", messageIDs).
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		result[row.ID] = &MessageQuote{
+			ID:         row.ID,
+			UserID:     row.UserID,
+			Username:   row.Username,
+			Content:    truncateRunes(row.Content, maxQuoteLength),
+			Deleted:    row.DeletedAt != nil,
+			ReplyCount: row.ReplyCount,
+		}
+	}
+	return result, nil
+}
+
+// EditMessage 编辑消息，只有作者可以编辑
+func (s *MessageService) EditMessage(chatRoomID string, messageID, userID uint, content string) (*models.Message, error) {
+	if err := validateContent(content); err != nil {
+		return nil, err
+	}
+	message, err := s.findMessage(chatRoomID, messageID)
+	if err != nil {
+		return nil, err
+	}
+	if message.UserID != userID {
+		return nil, ErrNotMessageAuthor
+	}
+	now := time.Now()
+	if err := s.db.Model(message).Updates(map[string]interface{}{
+		"content":   content,
+		"edited_at": now,
+	}).Error; err != nil {
+		return nil, err
+	}
+	message.Content = content
+	message.EditedAt = &now
+	return message, nil
+}
+
+// DeleteMessage 软删除消息：清空内容并保留墓碑记录，作者或房间管理者（moderator 为 true）可以删除
+func (s *MessageService) DeleteMessage(chatRoomID string, messageID, userID uint, moderator bool) (*models.Message, error) {
+	message, err := s.findMessage(chatRoomID, messageID)
+	if err != nil {
+		return nil, err
+	}
+	if message.UserID != userID && !moderator {
+		return nil, ErrAccessDenied
+	}
+	now := time.Now()
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(message).Updates(map[string]interface{}{
+			"content":    "",
+			"deleted_at": now,
+			"deleted_by": userID,
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Where("message_id = ?", message.ID).Delete(&models.MessageReaction{}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	message.Content = ""
+	message.DeletedAt = &now
+	message.DeletedBy = userID
+	return message, nil
+}
+
+// AddReaction 添加表情回应（重复添加无效果），返回该表情当前的回应人数
+func (s *MessageService) AddReaction(chatRoomID string, messageID, userID uint, emoji string) (int64, error) {
+	if err := validateReaction(emoji); err != nil {
+		return 0, err
+	}
+	message, err := s.findMessage(chatRoomID, messageID)
+	if err != nil {
+		return 0, err
+	}
+	reaction := models.MessageReaction{MessageID: message.ID, UserID: userID, Emoji: emoji}
+	if err := s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&reaction).Error; err != nil {
+		return 0, err
+	}
+	return s.reactionCount(message.ID, emoji)
+}
+
+// RemoveReaction 取消表情回应，返回该表情当前的回应人数
+func (s *MessageService) RemoveReaction(chatRoomID string, messageID, userID uint, emoji string) (int64, error) {
+	if err := validateReaction(emoji); err != nil {
+		return 0, err
+	}
+	message, err := s.findMessage(chatRoomID, messageID)
+	if err != nil {
+		return 0, err
+	}
+	if err := s.db.Where("message_id = ? AND user_id = ? AND emoji = ?", message.ID, userID, emoji).
+		Delete(&models.MessageReaction{}).Error; err != nil {
+		return 0, err
+	}
+	return s.reactionCount(message.ID, emoji)
+}
+
+// ReactionCounts 按消息汇总表情回应，按每个表情第一次出现的顺序排列
+func (s *MessageService) ReactionCounts(messageIDs []uint, userID uint) (map[uint][]ReactionCount, error) {
+	result := make(map[uint][]ReactionCount, len(messageIDs))
+	if len(messageIDs) == 0 {
+		return result, nil
+	}
+	var rows []struct {
+		MessageID uint
+		ReactionCount
+	}
+	err := s.db.Model(&models.MessageReaction{}).
+		Select("message_id, emoji, COUNT(*) AS count, BOOL_OR(user_id = ?) AS reacted", userID).
+		Where("message_id IN ?", messageIDs).
+		Group("message_id, emoji").
+		Order("MIN(created_at) ASC").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		result[row.MessageID] = append(result[row.MessageID], row.ReactionCount)
+	}
+	return result, nil
+}
+
+// findMessage 查找房间内未删除的消息
+func (s *MessageService) findMessage(chatRoomID string, messageID uint) (*models.Message, error) {
+	var message models.Message
+	if err := s.db.Where("id = ? AND room_id = ?", messageID, chatRoomID).First(&message).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrMessageNotFound
+		}
+		return nil, err
+	}
+	if message.DeletedAt != nil {
+		return nil, ErrMessageDeleted
+	}
+	return &message, nil
+}
+
+func (s *MessageService) reactionCount(messageID uint, emoji string) (int64, error) {
+	var count int64
+	err := s.db.Model(&models.MessageReaction{}).
+		Where("message_id = ? AND emoji = ?", messageID, emoji).
+		Count(&count).Error
+	return count, err
+}
+
+func truncateRunes(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n]) + "…"
+}
+
+func validateContent(content string) error {
+	if strings.TrimSpace(content) == "" || utf8.RuneCountInString(content) > maxMessageLength {
+		return ErrInvalidContent
+	}
+	return nil
+}
+
+// validateReaction 表情回应必须是不含空白的短字符串（emoji 或短代码）
+func validateReaction(emoji string) error {
+	if emoji == "" || len(emoji) > maxReactionBytes || !utf8.ValidString(emoji) ||
+		strings.IndexFunc(emoji, func(r rune) bool { return r <= ' ' }) >= 0 {
+		return ErrInvalidReaction
+	}
+	return nil
+}
@@ -11,6 +11,7 @@ import (
 	"net/url"
 	"time"
 
+	"github.com/redis/go-redis/v9"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/facebook"
 	"golang.org/x/oauth2/github"
@@ -30,20 +31,30 @@ var enWechatEndpoint = oauth2.Endpoint{
 }
 
 type OAuthService struct {
-	providers map[string]*oauth2.Config
+	providers   map[string]*oauth2.Config
+	oidc        map[string]*OIDCProvider // 通过 issuer 配置的自定义 provider
+	redis       *redis.Client            // 保存授权 state
+	signupTypes []string                 // 允许自助注册的用户类型
 }
 
 type OAuthUserInfo struct {
-	ID       string `json:"id"`
-	Email    string `json:"email"`
-	Name     string `json:"name"`
-	Avatar   string `json:"avatar"`
-	Provider string `json:"provider"`
+	ID            string `json:"id"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"` // provider 是否声明邮箱已验证，决定能否自动关联已有账号
+	Name          string `json:"name"`
+	Avatar        string `json:"avatar"`
+	Provider      string `json:"provider"`
 }
 
-func NewOAuthService(config *config.AuthConfig) *OAuthService {
+func NewOAuthService(config *config.AuthConfig, redisClient *redis.Client) *OAuthService {
 	service := &OAuthService{
-		providers: make(map[string]*oauth2.Config),
+		providers:   make(map[string]*oauth2.Config),
+		oidc:        make(map[string]*OIDCProvider),
+		redis:       redisClient,
+		signupTypes: config.SignupTypes,
+	}
+	if len(service.signupTypes) == 0 {
+		service.signupTypes = defaultSignupTypes
 	}
 
 	// Google OAuth
@@ -100,6 +111,17 @@ func NewOAuthService(config *config.AuthConfig) *OAuthService {
 	}
 	// Custom OAuth providers
 	for name, provider := range config.OAuth.Custom {
+		if provider.Issuer != "" {
+			// OIDC provider 的端点在首次使用时通过 discovery 获取
+			service.oidc[name] = NewOIDCProvider(name, provider.Issuer, provider.ClientID, provider.ClaimMapping, nil)
+			service.providers[name] = &oauth2.Config{
+				ClientID:     provider.ClientID,
+				ClientSecret: provider.ClientSecret,
+				RedirectURL:  provider.RedirectURL,
+				Scopes:       oidcScopes(provider.Scopes),
+			}
+			continue
+		}
 		service.providers[name] = &oauth2.Config{
 			ClientID:     provider.ClientID,
 			ClientSecret: provider.ClientSecret,
@@ -115,18 +137,62 @@ func NewOAuthService(config *config.AuthConfig) *OAuthService {
 	return service
 }
 
-func (s *OAuthService) GetAuthURL(provider, state string) (string, error) {
+// GetAuthURL 生成授权地址，标准 OAuth2 provider 附带 PKCE challenge（微信系不支持 PKCE），
+// OIDC provider 额外附带 nonce
+func (s *OAuthService) GetAuthURL(provider, state, verifier, nonce string) (string, error) {
 	if provider == "workchat" {
 		return s.GetEnWeChatAuthURL(state)
 	}
 	if provider == "wechat" {
 		return s.GetWeChatAuthURL(state)
 	}
+	cfg, err := s.oauthConfig(context.Background(), provider)
+	if err != nil {
+		return "", err
+	}
+	opts := []oauth2.AuthCodeOption{oauth2.AccessTypeOffline, oauth2.S256ChallengeOption(verifier)}
+	if nonce != "" {
+		opts = append(opts, oauth2.SetAuthURLParam("nonce", nonce))
+	}
+	return cfg.AuthCodeURL(state, opts...), nil
+}
+
+// IsOIDC 是否为通过 issuer 配置的 OIDC provider
+func (s *OAuthService) IsOIDC(provider string) bool {
+	_, ok := s.oidc[provider]
+	return ok
+}
+
+// oauthConfig 返回 provider 的配置，OIDC provider 会填充 discovery 得到的端点
+func (s *OAuthService) oauthConfig(ctx context.Context, provider string) (*oauth2.Config, error) {
 	cfg, exists := s.providers[provider]
 	if !exists {
-		return "", fmt.Errorf("unsupported provider: %s", provider)
+		return nil, fmt.Errorf("unsupported provider: %s", provider)
 	}
-	return cfg.AuthCodeURL(state, oauth2.AccessTypeOffline), nil
+	oidcProvider, ok := s.oidc[provider]
+	if !ok {
+		return cfg, nil
+	}
+	endpoint, err := oidcProvider.Endpoint(ctx)
+	if err != nil {
+		return nil, err
+	}
+	withEndpoint := *cfg
+	withEndpoint.Endpoint = endpoint
+	return &withEndpoint, nil
+}
+
+// oidcScopes 确保包含 openid scope
+func oidcScopes(scopes []string) []string {
+	if len(scopes) == 0 {
+		return []string{"openid", "email", "profile"}
+	}
+	for _, scope := range scopes {
+		if scope == "openid" {
+			return scopes
+		}
+	}
+	return append([]string{"openid"}, scopes...)
 }
 
 func (s *OAuthService) GetWeChatAuthURL(state string) (string, error) {
@@ -169,7 +235,7 @@ func (s *OAuthService) GetEnWeChatAuthURL(state string) (string, error) {
 	return u.String(), nil
 }
 
-func (s *OAuthService) ExchangeCode(provider, code string) (*oauth2.Token, error) {
+func (s *OAuthService) ExchangeCode(provider, code, verifier string) (*oauth2.Token, error) {
 	// 非oauth2标准特殊处理
 	if provider == "workchat" {
 		return s.handleWeChatEnterpriseCallback(code)
@@ -178,11 +244,15 @@ func (s *OAuthService) ExchangeCode(provider, code string) (*oauth2.Token, error
 		return s.handleWeChatPersonalCallback(code)
 	}
 	// 其他 provider 走标准 oauth2
-	cfg, exists := s.providers[provider]
-	if !exists {
-		return nil, fmt.Errorf("unsupported provider: %s", provider)
+	cfg, err := s.oauthConfig(context.Background(), provider)
+	if err != nil {
+		return nil, err
+	}
+	var opts []oauth2.AuthCodeOption
+	if verifier != "" {
+		opts = append(opts, oauth2.VerifierOption(verifier))
 	}
-	return cfg.Exchange(context.Background(), code)
+	return cfg.Exchange(context.Background(), code, opts...)
 }
 
 // handleWeChatPersonalCallback 个人微信公众号网页授权
@@ -301,8 +371,11 @@ func (s *OAuthService) getWeChatEnterpriseAccessToken(cfg *oauth2.Config) (strin
 	return tr.AccessToken, nil
 }
 
-// 获取用户信息
-func (s *OAuthService) GetUserInfo(provider string, token *oauth2.Token) (*OAuthUserInfo, error) {
+// 获取用户信息，nonce 仅用于校验 OIDC provider 的 id_token
+func (s *OAuthService) GetUserInfo(provider string, token *oauth2.Token, nonce string) (*OAuthUserInfo, error) {
+	if oidcProvider, ok := s.oidc[provider]; ok {
+		return oidcProvider.UserInfo(context.Background(), token, nonce)
+	}
 	switch provider {
 	case "google":
 		return s.getGoogleUserInfo(token)
@@ -336,12 +409,14 @@ func (s *OAuthService) getGoogleUserInfo(token *oauth2.Token) (*OAuthUserInfo, e
 		return nil, err
 	}
 
+	verified, _ := data["verified_email"].(bool)
 	return &OAuthUserInfo{
-		ID:       data["id"].(string),
-		Email:    data["email"].(string),
-		Name:     data["name"].(string),
-		Avatar:   data["picture"].(string),
-		Provider: "google",
+		ID:            data["id"].(string),
+		Email:         data["email"].(string),
+		EmailVerified: verified,
+		Name:          data["name"].(string),
+		Avatar:        data["picture"].(string),
+		Provider:      "google",
 	}, nil
 }
 
@@ -368,16 +443,57 @@ func (s *OAuthService) getGitHubUserInfo(token *oauth2.Token) (*OAuthUserInfo, e
 	if data["email"] != nil {
 		email = data["email"].(string)
 	}
+	// /user 返回的公开邮箱不带验证状态，以 /user/emails 中已验证的主邮箱为准
+	verified := false
+	if primary, err := s.getGitHubPrimaryEmail(token); err == nil && primary != "" {
+		email = primary
+		verified = true
+	}
 
 	return &OAuthUserInfo{
-		ID:       fmt.Sprintf("%v", data["id"]),
-		Email:    email,
-		Name:     data["login"].(string),
-		Avatar:   data["avatar_url"].(string),
-		Provider: "github",
+		ID:            fmt.Sprintf("%v", data["id"]),
+		Email:         email,
+		EmailVerified: verified,
+		Name:          data["login"].(string),
+		Avatar:        data["avatar_url"].(string),
+		Provider:      "github",
 	}, nil
 }
 
+// getGitHubPrimaryEmail 获取已验证的主邮箱（需要 user:email 权限）
+func (s *OAuthService) getGitHubPrimaryEmail(token *oauth2.Token) (string, error) {
+	req, _ := http.NewRequest("GET", "https://api.github.com/user/emails", nil)
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer func(Body io.ReadCloser) {
+		err := Body.Close()
+		if err != nil {
+			log.Printf("Error closing resp body: %v", err)
+		}
+	}(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("github user/emails error: %s", resp.Status)
+	}
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&emails); err != nil {
+		return "", err
+	}
+	for _, e := range emails {
+		if e.Primary && e.Verified {
+			return e.Email, nil
+		}
+	}
+	return "", nil
+}
+
 func (s *OAuthService) getFacebookUserInfo(token *oauth2.Token) (*OAuthUserInfo, error) {
 	resp, err := http.Get("https://graph.facebook.com/me?fields=id,name,email,picture&access_token=" + token.AccessToken)
 	if err != nil {
@@ -0,0 +1,120 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/oauth2"
+)
+
+var (
+	ErrInvalidOAuthState   = errors.New("invalid or expired oauth state")
+	ErrSignupTypeForbidden = errors.New("user type not allowed for self signup")
+)
+
+// OAuth 授权流程的用途
+const (
+	OAuthPurposeLogin = "login" // 登录/注册
+	OAuthPurposeLink  = "link"  // 已登录用户绑定第三方账号
+)
+
+const (
+	oauthStateTTL    = 10 * time.Minute
+	oauthStateKeyFmt = "oauth:state:%s"
+)
+
+// 未配置 signup_types 时，自助注册只允许普通客户
+var defaultSignupTypes = []string{"client"}
+
+// OAuthState 服务端保存的授权请求上下文，回调时校验并一次性消费
+type OAuthState struct {
+	Provider string `json:"provider"`
+	Purpose  string `json:"purpose"`
+	Verifier string `json:"verifier"` // PKCE code_verifier
+	UserType string `json:"user_type,omitempty"`
+	UserID   uint   `json:"user_id,omitempty"` // 绑定流程中发起绑定的用户
+	Nonce    string `json:"nonce,omitempty"`   // OIDC id_token 防重放
+}
+
+// AuthRequest BeginAuth 的返回值
+type AuthRequest struct {
+	URL   string
+	State string
+}
+
+// StateTTL 授权请求有效期
+func (s *OAuthService) StateTTL() time.Duration {
+	return oauthStateTTL
+}
+
+// ResolveSignupType 根据服务端策略确定自助注册的用户类型，空值使用第一个允许的类型
+func (s *OAuthService) ResolveSignupType(userType string) (string, error) {
+	if userType == "" {
+		return s.signupTypes[0], nil
+	}
+	for _, allowed := range s.signupTypes {
+		if allowed == userType {
+			return userType, nil
+		}
+	}
+	return "", ErrSignupTypeForbidden
+}
+
+// BeginAuth 生成服务端 state 与 PKCE verifier，保存到 Redis 并返回授权地址
+func (s *OAuthService) BeginAuth(ctx context.Context, provider string, state OAuthState) (*AuthRequest, error) {
+	if _, exists := s.providers[provider]; !exists {
+		return nil, fmt.Errorf("unsupported provider: %s", provider)
+	}
+	if s.redis == nil {
+		return nil, errors.New("oauth state store unavailable")
+	}
+	stateID, err := newOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+	state.Provider = provider
+	state.Verifier = oauth2.GenerateVerifier()
+	if s.IsOIDC(provider) {
+		if state.Nonce, err = newOpaqueToken(); err != nil {
+			return nil, err
+		}
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.redis.Set(ctx, fmt.Sprintf(oauthStateKeyFmt, stateID), data, oauthStateTTL).Err(); err != nil {
+		return nil, err
+	}
+	authURL, err := s.GetAuthURL(provider, stateID, state.Verifier, state.Nonce)
+	if err != nil {
+		return nil, err
+	}
+	return &AuthRequest{URL: authURL, State: stateID}, nil
+}
+
+// ConsumeState 校验并删除 state（只能使用一次）
+func (s *OAuthService) ConsumeState(ctx context.Context, provider, stateID string) (*OAuthState, error) {
+	if stateID == "" || s.redis == nil {
+		return nil, ErrInvalidOAuthState
+	}
+	data, err := s.redis.GetDel(ctx, fmt.Sprintf(oauthStateKeyFmt, stateID)).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, ErrInvalidOAuthState
+		}
+		return nil, err
+	}
+	var state OAuthState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, ErrInvalidOAuthState
+	}
+	if state.Provider != provider {
+		return nil, ErrInvalidOAuthState
+	}
+	return &state, nil
+}
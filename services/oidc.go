@@ -0,0 +1,405 @@
+package services
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/oauth2"
+)
+
+var ErrIDTokenInvalid = errors.New("invalid id_token")
+
+const (
+	oidcDiscoveryTTL = time.Hour
+	oidcJWKSTTL      = time.Hour
+	// 遇到未知 kid 时强制刷新 JWKS 的最小间隔，防止被恶意 kid 打爆 IdP
+	oidcJWKSMinRefresh = time.Minute
+)
+
+// 未配置 claim_mapping 时使用标准 OIDC claim
+var defaultClaimMapping = map[string]string{
+	"id":             "sub",
+	"email":          "email",
+	"email_verified": "email_verified",
+	"name":           "name",
+	"avatar":         "picture",
+}
+
+var oidcSigningMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+// OIDC discovery 文档中用到的字段
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// OIDCProvider 通过 issuer discovery 配置的自定义 OIDC 登录
+type OIDCProvider struct {
+	name       string
+	issuer     string
+	clientID   string
+	mapping    map[string]string
+	httpClient *http.Client
+
+	mu            sync.Mutex
+	discovery     *oidcDiscovery
+	discoveredAt  time.Time
+	keys          map[string]crypto.PublicKey
+	keysFetchedAt time.Time
+}
+
+func NewOIDCProvider(name, issuer, clientID string, mapping map[string]string, httpClient *http.Client) *OIDCProvider {
+	merged := make(map[string]string, len(defaultClaimMapping))
+	for field, claim := range defaultClaimMapping {
+		merged[field] = claim
+	}
+	for field, claim := range mapping {
+		merged[field] = claim
+	}
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &OIDCProvider{
+		name:       name,
+		issuer:     strings.TrimSuffix(issuer, "/"),
+		clientID:   clientID,
+		mapping:    merged,
+		httpClient: httpClient,
+	}
+}
+
+// Endpoint 根据 discovery 文档返回授权与令牌地址
+func (p *OIDCProvider) Endpoint(ctx context.Context) (oauth2.Endpoint, error) {
+	doc, err := p.discover(ctx)
+	if err != nil {
+		return oauth2.Endpoint{}, err
+	}
+	return oauth2.Endpoint{
+		AuthURL:  doc.AuthorizationEndpoint,
+		TokenURL: doc.TokenEndpoint,
+	}, nil
+}
+
+// UserInfo 校验 token 中的 id_token，并按 claim 映射转换为 OAuthUserInfo
+func (p *OIDCProvider) UserInfo(ctx context.Context, token *oauth2.Token, nonce string) (*OAuthUserInfo, error) {
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok || rawIDToken == "" {
+		return nil, fmt.Errorf("%w: missing id_token in token response", ErrIDTokenInvalid)
+	}
+	claims, err := p.VerifyIDToken(ctx, rawIDToken, nonce)
+	if err != nil {
+		return nil, err
+	}
+	// id_token 中常常缺少 profile 信息，用 userinfo 接口补全（不覆盖已签名的 claim）
+	if extra, err := p.fetchUserinfo(ctx, token); err == nil {
+		if extra["sub"] == claims["sub"] {
+			for k, v := range extra {
+				if _, exists := claims[k]; !exists {
+					claims[k] = v
+				}
+			}
+		}
+	} else {
+		log.Printf("OIDC %s userinfo skipped: %v", p.name, err)
+	}
+
+	info := &OAuthUserInfo{
+		ID:       claimString(claims, p.mapping["id"]),
+		Email:    claimString(claims, p.mapping["email"]),
+		Name:     claimString(claims, p.mapping["name"]),
+		Avatar:   claimString(claims, p.mapping["avatar"]),
+		Provider: p.name,
+	}
+	info.EmailVerified = claimBool(claims, p.mapping["email_verified"])
+	if info.ID == "" {
+		return nil, fmt.Errorf("%w: claim %q is empty", ErrIDTokenInvalid, p.mapping["id"])
+	}
+	return info, nil
+}
+
+// VerifyIDToken 校验签名（JWKS）、iss、aud、exp 与 nonce
+func (p *OIDCProvider) VerifyIDToken(ctx context.Context, rawIDToken, nonce string) (jwt.MapClaims, error) {
+	doc, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	claims := jwt.MapClaims{}
+	_, err = jwt.ParseWithClaims(rawIDToken, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return p.publicKey(ctx, kid)
+	},
+		jwt.WithValidMethods(oidcSigningMethods),
+		jwt.WithIssuer(doc.Issuer),
+		jwt.WithAudience(p.clientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrIDTokenInvalid, err)
+	}
+	if nonce != "" {
+		if got, _ := claims["nonce"].(string); got != nonce {
+			return nil, fmt.Errorf("%w: nonce mismatch", ErrIDTokenInvalid)
+		}
+	}
+	return claims, nil
+}
+
+func (p *OIDCProvider) discover(ctx context.Context) (*oidcDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery != nil && time.Since(p.discoveredAt) < oidcDiscoveryTTL {
+		return p.discovery, nil
+	}
+	var doc oidcDiscovery
+	if err := p.getJSON(ctx, p.issuer+"/.well-known/openid-configuration", "", &doc); err != nil {
+		if p.discovery != nil {
+			// IdP 暂时不可用时继续使用旧的配置
+			log.Printf("OIDC %s discovery refresh failed, using cached document: %v", p.name, err)
+			return p.discovery, nil
+		}
+		return nil, fmt.Errorf("oidc discovery failed: %w", err)
+	}
+	if strings.TrimSuffix(doc.Issuer, "/") != p.issuer {
+		return nil, fmt.Errorf("oidc discovery issuer mismatch: got %q, want %q", doc.Issuer, p.issuer)
+	}
+	if doc.AuthorizationEndpoint == "" || doc.TokenEndpoint == "" || doc.JWKSURI == "" {
+		return nil, errors.New("oidc discovery document is incomplete")
+	}
+	p.discovery = &doc
+	p.discoveredAt = time.Now()
+	return p.discovery, nil
+}
+
+// publicKey 按 kid 查找公钥，缓存过期或出现未知 kid 时刷新 JWKS
+func (p *OIDCProvider) publicKey(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	p.mu.Lock()
+	key, ok := p.lookupKey(kid)
+	fresh := time.Since(p.keysFetchedAt) < oidcJWKSTTL
+	canRefresh := time.Since(p.keysFetchedAt) >= oidcJWKSMinRefresh
+	p.mu.Unlock()
+	if ok && fresh {
+		return key, nil
+	}
+	if ok || canRefresh {
+		if err := p.refreshKeys(ctx); err != nil {
+			if ok {
+				log.Printf("OIDC %s JWKS refresh failed, using cached keys: %v", p.name, err)
+				return key, nil
+			}
+			return nil, err
+		}
+		p.mu.Lock()
+		key, ok = p.lookupKey(kid)
+		p.mu.Unlock()
+		if ok {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// lookupKey 需持有锁；kid 为空且只有一把钥匙时直接使用
+func (p *OIDCProvider) lookupKey(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key, true
+		}
+	}
+	key, ok := p.keys[kid]
+	return key, ok
+}
+
+func (p *OIDCProvider) refreshKeys(ctx context.Context) error {
+	doc, err := p.discover(ctx)
+	if err != nil {
+		return err
+	}
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := p.getJSON(ctx, doc.JWKSURI, "", &set); err != nil {
+		return fmt.Errorf("fetch jwks failed: %w", err)
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			log.Printf("OIDC %s skip jwk %q: %v", p.name, jwk.Kid, err)
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+	p.mu.Lock()
+	p.keys = keys
+	p.keysFetchedAt = time.Now()
+	p.mu.Unlock()
+	return nil
+}
+
+func (p *OIDCProvider) fetchUserinfo(ctx context.Context, token *oauth2.Token) (map[string]interface{}, error) {
+	doc, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if doc.UserinfoEndpoint == "" {
+		return nil, errors.New("no userinfo endpoint")
+	}
+	var claims map[string]interface{}
+	if err := p.getJSON(ctx, doc.UserinfoEndpoint, token.AccessToken, &claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (p *OIDCProvider) getJSON(ctx context.Context, url, bearer string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func(Body io.ReadCloser) {
+		err := Body.Close()
+		if err != nil {
+			log.Printf("Error closing resp body: %v", err)
+		}
+	}(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(out)
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid ed25519 key size")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+// claimValue 支持用 . 访问嵌套 claim，如 "profile.avatar"
+func claimValue(claims map[string]interface{}, path string) interface{} {
+	if path == "" {
+		return nil
+	}
+	var current interface{} = claims
+	for _, part := range strings.Split(path, ".") {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		current = m[part]
+	}
+	return current
+}
+
+func claimString(claims map[string]interface{}, path string) string {
+	switch v := claimValue(claims, path).(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return fmt.Sprintf("%.0f", v)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+func claimBool(claims map[string]interface{}, path string) bool {
+	switch v := claimValue(claims, path).(type) {
+	case bool:
+		return v
+	case string:
+		return v == "true"
+	default:
+		return false
+	}
+}
@@ -0,0 +1,274 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/oauth2"
+)
+
+const testClientID = "lite-admin"
+
+// fakeIdP 基于 httptest 的最小 OIDC 提供方：discovery、JWKS 和 userinfo
+type fakeIdP struct {
+	server    *httptest.Server
+	mu        sync.Mutex
+	keys      map[string]*rsa.PrivateKey // 当前发布在 JWKS 中的钥匙
+	userinfo  map[string]interface{}
+	jwksHits  atomic.Int32
+	infoCalls atomic.Int32
+}
+
+func newFakeIdP(t *testing.T) *fakeIdP {
+	t.Helper()
+	idp := &fakeIdP{keys: map[string]*rsa.PrivateKey{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.server.URL,
+			"authorization_endpoint": idp.server.URL + "/authorize",
+			"token_endpoint":         idp.server.URL + "/token",
+			"userinfo_endpoint":      idp.server.URL + "/userinfo",
+			"jwks_uri":               idp.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		idp.jwksHits.Add(1)
+		idp.mu.Lock()
+		defer idp.mu.Unlock()
+		keys := make([]map[string]string, 0, len(idp.keys))
+		for kid, key := range idp.keys {
+			keys = append(keys, map[string]string{
+				"kid": kid,
+				"kty": "RSA",
+				"use": "sig",
+				"alg": "RS256",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+	})
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		idp.infoCalls.Add(1)
+		if r.Header.Get("Authorization") != "Bearer access-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		idp.mu.Lock()
+		defer idp.mu.Unlock()
+		json.NewEncoder(w).Encode(idp.userinfo)
+	})
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	idp.addKey(t, "key-1")
+	return idp
+}
+
+func (idp *fakeIdP) addKey(t *testing.T, kid string) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp.mu.Lock()
+	idp.keys[kid] = key
+	idp.mu.Unlock()
+}
+
+// sign 签发 id_token，overrides 覆盖默认 claim（值为 nil 时删除该 claim）
+func (idp *fakeIdP) sign(t *testing.T, kid string, overrides jwt.MapClaims) string {
+	t.Helper()
+	claims := jwt.MapClaims{
+		"iss":            idp.server.URL,
+		"aud":            testClientID,
+		"sub":            "user-42",
+		"email":          "alice@example.com",
+		"email_verified": true,
+		"name":           "Alice",
+		"nonce":          "nonce-1",
+		"iat":            time.Now().Unix(),
+		"exp":            time.Now().Add(5 * time.Minute).Unix(),
+	}
+	for k, v := range overrides {
+		if v == nil {
+			delete(claims, k)
+		} else {
+			claims[k] = v
+		}
+	}
+	idp.mu.Lock()
+	key := idp.keys[kid]
+	idp.mu.Unlock()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	raw, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func (idp *fakeIdP) provider(mapping map[string]string) *OIDCProvider {
+	return NewOIDCProvider("fake", idp.server.URL, testClientID, mapping, idp.server.Client())
+}
+
+func oauthToken(idToken string) *oauth2.Token {
+	return (&oauth2.Token{AccessToken: "access-token"}).WithExtra(map[string]interface{}{"id_token": idToken})
+}
+
+func TestOIDCValidToken(t *testing.T) {
+	idp := newFakeIdP(t)
+	p := idp.provider(nil)
+
+	info, err := p.UserInfo(context.Background(), oauthToken(idp.sign(t, "key-1", nil)), "nonce-1")
+	if err != nil {
+		t.Fatalf("UserInfo: %v", err)
+	}
+	if info.ID != "user-42" || info.Email != "alice@example.com" || !info.EmailVerified || info.Name != "Alice" || info.Provider != "fake" {
+		t.Fatalf("unexpected user info: %+v", info)
+	}
+
+	endpoint, err := p.Endpoint(context.Background())
+	if err != nil {
+		t.Fatalf("Endpoint: %v", err)
+	}
+	if endpoint.TokenURL != idp.server.URL+"/token" {
+		t.Fatalf("token url = %q", endpoint.TokenURL)
+	}
+}
+
+func TestOIDCRejectsInvalidTokens(t *testing.T) {
+	idp := newFakeIdP(t)
+	p := idp.provider(nil)
+
+	tests := []struct {
+		name      string
+		overrides jwt.MapClaims
+		nonce     string
+	}{
+		{"wrong audience", jwt.MapClaims{"aud": "someone-else"}, "nonce-1"},
+		{"wrong issuer", jwt.MapClaims{"iss": "https://evil.example.com"}, "nonce-1"},
+		{"expired", jwt.MapClaims{"exp": time.Now().Add(-time.Hour).Unix()}, "nonce-1"},
+		{"missing exp", jwt.MapClaims{"exp": nil}, "nonce-1"},
+		{"nonce mismatch", nil, "nonce-2"},
+		{"missing nonce", jwt.MapClaims{"nonce": nil}, "nonce-1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := p.VerifyIDToken(context.Background(), idp.sign(t, "key-1", tt.overrides), tt.nonce)
+			if !errors.Is(err, ErrIDTokenInvalid) {
+				t.Fatalf("err = %v, want ErrIDTokenInvalid", err)
+			}
+		})
+	}
+
+	t.Run("signed by unpublished key", func(t *testing.T) {
+		other := newFakeIdP(t)
+		raw := other.sign(t, "key-1", jwt.MapClaims{"iss": idp.server.URL})
+		if _, err := p.VerifyIDToken(context.Background(), raw, "nonce-1"); !errors.Is(err, ErrIDTokenInvalid) {
+			t.Fatalf("err = %v, want ErrIDTokenInvalid", err)
+		}
+	})
+}
+
+func TestOIDCUnknownKidRefreshesJWKS(t *testing.T) {
+	idp := newFakeIdP(t)
+	p := idp.provider(nil)
+	ctx := context.Background()
+
+	if _, err := p.VerifyIDToken(ctx, idp.sign(t, "key-1", nil), "nonce-1"); err != nil {
+		t.Fatalf("verify with key-1: %v", err)
+	}
+	if hits := idp.jwksHits.Load(); hits != 1 {
+		t.Fatalf("jwks hits = %d, want 1", hits)
+	}
+
+	// IdP 轮换钥匙；刚刷新过时不会因为未知 kid 立即再次请求 JWKS
+	idp.addKey(t, "key-2")
+	rotated := idp.sign(t, "key-2", nil)
+	if _, err := p.VerifyIDToken(ctx, rotated, "nonce-1"); !errors.Is(err, ErrIDTokenInvalid) {
+		t.Fatalf("err = %v, want ErrIDTokenInvalid within the refresh interval", err)
+	}
+	if hits := idp.jwksHits.Load(); hits != 1 {
+		t.Fatalf("jwks hits = %d, want 1 within the refresh interval", hits)
+	}
+
+	// 超过最小刷新间隔后，未知 kid 触发刷新并通过校验
+	p.mu.Lock()
+	p.keysFetchedAt = time.Now().Add(-oidcJWKSMinRefresh - time.Second)
+	p.mu.Unlock()
+	if _, err := p.VerifyIDToken(ctx, rotated, "nonce-1"); err != nil {
+		t.Fatalf("verify with rotated key: %v", err)
+	}
+	if hits := idp.jwksHits.Load(); hits != 2 {
+		t.Fatalf("jwks hits = %d, want 2", hits)
+	}
+	// 旧钥匙仍在 JWKS 中，继续有效且不再请求
+	if _, err := p.VerifyIDToken(ctx, idp.sign(t, "key-1", nil), "nonce-1"); err != nil {
+		t.Fatalf("verify with key-1 after refresh: %v", err)
+	}
+	if hits := idp.jwksHits.Load(); hits != 2 {
+		t.Fatalf("jwks hits = %d, want 2", hits)
+	}
+}
+
+func TestOIDCClaimMapping(t *testing.T) {
+	idp := newFakeIdP(t)
+	idp.userinfo = map[string]interface{}{
+		"sub":     "user-42",
+		"profile": map[string]interface{}{"avatar": "https://cdn.example.com/alice.png"},
+		// 已签名的 claim 不会被 userinfo 覆盖
+		"mail": "spoofed@example.com",
+	}
+	p := idp.provider(map[string]string{
+		"id":             "employee_id",
+		"email":          "mail",
+		"email_verified": "mail_verified",
+		"name":           "display_name",
+		"avatar":         "profile.avatar",
+	})
+
+	raw := idp.sign(t, "key-1", jwt.MapClaims{
+		"employee_id":   float64(10086),
+		"mail":          "alice@corp.example.com",
+		"mail_verified": "true",
+		"display_name":  "Alice Zhang",
+	})
+	info, err := p.UserInfo(context.Background(), oauthToken(raw), "nonce-1")
+	if err != nil {
+		t.Fatalf("UserInfo: %v", err)
+	}
+	want := OAuthUserInfo{
+		ID:            "10086",
+		Email:         "alice@corp.example.com",
+		EmailVerified: true,
+		Name:          "Alice Zhang",
+		Avatar:        "https://cdn.example.com/alice.png",
+		Provider:      "fake",
+	}
+	if *info != want {
+		t.Fatalf("user info = %+v, want %+v", *info, want)
+	}
+	if idp.infoCalls.Load() != 1 {
+		t.Fatalf("userinfo calls = %d, want 1", idp.infoCalls.Load())
+	}
+
+	// 映射的 id claim 缺失时拒绝登录
+	raw = idp.sign(t, "key-1", nil)
+	if _, err := p.UserInfo(context.Background(), oauthToken(raw), "nonce-1"); !errors.Is(err, ErrIDTokenInvalid) {
+		t.Fatalf("err = %v, want ErrIDTokenInvalid for missing id claim", err)
+	}
+}
@@ -0,0 +1,227 @@
+package services
+
+import (
+	"LiteAdmin/models"
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrNotMerchant      = errors.New("only merchant accounts can manage pets")
+	ErrPetNotFound      = errors.New("pet not found")
+	ErrInvalidPet       = errors.New("name (max 200 characters), an active category and non-negative prices and stock are required")
+	ErrSKUTaken         = errors.New("sku is already used by another pet of this shop")
+	ErrInvalidPetStatus = errors.New("status must be on_sale, sold_out or off_shelf, and can only be changed after the pet is approved")
+)
+
+// 宠物商品状态：新建为 pending，平台审核通过（approved）后商家可以自行上下架
+const (
+	PetStatusPending  = "pending"
+	PetStatusApproved = "approved"
+	PetStatusOnSale   = "on_sale"
+	PetStatusSoldOut  = "sold_out"
+	PetStatusOffShelf = "off_shelf"
+	PetStatusRejected = "rejected"
+)
+
+const (
+	maxPetNameLength   = 200
+	defaultPetPageSize = 50
+	maxPetPageSize     = 200
+)
+
+// merchantPetStatuses 商家可以设置的状态
+var merchantPetStatuses = []string{PetStatusOnSale, PetStatusSoldOut, PetStatusOffShelf}
+
+// PetInput 新建/更新商品，字段为 nil 时保持不变（新建时按零值处理），ERP 同步库存时可以只传 stock
+type PetInput struct {
+	CategoryID     *uint   `json:"category_id"`
+	Name           *string `json:"name"`
+	ScientificName *string `json:"scientific_name"`
+	SKU            *string `json:"sku"` // 商家自定义 SKU，同一店铺内唯一
+	Description    *string `json:"description"`
+	OriginalPrice  *int64  `json:"original_price"` // 单位：分
+	CurrentPrice   *int64  `json:"current_price"`
+	CostPrice      *int64  `json:"cost_price"`
+	Stock          *int    `json:"stock"`
+	Status         *string `json:"status"` // 只能在审核通过后设置为 merchantPetStatuses 之一
+}
+
+// PetService 商家维护本店铺的宠物商品，商家 ERP 可以通过 scopes 包含 pets:write 的 API 密钥调用
+type PetService struct {
+	db    *gorm.DB
+	audit *AuditService
+}
+
+func NewPetService(db *gorm.DB, audit *AuditService) *PetService {
+	return &PetService{db: db, audit: audit}
+}
+
+// List 本店铺的商品，sku 非空时按 SKU 精确查找；page 从 1 开始
+func (s *PetService) List(user *models.User, sku string, page, pageSize int) ([]models.Pet, int64, error) {
+	merchantID, err := s.merchantOf(user)
+	if err != nil {
+		return nil, 0, err
+	}
+	query := s.db.Model(&models.Pet{}).Where("merchant_id = ?", merchantID)
+	if sku = strings.TrimSpace(sku); sku != "" {
+		query = query.Where("sku = ?", sku)
+	}
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 {
+		pageSize = defaultPetPageSize
+	}
+	if pageSize > maxPetPageSize {
+		pageSize = maxPetPageSize
+	}
+	var pets []models.Pet
+	err = query.Order("id ASC").Offset((page - 1) * pageSize).Limit(pageSize).Find(&pets).Error
+	return pets, total, err
+}
+
+// Create 新建商品，等待平台审核
+func (s *PetService) Create(ctx context.Context, user *models.User, input PetInput) (*models.Pet, error) {
+	merchantID, err := s.merchantOf(user)
+	if err != nil {
+		return nil, err
+	}
+	if input.Status != nil {
+		return nil, ErrInvalidPetStatus
+	}
+	pet := &models.Pet{MerchantID: merchantID, Status: PetStatusPending}
+	if err := s.apply(pet, input); err != nil {
+		return nil, err
+	}
+	if err := s.db.Create(pet).Error; err != nil {
+		return nil, err
+	}
+	s.audit.Record(ctx, AuditEntry{
+		Actor:      user,
+		Action:     AuditPetCreate,
+		TargetType: "pet",
+		TargetID:   pet.ID,
+		After:      pet,
+	})
+	return pet, nil
+}
+
+// Update 修改本店铺的商品
+func (s *PetService) Update(ctx context.Context, user *models.User, id uint, input PetInput) (*models.Pet, error) {
+	merchantID, err := s.merchantOf(user)
+	if err != nil {
+		return nil, err
+	}
+	var pet models.Pet
+	if err := s.db.Where("merchant_id = ?", merchantID).First(&pet, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrPetNotFound
+		}
+		return nil, err
+	}
+	before := pet
+	if input.Status != nil {
+		if pet.Status == PetStatusPending || pet.Status == PetStatusRejected || !slices.Contains(merchantPetStatuses, *input.Status) {
+			return nil, ErrInvalidPetStatus
+		}
+		pet.Status = *input.Status
+	}
+	if err := s.apply(&pet, input); err != nil {
+		return nil, err
+	}
+	if err := s.db.Save(&pet).Error; err != nil {
+		return nil, err
+	}
+	s.audit.Record(ctx, AuditEntry{
+		Actor:      user,
+		Action:     AuditPetUpdate,
+		TargetType: "pet",
+		TargetID:   pet.ID,
+		Before:     before,
+		After:      pet,
+	})
+	return &pet, nil
+}
+
+// apply 校验输入并写入商品（不含状态）
+func (s *PetService) apply(pet *models.Pet, input PetInput) error {
+	if input.CategoryID != nil {
+		pet.CategoryID = *input.CategoryID
+	}
+	if input.Name != nil {
+		pet.Name = strings.TrimSpace(*input.Name)
+	}
+	if input.ScientificName != nil {
+		pet.ScientificName = strings.TrimSpace(*input.ScientificName)
+	}
+	if input.Description != nil {
+		pet.Description = *input.Description
+	}
+	if input.OriginalPrice != nil {
+		pet.OriginalPrice = *input.OriginalPrice
+	}
+	if input.CurrentPrice != nil {
+		pet.CurrentPrice = *input.CurrentPrice
+	}
+	if input.CostPrice != nil {
+		pet.CostPrice = *input.CostPrice
+	}
+	if input.Stock != nil {
+		pet.Stock = *input.Stock
+	}
+	if pet.Name == "" || utf8.RuneCountInString(pet.Name) > maxPetNameLength ||
+		pet.OriginalPrice < 0 || pet.CurrentPrice < 0 || pet.CostPrice < 0 || pet.Stock < 0 {
+		return ErrInvalidPet
+	}
+	if input.CategoryID != nil {
+		var count int64
+		if err := s.db.Model(&models.PetCategory{}).Where("id = ? AND is_active = ?", pet.CategoryID, true).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			return ErrInvalidPet
+		}
+	} else if pet.CategoryID == 0 {
+		return ErrInvalidPet
+	}
+	if input.SKU != nil {
+		sku := strings.TrimSpace(*input.SKU)
+		if sku != "" {
+			var count int64
+			err := s.db.Model(&models.Pet{}).
+				Where("merchant_id = ? AND sku = ? AND id <> ?", pet.MerchantID, sku, pet.ID).
+				Count(&count).Error
+			if err != nil {
+				return err
+			}
+			if count > 0 {
+				return ErrSKUTaken
+			}
+		}
+		pet.SKU = sku
+	}
+	return nil
+}
+
+// merchantOf 商家账号对应的店铺 ID
+func (s *PetService) merchantOf(user *models.User) (uint, error) {
+	var merchant models.MerchantInfo
+	err := s.db.Select("id").Where("user_id = ?", user.ID).First(&merchant).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, ErrNotMerchant
+	}
+	if err != nil {
+		return 0, err
+	}
+	return merchant.ID, nil
+}
@@ -0,0 +1,192 @@
+package services
+
+import (
+	"LiteAdmin/models"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrRoleNotFound = errors.New("role not found")
+	ErrUserNotFound = errors.New("user not found")
+)
+
+// 权限缓存
+const (
+	rbacCacheTTL    = 10 * time.Minute
+	rbacVersionKey  = "rbac:version"
+	rbacUserKeyFmt  = "rbac:v%d:user:%d:permissions"
+	rbacDefaultRole = models.RoleClient
+)
+
+type RBACService struct {
+	db    *gorm.DB
+	redis *redis.Client
+}
+
+func NewRBACService(db *gorm.DB, redisClient *redis.Client) *RBACService {
+	return &RBACService{db: db, redis: redisClient}
+}
+
+// UserRoles 用户的全部角色：User.Type 对应的隐式角色 + 额外分配的角色
+func (s *RBACService) UserRoles(user *models.User) ([]models.Role, error) {
+	names := []string{primaryRole(user)}
+	var assigned []models.UserRole
+	if err := s.db.Preload("Role").Where("user_id = ?", user.ID).Find(&assigned).Error; err != nil {
+		return nil, err
+	}
+	for _, ur := range assigned {
+		names = append(names, ur.Role.Name)
+	}
+	var roles []models.Role
+	if err := s.db.Preload("Permissions").Where("name IN ?", names).Order("id ASC").Find(&roles).Error; err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+// UserPermissions 用户的有效权限（优先读 Redis 缓存）
+func (s *RBACService) UserPermissions(ctx context.Context, user *models.User) ([]string, error) {
+	key := s.cacheKey(ctx, user.ID)
+	if key != "" {
+		if cached, err := s.redis.Get(ctx, key).Result(); err == nil {
+			var perms []string
+			if json.Unmarshal([]byte(cached), &perms) == nil {
+				return perms, nil
+			}
+		}
+	}
+
+	roles, err := s.UserRoles(user)
+	if err != nil {
+		return nil, err
+	}
+	set := make(map[string]struct{})
+	for _, role := range roles {
+		for _, perm := range role.Permissions {
+			set[perm.Code] = struct{}{}
+		}
+	}
+	perms := make([]string, 0, len(set))
+	for code := range set {
+		perms = append(perms, code)
+	}
+	sort.Strings(perms)
+
+	if key != "" {
+		if data, err := json.Marshal(perms); err == nil {
+			if err := s.redis.Set(ctx, key, data, rbacCacheTTL).Err(); err != nil {
+				log.Printf("Failed to cache permissions: %v", err)
+			}
+		}
+	}
+	return perms, nil
+}
+
+// HasPermission 判断用户是否拥有某个权限
+func (s *RBACService) HasPermission(ctx context.Context, user *models.User, permission string) (bool, error) {
+	perms, err := s.UserPermissions(ctx, user)
+	if err != nil {
+		return false, err
+	}
+	for _, p := range perms {
+		if p == permission {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// ListRoles 所有角色及其权限
+func (s *RBACService) ListRoles() ([]models.Role, error) {
+	var roles []models.Role
+	err := s.db.Preload("Permissions").Order("id ASC").Find(&roles).Error
+	return roles, err
+}
+
+// AssignRole 为用户分配角色
+func (s *RBACService) AssignRole(ctx context.Context, userID uint, roleName string) error {
+	var role models.Role
+	if err := s.db.Where("name = ?", roleName).First(&role).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrRoleNotFound
+		}
+		return err
+	}
+	if err := s.db.First(&models.User{}, userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrUserNotFound
+		}
+		return err
+	}
+	userRole := models.UserRole{UserID: userID, RoleID: role.ID}
+	if err := s.db.Where(userRole).FirstOrCreate(&userRole).Error; err != nil {
+		return err
+	}
+	s.InvalidateUser(ctx, userID)
+	return nil
+}
+
+// RemoveRole 移除用户的额外角色（User.Type 对应的隐式角色需通过修改类型调整）
+func (s *RBACService) RemoveRole(ctx context.Context, userID uint, roleName string) error {
+	var role models.Role
+	if err := s.db.Where("name = ?", roleName).First(&role).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrRoleNotFound
+		}
+		return err
+	}
+	if err := s.db.Where("user_id = ? AND role_id = ?", userID, role.ID).Delete(&models.UserRole{}).Error; err != nil {
+		return err
+	}
+	s.InvalidateUser(ctx, userID)
+	return nil
+}
+
+// InvalidateUser 清除单个用户的权限缓存
+func (s *RBACService) InvalidateUser(ctx context.Context, userID uint) {
+	key := s.cacheKey(ctx, userID)
+	if key == "" {
+		return
+	}
+	if err := s.redis.Del(ctx, key).Err(); err != nil {
+		log.Printf("Failed to invalidate permission cache: %v", err)
+	}
+}
+
+// InvalidateAll 角色权限变化时使所有用户的缓存失效
+func (s *RBACService) InvalidateAll(ctx context.Context) {
+	if s.redis == nil {
+		return
+	}
+	if err := s.redis.Incr(ctx, rbacVersionKey).Err(); err != nil {
+		log.Printf("Failed to bump permission cache version: %v", err)
+	}
+}
+
+// 缓存 key 带版本号，InvalidateAll 只需递增版本
+func (s *RBACService) cacheKey(ctx context.Context, userID uint) string {
+	if s.redis == nil {
+		return ""
+	}
+	version, err := s.redis.Get(ctx, rbacVersionKey).Int64()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return ""
+	}
+	return fmt.Sprintf(rbacUserKeyFmt, version, userID)
+}
+
+func primaryRole(user *models.User) string {
+	if user.Type == "" {
+		return rbacDefaultRole
+	}
+	return user.Type
+}
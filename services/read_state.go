@@ -0,0 +1,81 @@
+package services
+
+import (
+	"LiteAdmin/models"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// MarkRead 把 messageID 记为用户在房间内的最后已读消息，只会向前推进；
+// 返回 false 表示已读位置没有变化（不需要广播已读回执）
+func (s *MessageService) MarkRead(chatRoomID string, userID, messageID uint) (bool, error) {
+	var count int64
+	if err := s.db.Model(&models.Message{}).
+		Where("id = ? AND room_id = ?", messageID, chatRoomID).
+		Count(&count).Error; err != nil {
+		return false, err
+	}
+	if count == 0 {
+		return false, ErrMessageNotFound
+	}
+	state := models.RoomReadState{
+		UserID:            userID,
+		RoomID:            chatRoomID,
+		LastReadMessageID: messageID,
+		UpdatedAt:         time.Now(),
+	}
+	result := s.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "room_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"last_read_message_id", "updated_at"}),
+		Where: clause.Where{Exprs: []clause.Expression{
+			clause.Expr{SQL: "room_read_states.last_read_message_id < EXCLUDED.last_read_message_id"},
+		}},
+	}).Create(&state)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// ReadStates 房间内所有用户的已读位置
+func (s *MessageService) ReadStates(chatRoomID string) ([]models.RoomReadState, error) {
+	var states []models.RoomReadState
+	err := s.db.Where("room_id = ?", chatRoomID).Order("updated_at DESC").Find(&states).Error
+	return states, err
+}
+
+// UnreadCounts 用户在各聊天室的未读消息数（不含自己发送的和已删除的消息）。
+// 有已读位置的房间从已读位置之后算起；没有已读位置时只统计成员加入之后的消息，既不是成员也没读过的房间不计未读
+func (s *MessageService) UnreadCounts(userID uint, chatRoomIDs []string) (map[string]int64, error) {
+	return countUnread(s.db, userID, chatRoomIDs)
+}
+
+func countUnread(db *gorm.DB, userID uint, chatRoomIDs []string) (map[string]int64, error) {
+	result := make(map[string]int64, len(chatRoomIDs))
+	if len(chatRoomIDs) == 0 {
+		return result, nil
+	}
+	var rows []struct {
+		RoomID string
+		Count  int64
+	}
+	err := db.Table("messages").
+		Select("messages.room_id, COUNT(*) AS count").
+		Joins("LEFT JOIN room_read_states ON room_read_states.room_id = messages.room_id AND room_read_states.user_id = ?", userID).
+		Joins("LEFT JOIN room_members ON CAST(room_members.room_id AS TEXT) = messages.room_id AND room_members.user_id = ?", userID).
+		Where("messages.room_id IN ? AND messages.user_id <> ? AND messages.deleted_at IS NULL", chatRoomIDs, userID).
+		// 没有已读位置也不是成员时两个条件都为 NULL，不计入
+		Where("messages.id > room_read_states.last_read_message_id OR " +
+			"(room_read_states.user_id IS NULL AND messages.created_at > room_members.created_at)").
+		Group("messages.room_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		result[row.RoomID] = row.Count
+	}
+	return result, nil
+}
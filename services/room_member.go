@@ -0,0 +1,420 @@
+package services
+
+import (
+	"LiteAdmin/models"
+	"context"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrNotRoomMember     = errors.New("not a member of this room")
+	ErrInviteRequired    = errors.New("this room can only be joined with an invite")
+	ErrInviteInvalid     = errors.New("invite is invalid or expired")
+	ErrInviteNotFound    = errors.New("invite not found")
+	ErrOwnerCannotLeave  = errors.New("the owner cannot leave the room")
+	ErrInvalidRoomRole   = errors.New("invalid room role")
+	ErrMemberNotFound    = errors.New("member not found")
+	ErrCannotManageOwner = errors.New("cannot change the room owner")
+)
+
+// CustomerServiceRoomPrefix 客服会话的聊天室 ID 前缀，格式 customer_service_<room_id>
+const CustomerServiceRoomPrefix = "customer_service_"
+
+// roomRoleRank 角色等级，用于判断能否管理其他成员
+var roomRoleRank = map[string]int{
+	models.RoomRoleMember:    1,
+	models.RoomRoleModerator: 2,
+	models.RoomRoleOwner:     3,
+}
+
+// MemberRole 用户在房间中的角色，非成员返回空字符串
+func (s *RoomService) MemberRole(room *models.Room, userID uint) (string, error) {
+	if room.OwnerID != 0 && room.OwnerID == userID {
+		return models.RoomRoleOwner, nil
+	}
+	var member models.RoomMember
+	err := s.db.Where("room_id = ? AND user_id = ?", room.ID, userID).First(&member).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return member.Role, nil
+}
+
+// JoinRoom 加入房间：公开房间直接加入，密码房间需验证密码，私密房间只能通过邀请加入
+func (s *RoomService) JoinRoom(roomID uint, user *models.User, password string) (*models.Room, error) {
+	room, err := s.findRoom(roomID)
+	if err != nil {
+		return nil, err
+	}
+	role, err := s.MemberRole(room, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if role != "" {
+		return room, nil
+	}
+	if err := s.checkBanned(room.ID, user.ID); err != nil {
+		return nil, err
+	}
+	switch room.Privacy {
+	case models.RoomPrivacyPublic:
+	case models.RoomPrivacyPassword:
+		if err := verifyRoomPassword(room, password); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, ErrInviteRequired
+	}
+	if err := s.addMember(s.db, room.ID, user.ID, models.RoomRoleMember); err != nil {
+		return nil, err
+	}
+	return room, nil
+}
+
+// JoinByInvite 使用邀请码加入房间
+func (s *RoomService) JoinByInvite(code string, user *models.User) (*models.Room, error) {
+	var room models.Room
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var invite models.RoomInvite
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("code = ? AND revoked_at IS NULL", code).First(&invite).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrInviteInvalid
+			}
+			return err
+		}
+		if invite.ExpiresAt != nil && time.Now().After(*invite.ExpiresAt) {
+			return ErrInviteInvalid
+		}
+		if err := tx.First(&room, invite.RoomID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrInviteInvalid
+			}
+			return err
+		}
+		if err := s.checkBanned(room.ID, user.ID); err != nil {
+			return err
+		}
+		var count int64
+		if err := tx.Model(&models.RoomMember{}).Where("room_id = ? AND user_id = ?", room.ID, user.ID).Count(&count).Error; err != nil {
+			return err
+		}
+		// 已是成员时不消耗邀请次数
+		if count > 0 || room.OwnerID == user.ID {
+			return nil
+		}
+		if invite.MaxUses > 0 && invite.Uses >= invite.MaxUses {
+			return ErrInviteInvalid
+		}
+		if err := tx.Model(&invite).UpdateColumn("uses", gorm.Expr("uses + 1")).Error; err != nil {
+			return err
+		}
+		return s.addMember(tx, room.ID, user.ID, models.RoomRoleMember)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &room, nil
+}
+
+// LeaveRoom 退出房间，房主不能退出（只能删除房间）
+func (s *RoomService) LeaveRoom(ctx context.Context, roomID uint, user *models.User) error {
+	room, err := s.findRoom(roomID)
+	if err != nil {
+		return err
+	}
+	if room.OwnerID == user.ID {
+		return ErrOwnerCannotLeave
+	}
+	result := s.db.Where("room_id = ? AND user_id = ?", roomID, user.ID).Delete(&models.RoomMember{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotRoomMember
+	}
+	s.disconnectMember(ctx, roomID, user.ID, "left the room")
+	return nil
+}
+
+// ListMembers 房间成员列表，只有成员可以查看
+func (s *RoomService) ListMembers(roomID uint, user *models.User) ([]models.RoomMember, error) {
+	room, err := s.findRoom(roomID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.requireRole(room, user.ID, models.RoomRoleMember); err != nil {
+		return nil, err
+	}
+	var members []models.RoomMember
+	if err := s.db.Preload("User").Where("room_id = ?", roomID).Order("created_at ASC").Find(&members).Error; err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+// UpdateMemberRole 房主设置成员角色（moderator/member）
+func (s *RoomService) UpdateMemberRole(ctx context.Context, roomID, memberID uint, role string, user *models.User) error {
+	if role != models.RoomRoleModerator && role != models.RoomRoleMember {
+		return ErrInvalidRoomRole
+	}
+	room, err := s.findRoom(roomID)
+	if err != nil {
+		return err
+	}
+	if _, err := s.requireRole(room, user.ID, models.RoomRoleOwner); err != nil {
+		return err
+	}
+	if memberID == room.OwnerID {
+		return ErrCannotManageOwner
+	}
+	result := s.db.Model(&models.RoomMember{}).
+		Where("room_id = ? AND user_id = ?", roomID, memberID).
+		Update("role", role)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrMemberNotFound
+	}
+	s.audit.Record(ctx, AuditEntry{
+		Actor:      user,
+		Action:     AuditRoomMemberRole,
+		TargetType: "room",
+		TargetID:   roomID,
+		After:      map[string]interface{}{"user_id": memberID, "role": role},
+	})
+	return nil
+}
+
+// RemoveMember 移出成员：房主可移出任何人，版主只能移出普通成员
+func (s *RoomService) RemoveMember(ctx context.Context, roomID, memberID uint, user *models.User) error {
+	room, err := s.findRoom(roomID)
+	if err != nil {
+		return err
+	}
+	actorRole, err := s.requireRole(room, user.ID, models.RoomRoleModerator)
+	if err != nil {
+		return err
+	}
+	if memberID == room.OwnerID {
+		return ErrCannotManageOwner
+	}
+	targetRole, err := s.MemberRole(room, memberID)
+	if err != nil {
+		return err
+	}
+	if targetRole == "" {
+		return ErrMemberNotFound
+	}
+	if roomRoleRank[targetRole] >= roomRoleRank[actorRole] {
+		return ErrAccessDenied
+	}
+	if err := s.db.Where("room_id = ? AND user_id = ?", roomID, memberID).Delete(&models.RoomMember{}).Error; err != nil {
+		return err
+	}
+	s.audit.Record(ctx, AuditEntry{
+		Actor:      user,
+		Action:     AuditRoomMemberRemove,
+		TargetType: "room",
+		TargetID:   roomID,
+		Before:     map[string]interface{}{"user_id": memberID, "role": targetRole},
+	})
+	s.disconnectMember(ctx, roomID, memberID, "removed from the room")
+	return nil
+}
+
+// CreateInvite 房主或版主创建邀请链接，expiresIn 为 0 表示不过期，maxUses 为 0 表示不限次数
+func (s *RoomService) CreateInvite(roomID uint, user *models.User, expiresIn time.Duration, maxUses int) (*models.RoomInvite, error) {
+	room, err := s.findRoom(roomID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.requireRole(room, user.ID, models.RoomRoleModerator); err != nil {
+		return nil, err
+	}
+	code, err := newOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+	invite := &models.RoomInvite{
+		RoomID:    roomID,
+		Code:      code,
+		CreatedBy: user.ID,
+		MaxUses:   maxUses,
+	}
+	if expiresIn > 0 {
+		expiresAt := time.Now().Add(expiresIn)
+		invite.ExpiresAt = &expiresAt
+	}
+	if err := s.db.Create(invite).Error; err != nil {
+		return nil, err
+	}
+	return invite, nil
+}
+
+// ListInvites 房间的邀请链接（房主或版主）
+func (s *RoomService) ListInvites(roomID uint, user *models.User) ([]models.RoomInvite, error) {
+	room, err := s.findRoom(roomID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.requireRole(room, user.ID, models.RoomRoleModerator); err != nil {
+		return nil, err
+	}
+	var invites []models.RoomInvite
+	err = s.db.Where("room_id = ?", roomID).Order("created_at DESC").Find(&invites).Error
+	return invites, err
+}
+
+// RevokeInvite 撤销邀请链接（房主或版主）
+func (s *RoomService) RevokeInvite(roomID, inviteID uint, user *models.User) error {
+	room, err := s.findRoom(roomID)
+	if err != nil {
+		return err
+	}
+	if _, err := s.requireRole(room, user.ID, models.RoomRoleModerator); err != nil {
+		return err
+	}
+	result := s.db.Model(&models.RoomInvite{}).
+		Where("id = ? AND room_id = ? AND revoked_at IS NULL", inviteID, roomID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrInviteNotFound
+	}
+	return nil
+}
+
+// AuthorizeConnection 校验用户能否连接聊天室 WebSocket
+// 数字 ID 对应 rooms 表：公开房间任何人可进入，其余房间必须是成员（密码房间在 JoinRoom 验证密码后成为成员），被封禁的用户不能进入；
+// customer_service_<room_id> 为客服会话，只允许会话所属用户、当前接待的客服和有主管权限的用户
+func (s *RoomService) AuthorizeConnection(ctx context.Context, chatRoomID string, user *models.User) (*ChatAccess, error) {
+	if strings.HasPrefix(chatRoomID, CustomerServiceRoomPrefix) {
+		roomID, err := strconv.ParseUint(strings.TrimPrefix(chatRoomID, CustomerServiceRoomPrefix), 10, 64)
+		if err != nil {
+			return nil, ErrRoomNotFound
+		}
+		var session models.CustomerSession
+		if err := s.db.Where("room_id = ?", roomID).First(&session).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, ErrRoomNotFound
+			}
+			return nil, err
+		}
+		if session.UserID == user.ID || (session.AgentID != nil && *session.AgentID == user.ID) {
+			return &ChatAccess{}, nil
+		}
+		allowed, err := s.rbac.HasPermission(ctx, user, models.PermCustomerServiceSupervise)
+		if err != nil {
+			return nil, err
+		}
+		if !allowed {
+			return nil, ErrAccessDenied
+		}
+		return &ChatAccess{}, nil
+	}
+
+	roomID, err := strconv.ParseUint(chatRoomID, 10, 64)
+	if err != nil {
+		return nil, ErrRoomNotFound
+	}
+	room, err := s.findRoom(uint(roomID))
+	if err != nil {
+		return nil, err
+	}
+	role, err := s.MemberRole(room, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if role == "" && room.Privacy != models.RoomPrivacyPublic {
+		if room.Privacy == models.RoomPrivacyPrivate {
+			// 私密房间对非成员不可见
+			return nil, ErrRoomNotFound
+		}
+		return nil, ErrNotRoomMember
+	}
+	if err := s.checkBanned(room.ID, user.ID); err != nil {
+		return nil, err
+	}
+	access := &ChatAccess{RoomID: room.ID, Role: role, SlowMode: room.SlowMode}
+	mute, err := s.activeRestriction(room.ID, user.ID, models.RestrictionMute)
+	if err != nil {
+		return nil, err
+	}
+	if mute != nil {
+		access.Muted = true
+		access.MutedUntil = mute.ExpiresAt
+	}
+	return access, nil
+}
+
+func (s *RoomService) requireRole(room *models.Room, userID uint, minRole string) (string, error) {
+	role, err := s.MemberRole(room, userID)
+	if err != nil {
+		return "", err
+	}
+	if role == "" {
+		if room.Privacy == models.RoomPrivacyPrivate {
+			return "", ErrRoomNotFound
+		}
+		return "", ErrNotRoomMember
+	}
+	if roomRoleRank[role] < roomRoleRank[minRole] {
+		return "", ErrAccessDenied
+	}
+	return role, nil
+}
+
+func (s *RoomService) addMember(tx *gorm.DB, roomID, userID uint, role string) error {
+	member := models.RoomMember{RoomID: roomID, UserID: userID, Role: role}
+	return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&member).Error
+}
+
+func (s *RoomService) findRoom(roomID uint) (*models.Room, error) {
+	var room models.Room
+	if err := s.db.First(&room, roomID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrRoomNotFound
+		}
+		return nil, err
+	}
+	return &room, nil
+}
+
+// disconnectMember 断开用户在该房间的聊天连接
+func (s *RoomService) disconnectMember(ctx context.Context, roomID, userID uint, reason string) {
+	err := PublishChatControl(ctx, s.redis, ChatControlEvent{
+		Action: ChatControlDisconnectUser,
+		UserID: userID,
+		RoomID: strconv.FormatUint(uint64(roomID), 10),
+		Reason: reason,
+	})
+	if err != nil {
+		log.Printf("Failed to publish room disconnect for user %d: %v", userID, err)
+	}
+}
+
+// verifyRoomPassword 校验密码房间的密码
+func verifyRoomPassword(room *models.Room, password string) error {
+	if password == "" {
+		return ErrPasswordRequired
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(room.Password), []byte(password)); err != nil {
+		return ErrIncorrectPassword
+	}
+	return nil
+}
@@ -0,0 +1,361 @@
+package services
+
+import (
+	"LiteAdmin/models"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrBannedFromRoom      = errors.New("you are banned from this room")
+	ErrMutedInRoom         = errors.New("you are muted in this room")
+	ErrRestrictionNotFound = errors.New("restriction not found")
+	ErrCannotModerateSelf  = errors.New("cannot moderate yourself")
+	ErrInvalidSlowMode     = errors.New("slow mode must be between 0 and 3600 seconds")
+)
+
+// maxSlowModeSeconds 慢速模式最大间隔
+const maxSlowModeSeconds = 3600
+
+// ChatAccess 连接聊天室时的权限信息，由 AuthorizeConnection 返回并保存在连接上
+type ChatAccess struct {
+	RoomID     uint       // rooms 表 ID，客服会话为 0
+	Role       string     // 房间角色，公开房间的非成员为空
+	Muted      bool       // 是否被禁言
+	MutedUntil *time.Time // 禁言到期时间，为空表示永久
+	SlowMode   int        // 慢速模式间隔（秒）
+}
+
+// CanModerate 是否为房主或版主（不受慢速模式限制，可使用管理指令）
+func (a *ChatAccess) CanModerate() bool {
+	return roomRoleRank[a.Role] >= roomRoleRank[models.RoomRoleModerator]
+}
+
+// KickUser 把用户踢出聊天室：断开其在所有节点上的连接，不影响成员身份
+func (s *RoomService) KickUser(ctx context.Context, roomID, targetID uint, actor *models.User, reason string) error {
+	room, err := s.findRoom(roomID)
+	if err != nil {
+		return err
+	}
+	target, err := s.moderationTarget(room, actor, targetID)
+	if err != nil {
+		return err
+	}
+	s.disconnectMember(ctx, roomID, targetID, "kicked from the room")
+	s.announce(ctx, roomID, withReason(fmt.Sprintf("%s 被 %s 踢出了聊天室", target.Username, actor.Username), reason))
+	s.audit.Record(ctx, AuditEntry{
+		Actor:      actor,
+		Action:     AuditRoomKick,
+		TargetType: "room",
+		TargetID:   roomID,
+		After:      map[string]interface{}{"user_id": targetID, "reason": reason},
+	})
+	return nil
+}
+
+// BanUser 封禁用户，duration 为 0 表示永久；被封禁的用户立即断开且无法重新连接
+func (s *RoomService) BanUser(ctx context.Context, roomID, targetID uint, actor *models.User, duration time.Duration, reason string) (*models.RoomRestriction, error) {
+	room, err := s.findRoom(roomID)
+	if err != nil {
+		return nil, err
+	}
+	target, err := s.moderationTarget(room, actor, targetID)
+	if err != nil {
+		return nil, err
+	}
+	restriction, err := s.restrict(ctx, roomID, targetID, actor, models.RestrictionBan, duration, reason, AuditRoomBan)
+	if err != nil {
+		return nil, err
+	}
+	s.disconnectMember(ctx, roomID, targetID, "banned from the room")
+	s.announce(ctx, roomID, withReason(fmt.Sprintf("%s 被 %s 封禁%s", target.Username, actor.Username, durationText(duration)), reason))
+	return restriction, nil
+}
+
+// UnbanUser 解除封禁
+func (s *RoomService) UnbanUser(ctx context.Context, roomID, targetID uint, actor *models.User) error {
+	return s.lift(ctx, roomID, targetID, actor, models.RestrictionBan, AuditRoomUnban, "%s 被 %s 解除了封禁")
+}
+
+// MuteUser 禁言用户，duration 为 0 表示永久；用户保持连接但不能发送消息
+func (s *RoomService) MuteUser(ctx context.Context, roomID, targetID uint, actor *models.User, duration time.Duration, reason string) (*models.RoomRestriction, error) {
+	room, err := s.findRoom(roomID)
+	if err != nil {
+		return nil, err
+	}
+	target, err := s.moderationTarget(room, actor, targetID)
+	if err != nil {
+		return nil, err
+	}
+	restriction, err := s.restrict(ctx, roomID, targetID, actor, models.RestrictionMute, duration, reason, AuditRoomMute)
+	if err != nil {
+		return nil, err
+	}
+	s.publishControl(ctx, ChatControlEvent{
+		Action: ChatControlMuteUser,
+		UserID: targetID,
+		RoomID: strconv.FormatUint(uint64(roomID), 10),
+		Until:  restriction.ExpiresAt,
+	})
+	s.announce(ctx, roomID, withReason(fmt.Sprintf("%s 被 %s 禁言%s", target.Username, actor.Username, durationText(duration)), reason))
+	return restriction, nil
+}
+
+// UnmuteUser 解除禁言
+func (s *RoomService) UnmuteUser(ctx context.Context, roomID, targetID uint, actor *models.User) error {
+	if err := s.lift(ctx, roomID, targetID, actor, models.RestrictionMute, AuditRoomUnmute, "%s 被 %s 解除了禁言"); err != nil {
+		return err
+	}
+	s.publishControl(ctx, ChatControlEvent{
+		Action: ChatControlUnmuteUser,
+		UserID: targetID,
+		RoomID: strconv.FormatUint(uint64(roomID), 10),
+	})
+	return nil
+}
+
+// SetSlowMode 设置慢速模式，seconds 为 0 表示关闭
+func (s *RoomService) SetSlowMode(ctx context.Context, roomID uint, seconds int, actor *models.User) error {
+	if seconds < 0 || seconds > maxSlowModeSeconds {
+		return ErrInvalidSlowMode
+	}
+	room, err := s.findRoom(roomID)
+	if err != nil {
+		return err
+	}
+	if _, err := s.requireRole(room, actor.ID, models.RoomRoleModerator); err != nil {
+		return err
+	}
+	if err := s.db.Model(room).Update("slow_mode", seconds).Error; err != nil {
+		return err
+	}
+	s.audit.Record(ctx, AuditEntry{
+		Actor:      actor,
+		Action:     AuditRoomSlowMode,
+		TargetType: "room",
+		TargetID:   roomID,
+		Before:     map[string]interface{}{"slow_mode": room.SlowMode},
+		After:      map[string]interface{}{"slow_mode": seconds},
+	})
+	s.publishControl(ctx, ChatControlEvent{
+		Action:  ChatControlSlowMode,
+		RoomID:  strconv.FormatUint(uint64(roomID), 10),
+		Seconds: seconds,
+	})
+	content := fmt.Sprintf("%s 关闭了慢速模式", actor.Username)
+	if seconds > 0 {
+		content = fmt.Sprintf("%s 开启了慢速模式：每 %d 秒只能发送一条消息", actor.Username, seconds)
+	}
+	s.announce(ctx, roomID, content)
+	return nil
+}
+
+// ListRestrictions 房间内当前生效的封禁和禁言（房主或版主）
+func (s *RoomService) ListRestrictions(roomID uint, actor *models.User) ([]models.RoomRestriction, error) {
+	room, err := s.findRoom(roomID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.requireRole(room, actor.ID, models.RoomRoleModerator); err != nil {
+		return nil, err
+	}
+	var restrictions []models.RoomRestriction
+	err = activeRestrictions(s.db, time.Now()).
+		Where("room_id = ?", roomID).
+		Order("created_at DESC").
+		Find(&restrictions).Error
+	return restrictions, err
+}
+
+// CheckSlowMode 慢速模式下检查用户能否发言，返回还需等待的时间
+func (s *RoomService) CheckSlowMode(ctx context.Context, chatRoomID string, userID uint, seconds int) (time.Duration, error) {
+	if seconds <= 0 || s.redis == nil {
+		return 0, nil
+	}
+	key := fmt.Sprintf("chat:room:%s:slow:%d", chatRoomID, userID)
+	ok, err := s.redis.SetNX(ctx, key, 1, time.Duration(seconds)*time.Second).Result()
+	if err != nil || ok {
+		return 0, err
+	}
+	wait, err := s.redis.PTTL(ctx, key).Result()
+	if err != nil {
+		return 0, err
+	}
+	if wait <= 0 {
+		// key 恰好过期，下一次发送会重新计时
+		return 0, nil
+	}
+	return wait, nil
+}
+
+// activeRestriction 用户在房间内生效的指定类型处罚，没有时返回 nil
+func (s *RoomService) activeRestriction(roomID, userID uint, restrictionType string) (*models.RoomRestriction, error) {
+	var restriction models.RoomRestriction
+	err := activeRestrictions(s.db, time.Now()).
+		Where("room_id = ? AND user_id = ? AND type = ?", roomID, userID, restrictionType).
+		Order("created_at DESC").
+		First(&restriction).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &restriction, nil
+}
+
+// checkBanned 被封禁时返回 ErrBannedFromRoom
+func (s *RoomService) checkBanned(roomID, userID uint) error {
+	ban, err := s.activeRestriction(roomID, userID, models.RestrictionBan)
+	if err != nil {
+		return err
+	}
+	if ban != nil {
+		return ErrBannedFromRoom
+	}
+	return nil
+}
+
+// moderationTarget 校验操作者至少是版主，且目标的角色低于操作者
+func (s *RoomService) moderationTarget(room *models.Room, actor *models.User, targetID uint) (*models.User, error) {
+	actorRole, err := s.requireRole(room, actor.ID, models.RoomRoleModerator)
+	if err != nil {
+		return nil, err
+	}
+	if targetID == actor.ID {
+		return nil, ErrCannotModerateSelf
+	}
+	if targetID == room.OwnerID {
+		return nil, ErrCannotManageOwner
+	}
+	var target models.User
+	if err := s.db.Select("id", "username").First(&target, targetID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+	targetRole, err := s.MemberRole(room, targetID)
+	if err != nil {
+		return nil, err
+	}
+	if roomRoleRank[targetRole] >= roomRoleRank[actorRole] {
+		return nil, ErrAccessDenied
+	}
+	return &target, nil
+}
+
+// restrict 新建处罚记录，同类型的旧记录被替换
+func (s *RoomService) restrict(ctx context.Context, roomID, targetID uint, actor *models.User, restrictionType string, duration time.Duration, reason, action string) (*models.RoomRestriction, error) {
+	now := time.Now()
+	restriction := &models.RoomRestriction{
+		RoomID:    roomID,
+		UserID:    targetID,
+		Type:      restrictionType,
+		Reason:    reason,
+		CreatedBy: actor.ID,
+	}
+	if duration > 0 {
+		expiresAt := now.Add(duration)
+		restriction.ExpiresAt = &expiresAt
+	}
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := activeRestrictions(tx.Model(&models.RoomRestriction{}), now).
+			Where("room_id = ? AND user_id = ? AND type = ?", roomID, targetID, restrictionType).
+			Update("lifted_at", now).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(restriction).Error; err != nil {
+			return err
+		}
+		return s.audit.RecordTx(ctx, tx, AuditEntry{
+			Actor:      actor,
+			Action:     action,
+			TargetType: "room",
+			TargetID:   roomID,
+			After: map[string]interface{}{
+				"user_id":    targetID,
+				"reason":     reason,
+				"expires_at": restriction.ExpiresAt,
+			},
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return restriction, nil
+}
+
+// lift 提前解除处罚（房主或版主），notice 为系统消息模板（被解除者、操作者）
+func (s *RoomService) lift(ctx context.Context, roomID, targetID uint, actor *models.User, restrictionType, action, notice string) error {
+	room, err := s.findRoom(roomID)
+	if err != nil {
+		return err
+	}
+	if _, err := s.requireRole(room, actor.ID, models.RoomRoleModerator); err != nil {
+		return err
+	}
+	now := time.Now()
+	result := activeRestrictions(s.db.Model(&models.RoomRestriction{}), now).
+		Where("room_id = ? AND user_id = ? AND type = ?", roomID, targetID, restrictionType).
+		Update("lifted_at", now)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrRestrictionNotFound
+	}
+	s.audit.Record(ctx, AuditEntry{
+		Actor:      actor,
+		Action:     action,
+		TargetType: "room",
+		TargetID:   roomID,
+		Before:     map[string]interface{}{"user_id": targetID},
+	})
+	var target models.User
+	if err := s.db.Select("id", "username").First(&target, targetID).Error; err != nil {
+		target.Username = strconv.FormatUint(uint64(targetID), 10)
+	}
+	s.announce(ctx, roomID, fmt.Sprintf(notice, target.Username, actor.Username))
+	return nil
+}
+
+// announce 向房间广播系统消息（所有节点）
+func (s *RoomService) announce(ctx context.Context, roomID uint, content string) {
+	s.publishControl(ctx, ChatControlEvent{
+		Action:  ChatControlSystemMessage,
+		RoomID:  strconv.FormatUint(uint64(roomID), 10),
+		Content: content,
+	})
+}
+
+func (s *RoomService) publishControl(ctx context.Context, event ChatControlEvent) {
+	if err := PublishChatControl(ctx, s.redis, event); err != nil {
+		log.Printf("Failed to publish chat control %s for room %s: %v", event.Action, event.RoomID, err)
+	}
+}
+
+// activeRestrictions 过滤出未解除且未过期的处罚
+func activeRestrictions(db *gorm.DB, now time.Time) *gorm.DB {
+	return db.Where("lifted_at IS NULL AND (expires_at IS NULL OR expires_at > ?)", now)
+}
+
+func durationText(duration time.Duration) string {
+	if duration <= 0 {
+		return ""
+	}
+	return "，时长 " + duration.String()
+}
+
+func withReason(content, reason string) string {
+	if reason == "" {
+		return content
+	}
+	return content + "（原因：" + reason + "）"
+}
@@ -1,12 +1,14 @@
 package services
 
 import (
-	"LiteAdmin/config"
 	"LiteAdmin/models"
 	"LiteAdmin/redis"
 	"context"
 	"errors"
+	"log"
+	"strconv"
 
+	goredis "github.com/redis/go-redis/v9"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
@@ -16,29 +18,31 @@ var (
 	ErrAccessDenied      = errors.New("access denied")
 	ErrPasswordRequired  = errors.New("password required")
 	ErrIncorrectPassword = errors.New("incorrect password")
+	ErrInvalidPrivacy    = errors.New("privacy must be public, password or private")
 )
 
-type CreateRoomDTO struct {
-	Name        string `json:"name"        validate:"required,min=3,max=50"`
-	Description string `json:"description" validate:"max=255"`                                // 假设描述是可选的，但有最大长度
-	Type        string `json:"type"        validate:"required,oneof=chat game collaboration"` // 示例：类型必须是这三者之一
-	Privacy     string `json:"privacy"     validate:"required,oneof=public password"`         // 隐私设置必须是 'public' 或 'password'
-	Language    string `json:"language"    validate:"required"`
-	Password    string `json:"password,omitempty" validate:"omitempty,required_if=Privacy password,min=6"`
-}
-
 type RoomService struct {
-	db  *gorm.DB
-	cfg *config.RedisConfig
+	db    *gorm.DB
+	redis *goredis.Client // 发布聊天控制指令（踢出成员等）、读取在线人数
+	rbac  *RBACService
+	audit *AuditService
 }
 
-func NewRoomService(db *gorm.DB, cfg *config.RedisConfig) *RoomService {
-	return &RoomService{db: db}
+func NewRoomService(db *gorm.DB, redisClient *goredis.Client, rbac *RBACService, audit *AuditService) *RoomService {
+	return &RoomService{db: db, redis: redisClient, rbac: rbac, audit: audit}
 }
 
 func (s *RoomService) CreateRoom(inputRoom models.Room, user *models.User) (*models.Room, error) {
+	if inputRoom.Privacy == "" {
+		inputRoom.Privacy = models.RoomPrivacyPublic
+	}
+	if inputRoom.Privacy != models.RoomPrivacyPublic &&
+		inputRoom.Privacy != models.RoomPrivacyPassword &&
+		inputRoom.Privacy != models.RoomPrivacyPrivate {
+		return nil, ErrInvalidPrivacy
+	}
 	var hashedPassword string
-	if inputRoom.Privacy == "password" {
+	if inputRoom.Privacy == models.RoomPrivacyPassword {
 		if inputRoom.Password == "" {
 			return nil, errors.New("password is required for private rooms")
 		}
@@ -58,29 +62,48 @@ func (s *RoomService) CreateRoom(inputRoom models.Room, user *models.User) (*mod
 		OwnerID:     user.ID,
 		IsActive:    true,
 	}
-	if err := s.db.Create(&room).Error; err != nil {
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&room).Error; err != nil {
+			return err
+		}
+		return s.addMember(tx, room.ID, user.ID, models.RoomRoleOwner)
+	})
+	if err != nil {
 		return nil, err
 	}
+	room.Password = ""
 	return &room, nil
 }
 
-func (s *RoomService) ListRooms() ([]models.RoomWithUser, error) {
+// ListRooms 房间列表，私密房间只对成员可见
+func (s *RoomService) ListRooms(user *models.User) ([]models.RoomWithUser, error) {
 	var results []models.RoomWithUser
 	err := s.db.Table("rooms").
 		Select("rooms.*, users.username").
 		Joins("LEFT JOIN users ON users.id = rooms.owner_id").
+		Where("rooms.privacy <> ? OR rooms.owner_id = ? OR EXISTS (SELECT 1 FROM room_members WHERE room_members.room_id = rooms.id AND room_members.user_id = ?)",
+			models.RoomPrivacyPrivate, user.ID, user.ID).
 		Order("rooms.created_at DESC").
 		Scan(&results).Error
 	if err != nil {
 		return nil, err
 	}
+	chatRoomIDs := make([]string, len(results))
+	for i := range results {
+		chatRoomIDs[i] = strconv.FormatUint(uint64(results[i].ID), 10)
+	}
+	unread, err := countUnread(s.db, user.ID, chatRoomIDs)
+	if err != nil {
+		return nil, err
+	}
+	// 在线人数读取失败时按 0 显示，不影响房间列表
+	online, err := redis.OnlineCounts(context.Background(), s.redis, chatRoomIDs)
+	if err != nil {
+		log.Printf("Failed to count online users: %v", err)
+	}
 	for i := 0; i < len(results); i++ {
-		ctx := context.Background()
-		users, redisErr := redis.GetRedis(s.cfg).GetOnlineUsers(ctx, results[i].Type, results[i].ID)
-		if redisErr != nil {
-			continue
-		}
-		results[i].OnlineUsers = uint(len(users))
+		results[i].UnreadCount = unread[chatRoomIDs[i]]
+		results[i].OnlineUsers = uint(online[chatRoomIDs[i]])
 		results[i].Password = ""
 	}
 	return results, nil
@@ -89,28 +112,25 @@ func (s *RoomService) ListRooms() ([]models.RoomWithUser, error) {
 // AuthorizeRoomEntry 验证用户是否有权进入房间（例如通过密码）
 // 业务逻辑：检查房间是否存在，并验证密码（如果需要）
 func (s *RoomService) AuthorizeRoomEntry(roomID uint, password string) (*models.Room, error) {
-	var room models.Room
-	if err := s.db.First(&room, roomID).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, ErrRoomNotFound
-		}
+	room, err := s.findRoom(roomID)
+	if err != nil {
 		return nil, err
 	}
-	if room.Privacy == "password" {
-		if password == "" {
-			return nil, ErrPasswordRequired
-		}
-
-		if err := bcrypt.CompareHashAndPassword([]byte(room.Password), []byte(password)); err != nil {
-			return nil, ErrIncorrectPassword
+	if room.Privacy == models.RoomPrivacyPassword {
+		if err := verifyRoomPassword(room, password); err != nil {
+			return nil, err
 		}
 	}
-	return &room, nil
+	return room, nil
 }
 
 // DeleteRoom 删除房间
-// 业务逻辑：检查房间是否存在，并验证是否为房主
-func (s *RoomService) DeleteRoom(roomID uint, user *models.User) error {
+// 业务逻辑：检查房间是否存在，并验证是否为房主或拥有 room:delete:any 权限
+func (s *RoomService) DeleteRoom(ctx context.Context, roomID uint, user *models.User) error {
+	canDeleteAny, err := s.rbac.HasPermission(ctx, user, models.PermRoomDeleteAny)
+	if err != nil {
+		return err
+	}
 	var room models.Room
 	// 必须用事务，确保“先查后删”的原子性
 	return s.db.Transaction(func(tx *gorm.DB) error {
@@ -121,18 +141,33 @@ func (s *RoomService) DeleteRoom(roomID uint, user *models.User) error {
 			return err
 		}
 
-		if room.OwnerID != user.ID {
+		if room.OwnerID != user.ID && !canDeleteAny {
 			return ErrAccessDenied
 		}
 
 		if err := tx.Delete(&room).Error; err != nil {
 			return err
 		}
-		return nil
+		if err := tx.Where("room_id = ?", room.ID).Delete(&models.RoomMember{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("room_id = ?", room.ID).Delete(&models.RoomInvite{}).Error; err != nil {
+			return err
+		}
+		before := room
+		before.Password = ""
+		return s.audit.RecordTx(ctx, tx, AuditEntry{
+			Actor:      user,
+			Action:     AuditRoomDelete,
+			TargetType: "room",
+			TargetID:   room.ID,
+			Before:     before,
+		})
 	})
 }
 
-func (s *RoomService) GetRoomByID(id uint) (models.RoomWithUser, error) {
+// GetRoomByID 房间详情，私密房间对非成员返回 ErrRoomNotFound
+func (s *RoomService) GetRoomByID(id uint, user *models.User) (models.RoomWithUser, error) {
 	var results models.RoomWithUser
 	err := s.db.Table("rooms").
 		Select("rooms.*, users.username").
@@ -140,6 +175,21 @@ func (s *RoomService) GetRoomByID(id uint) (models.RoomWithUser, error) {
 		Where("rooms.id = ?", id).
 		Order("rooms.created_at DESC").
 		Scan(&results).Error
-	return results, err
-
+	if err != nil {
+		return results, err
+	}
+	if results.ID == 0 {
+		return results, ErrRoomNotFound
+	}
+	results.Password = ""
+	if results.Privacy == models.RoomPrivacyPrivate {
+		role, err := s.MemberRole(&results.Room, user.ID)
+		if err != nil {
+			return results, err
+		}
+		if role == "" {
+			return models.RoomWithUser{}, ErrRoomNotFound
+		}
+	}
+	return results, nil
 }
@@ -0,0 +1,609 @@
+package services
+
+import (
+	"LiteAdmin/config"
+	"LiteAdmin/models"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"time"
+
+	goredis "github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrSessionNotFound      = errors.New("session not found")
+	ErrSessionNotQueued     = errors.New("session is not waiting in the queue")
+	ErrNotSessionAgent      = errors.New("session is not assigned to you")
+	ErrAgentUnavailable     = errors.New("agent is not online or has no free capacity")
+	ErrInvalidAgentStatus   = errors.New("status must be online, away or offline")
+	ErrInvalidAgentCapacity = errors.New("max_concurrent must be between 1 and 50")
+)
+
+// 分配策略
+const (
+	RoutingLeastLoad  = "least_load"  // 接待数最少的客服优先，相同时最久未分配的优先
+	RoutingRoundRobin = "round_robin" // 按最近一次分配时间轮流分配
+)
+
+const (
+	defaultAgentMaxConcurrent = 5
+	maxAgentConcurrent        = 50
+)
+
+// QueueStatus 会话的排队/接入状态，通过 WebSocket queue_update 事件推送给客户
+type QueueStatus struct {
+	SessionID uint   `json:"session_id"`
+	Status    string `json:"status"`
+	Position  int    `json:"position,omitempty"` // 排队位置，从 1 开始，只有排队中的会话有
+	AgentID   uint   `json:"agent_id,omitempty"`
+	AgentName string `json:"agent_name,omitempty"`
+}
+
+// SupportService 客服会话的排队与分配：新会话进入队列，按 QueuedAt 先后分配给在线且未满的客服；
+// 客服也可以手动接入排队中的会话、转接或释放自己的会话
+type SupportService struct {
+	db            *gorm.DB
+	redis         *goredis.Client // 向客户推送排队位置和接入通知
+	audit         *AuditService
+	routing       string
+	maxConcurrent int
+	firstResponse time.Duration // 首次响应目标
+	resolution    time.Duration // 解决目标
+	autoClose     time.Duration // 无活动自动关闭
+}
+
+func NewSupportService(db *gorm.DB, redisClient *goredis.Client, audit *AuditService, cfg *config.SupportConfig) *SupportService {
+	s := &SupportService{
+		db:            db,
+		redis:         redisClient,
+		audit:         audit,
+		routing:       cfg.Routing,
+		maxConcurrent: cfg.MaxConcurrent,
+		firstResponse: time.Duration(cfg.FirstResponseMinutes) * time.Minute,
+		resolution:    time.Duration(cfg.ResolutionHours) * time.Hour,
+		autoClose:     time.Duration(cfg.AutoCloseHours) * time.Hour,
+	}
+	if s.routing != RoutingRoundRobin {
+		s.routing = RoutingLeastLoad
+	}
+	if s.maxConcurrent <= 0 {
+		s.maxConcurrent = defaultAgentMaxConcurrent
+	}
+	if s.firstResponse <= 0 {
+		s.firstResponse = defaultFirstResponse
+	}
+	if s.resolution <= 0 {
+		s.resolution = defaultResolution
+	}
+	if s.autoClose <= 0 {
+		s.autoClose = defaultAutoClose
+	}
+	return s
+}
+
+// SetAgentStatus 更新客服状态和接待上限（maxConcurrent 为 0 时保持不变）；
+// 下线时其接待中的会话按原排队时间退回队列并断开客服在这些会话中的连接，上线后立即尝试分配排队中的会话
+func (s *SupportService) SetAgentStatus(ctx context.Context, user *models.User, status string, maxConcurrent int) (*models.SupportAgent, error) {
+	if status != models.AgentStatusOnline && status != models.AgentStatusAway && status != models.AgentStatusOffline {
+		return nil, ErrInvalidAgentStatus
+	}
+	if maxConcurrent < 0 || maxConcurrent > maxAgentConcurrent {
+		return nil, ErrInvalidAgentCapacity
+	}
+	var agent models.SupportAgent
+	var requeued []models.CustomerSession
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&agent, user.ID).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			agent = models.SupportAgent{UserID: user.ID, MaxConcurrent: s.maxConcurrent}
+		} else if err != nil {
+			return err
+		}
+		agent.Status = status
+		if maxConcurrent > 0 {
+			agent.MaxConcurrent = maxConcurrent
+		}
+		if err := tx.Save(&agent).Error; err != nil {
+			return err
+		}
+		if status != models.AgentStatusOffline {
+			return nil
+		}
+		if err := tx.Select("id", "room_id").
+			Where("agent_id = ? AND status = ?", user.ID, models.SessionStatusActive).
+			Find(&requeued).Error; err != nil || len(requeued) == 0 {
+			return err
+		}
+		ids := make([]uint, len(requeued))
+		for i := range requeued {
+			ids[i] = requeued[i].ID
+		}
+		return tx.Model(&models.CustomerSession{}).
+			Where("id IN ?", ids).
+			Updates(map[string]interface{}{
+				"agent_id":    nil,
+				"assigned_at": nil,
+				"status":      models.SessionStatusPending,
+			}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i := range requeued {
+		s.disconnectAgent(ctx, &requeued[i], user.ID)
+	}
+	s.Dispatch(ctx)
+	loads, err := s.agentLoads(s.db, []uint{agent.UserID})
+	if err != nil {
+		return nil, err
+	}
+	agent.ActiveSessions = loads[agent.UserID]
+	return &agent, nil
+}
+
+// ListAgents 所有客服及其当前接待数
+func (s *SupportService) ListAgents() ([]models.SupportAgent, error) {
+	var agents []models.SupportAgent
+	if err := s.db.Preload("User").Order("user_id ASC").Find(&agents).Error; err != nil {
+		return nil, err
+	}
+	ids := make([]uint, len(agents))
+	for i := range agents {
+		ids[i] = agents[i].UserID
+	}
+	loads, err := s.agentLoads(s.db, ids)
+	if err != nil {
+		return nil, err
+	}
+	for i := range agents {
+		agents[i].ActiveSessions = loads[agents[i].UserID]
+	}
+	return agents, nil
+}
+
+// ListQueue 排队中的会话，按分配顺序排列
+func (s *SupportService) ListQueue() ([]models.CustomerSession, error) {
+	var sessions []models.CustomerSession
+	err := queuedSessions(s.db).Preload("User").Find(&sessions).Error
+	return sessions, err
+}
+
+// Enqueue 会话进入队列末尾（新建或重新打开的会话）并尝试分配；重新打开的会话重新开始 SLA 计时
+func (s *SupportService) Enqueue(ctx context.Context, session *models.CustomerSession) error {
+	now := time.Now()
+	updates := map[string]interface{}{
+		"status":      models.SessionStatusPending,
+		"agent_id":    nil,
+		"assigned_at": nil,
+		"released_by": nil,
+		"queued_at":   now,
+		"updated_at":  now,
+	}
+	reopened := session.Status == models.SessionStatusClosed
+	if reopened {
+		updates["first_response_at"] = nil
+		updates["last_activity_at"] = now
+		updates["escalated_at"] = nil
+		updates["closed_at"] = nil
+		updates["first_response_breached"] = false
+		updates["resolution_breached"] = false
+	}
+	if err := s.db.Model(session).Updates(updates).Error; err != nil {
+		return err
+	}
+	session.Status = models.SessionStatusPending
+	session.AgentID, session.AssignedAt, session.ReleasedBy = nil, nil, nil
+	session.QueuedAt = &now
+	if reopened {
+		session.FirstResponseAt, session.EscalatedAt, session.ClosedAt = nil, nil, nil
+		session.LastActivityAt = &now
+		session.FirstResponseBreached, session.ResolutionBreached = false, false
+	}
+	s.Dispatch(ctx)
+	return nil
+}
+
+// Dispatch 把排队中的会话依次分配给有空闲的客服，直到队列为空或没有可用客服，
+// 然后向仍在排队的客户推送最新位置；客服上线、会话关闭或释放后调用
+func (s *SupportService) Dispatch(ctx context.Context) {
+	for {
+		session, err := s.assignNext(ctx)
+		if err != nil {
+			log.Printf("Failed to assign customer session: %v", err)
+			break
+		}
+		if session == nil {
+			break
+		}
+		s.notifyAssigned(ctx, session, "")
+	}
+	s.publishQueue(ctx)
+}
+
+// assignNext 分配队首的会话；队列为空或没有可用客服时返回 nil
+func (s *SupportService) assignNext(ctx context.Context) (*models.CustomerSession, error) {
+	var session models.CustomerSession
+	var assigned bool
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// SKIP LOCKED：多个节点同时分配时各自取不同的会话
+		err := queuedSessions(tx).Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).First(&session).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		agent, err := s.pickAgent(tx, session.ReleasedBy)
+		if err != nil || agent == nil {
+			return err
+		}
+		assigned = true
+		return assignSession(tx, &session, agent.UserID)
+	})
+	if err != nil || !assigned {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// pickAgent 按分配策略选出在线且未满的客服；优先跳过刚释放该会话的客服，没有其他人时仍分配给他
+func (s *SupportService) pickAgent(tx *gorm.DB, releasedBy *uint) (*models.SupportAgent, error) {
+	var agents []models.SupportAgent
+	// 按 user_id 顺序加锁，并发分配时不会死锁，也不会超出接待上限
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("status = ?", models.AgentStatusOnline).Order("user_id ASC").Find(&agents).Error; err != nil {
+		return nil, err
+	}
+	ids := make([]uint, len(agents))
+	for i := range agents {
+		ids[i] = agents[i].UserID
+	}
+	loads, err := s.agentLoads(tx, ids)
+	if err != nil {
+		return nil, err
+	}
+	var candidates []models.SupportAgent
+	for _, agent := range agents {
+		agent.ActiveSessions = loads[agent.UserID]
+		if agent.ActiveSessions < agent.MaxConcurrent {
+			candidates = append(candidates, agent)
+		}
+	}
+	if releasedBy != nil && len(candidates) > 1 {
+		candidates = slices.DeleteFunc(candidates, func(agent models.SupportAgent) bool {
+			return agent.UserID == *releasedBy
+		})
+	}
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+	slices.SortStableFunc(candidates, func(a, b models.SupportAgent) int {
+		if s.routing == RoutingLeastLoad && a.ActiveSessions != b.ActiveSessions {
+			return a.ActiveSessions - b.ActiveSessions
+		}
+		return compareAssignedAt(a.LastAssignedAt, b.LastAssignedAt)
+	})
+	return &candidates[0], nil
+}
+
+// Accept 客服手动接入排队中的会话，不受分配顺序限制，但受接待上限限制
+func (s *SupportService) Accept(ctx context.Context, sessionID uint, agent *models.User) (*models.CustomerSession, error) {
+	var session models.CustomerSession
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := lockSession(tx, sessionID, &session); err != nil {
+			return err
+		}
+		if session.Status != models.SessionStatusPending || session.AgentID != nil {
+			return ErrSessionNotQueued
+		}
+		if err := s.reserveAgent(tx, agent.ID); err != nil {
+			return err
+		}
+		before := session
+		if err := assignSession(tx, &session, agent.ID); err != nil {
+			return err
+		}
+		return s.audit.RecordTx(ctx, tx, AuditEntry{
+			Actor:      agent,
+			Action:     AuditSessionAssign,
+			TargetType: "customer_session",
+			TargetID:   session.ID,
+			Before:     before,
+			After:      session,
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.notifyAssigned(ctx, &session, agent.Username)
+	s.publishQueue(ctx)
+	return &session, nil
+}
+
+// Transfer 把自己接待的会话转给另一位在线且未满的客服
+func (s *SupportService) Transfer(ctx context.Context, sessionID uint, actor *models.User, targetID uint) (*models.CustomerSession, error) {
+	if targetID == actor.ID {
+		return nil, ErrAgentUnavailable
+	}
+	var session models.CustomerSession
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := s.lockOwnSession(tx, sessionID, actor.ID, &session); err != nil {
+			return err
+		}
+		if err := s.reserveAgent(tx, targetID); err != nil {
+			return err
+		}
+		before := session
+		if err := assignSession(tx, &session, targetID); err != nil {
+			return err
+		}
+		return s.audit.RecordTx(ctx, tx, AuditEntry{
+			Actor:      actor,
+			Action:     AuditSessionTransfer,
+			TargetType: "customer_session",
+			TargetID:   session.ID,
+			Before:     before,
+			After:      session,
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.disconnectAgent(ctx, &session, actor.ID)
+	s.notifyAssigned(ctx, &session, "")
+	// 转出的客服腾出了名额
+	s.Dispatch(ctx)
+	return &session, nil
+}
+
+// Release 客服释放自己接待的会话：会话保留原排队时间回到队列，优先分配给其他客服
+func (s *SupportService) Release(ctx context.Context, sessionID uint, actor *models.User) (*models.CustomerSession, error) {
+	var session models.CustomerSession
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := s.lockOwnSession(tx, sessionID, actor.ID, &session); err != nil {
+			return err
+		}
+		before := session
+		session.Status = models.SessionStatusPending
+		session.AgentID, session.AssignedAt = nil, nil
+		session.ReleasedBy = &actor.ID
+		session.UpdatedAt = time.Now()
+		if session.QueuedAt == nil {
+			session.QueuedAt = &session.CreatedAt
+		}
+		if err := tx.Model(&session).Select("status", "agent_id", "assigned_at", "released_by", "queued_at", "updated_at").Updates(&session).Error; err != nil {
+			return err
+		}
+		return s.audit.RecordTx(ctx, tx, AuditEntry{
+			Actor:      actor,
+			Action:     AuditSessionRelease,
+			TargetType: "customer_session",
+			TargetID:   session.ID,
+			Before:     before,
+			After:      session,
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.disconnectAgent(ctx, &session, actor.ID)
+	s.announce(ctx, &session, "客服已离开，正在为您转接其他客服")
+	s.Dispatch(ctx)
+	return &session, nil
+}
+
+// QueueStatus 用户自己的会话当前的排队/接入状态
+func (s *SupportService) QueueStatus(userID uint) (*QueueStatus, error) {
+	var session models.CustomerSession
+	if err := s.db.Preload("Agent").Where("user_id = ?", userID).First(&session).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrSessionNotFound
+		}
+		return nil, err
+	}
+	status := &QueueStatus{SessionID: session.ID, Status: session.Status}
+	if session.Agent != nil && session.Status == models.SessionStatusActive {
+		status.AgentID, status.AgentName = session.Agent.ID, session.Agent.Username
+	}
+	if session.Status == models.SessionStatusPending && session.AgentID == nil {
+		queuedAt := session.CreatedAt
+		if session.QueuedAt != nil {
+			queuedAt = *session.QueuedAt
+		}
+		var ahead int64
+		// 与 queuedSessions 的排序一致：已升级的会话排在前面
+		err := s.db.Model(&models.CustomerSession{}).
+			Where("status = ? AND agent_id IS NULL", models.SessionStatusPending).
+			Where("(escalated_at IS NULL, COALESCE(queued_at, created_at), id) < (?, ?, ?)", session.EscalatedAt == nil, queuedAt, session.ID).
+			Count(&ahead).Error
+		if err != nil {
+			return nil, err
+		}
+		status.Position = int(ahead) + 1
+	}
+	return status, nil
+}
+
+// reserveAgent 锁定客服并确认其在线且未满
+func (s *SupportService) reserveAgent(tx *gorm.DB, agentID uint) error {
+	var agent models.SupportAgent
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&agent, agentID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrAgentUnavailable
+		}
+		return err
+	}
+	if agent.Status != models.AgentStatusOnline {
+		return ErrAgentUnavailable
+	}
+	loads, err := s.agentLoads(tx, []uint{agentID})
+	if err != nil {
+		return err
+	}
+	if loads[agentID] >= agent.MaxConcurrent {
+		return ErrAgentUnavailable
+	}
+	return nil
+}
+
+// lockOwnSession 锁定会话并确认由 agentID 接待中
+func (s *SupportService) lockOwnSession(tx *gorm.DB, sessionID, agentID uint, session *models.CustomerSession) error {
+	if err := lockSession(tx, sessionID, session); err != nil {
+		return err
+	}
+	if session.Status != models.SessionStatusActive || session.AgentID == nil || *session.AgentID != agentID {
+		return ErrNotSessionAgent
+	}
+	return nil
+}
+
+// agentLoads 客服当前接待中的会话数
+func (s *SupportService) agentLoads(db *gorm.DB, agentIDs []uint) (map[uint]int, error) {
+	loads := make(map[uint]int, len(agentIDs))
+	if len(agentIDs) == 0 {
+		return loads, nil
+	}
+	var rows []struct {
+		AgentID uint
+		Count   int
+	}
+	err := db.Model(&models.CustomerSession{}).
+		Select("agent_id, COUNT(*) AS count").
+		Where("agent_id IN ? AND status = ?", agentIDs, models.SessionStatusActive).
+		Group("agent_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		loads[row.AgentID] = row.Count
+	}
+	return loads, nil
+}
+
+// notifyAssigned 通知客户已接入客服；agentName 为空时从数据库读取
+func (s *SupportService) notifyAssigned(ctx context.Context, session *models.CustomerSession, agentName string) {
+	if session.AgentID == nil {
+		return
+	}
+	if agentName == "" {
+		var agent models.User
+		if err := s.db.Select("id", "username").First(&agent, *session.AgentID).Error; err != nil {
+			log.Printf("Failed to load agent %d: %v", *session.AgentID, err)
+		}
+		agentName = agent.Username
+	}
+	s.publishStatus(ctx, session, &QueueStatus{
+		SessionID: session.ID,
+		Status:    models.SessionStatusActive,
+		AgentID:   *session.AgentID,
+		AgentName: agentName,
+	})
+	s.announce(ctx, session, fmt.Sprintf("客服 %s 正在为您服务", agentName))
+}
+
+// publishQueue 向所有排队中的客户推送当前位置
+func (s *SupportService) publishQueue(ctx context.Context) {
+	var sessions []models.CustomerSession
+	if err := queuedSessions(s.db).Select("id", "user_id", "room_id").Find(&sessions).Error; err != nil {
+		log.Printf("Failed to load customer service queue: %v", err)
+		return
+	}
+	for i := range sessions {
+		s.publishStatus(ctx, &sessions[i], &QueueStatus{
+			SessionID: sessions[i].ID,
+			Status:    models.SessionStatusPending,
+			Position:  i + 1,
+		})
+	}
+}
+
+func (s *SupportService) publishStatus(ctx context.Context, session *models.CustomerSession, status *QueueStatus) {
+	event := ChatControlEvent{
+		Action: ChatControlQueueUpdate,
+		UserID: session.UserID,
+		RoomID: customerServiceRoomID(session.RoomID),
+		Queue:  status,
+	}
+	if err := PublishChatControl(ctx, s.redis, event); err != nil {
+		log.Printf("Failed to publish queue update for session %d: %v", session.ID, err)
+	}
+}
+
+// disconnectAgent 断开不再接待该会话的客服在会话房间中的连接（转接、释放或下线后），重新连接时按当前接待关系校验
+func (s *SupportService) disconnectAgent(ctx context.Context, session *models.CustomerSession, agentID uint) {
+	event := ChatControlEvent{
+		Action: ChatControlDisconnectUser,
+		UserID: agentID,
+		RoomID: customerServiceRoomID(session.RoomID),
+		Reason: "session is no longer assigned to you",
+	}
+	if err := PublishChatControl(ctx, s.redis, event); err != nil {
+		log.Printf("Failed to publish disconnect of agent %d for session %d: %v", agentID, session.ID, err)
+	}
+}
+
+// announce 在客服会话中广播系统消息
+func (s *SupportService) announce(ctx context.Context, session *models.CustomerSession, content string) {
+	event := ChatControlEvent{
+		Action:  ChatControlSystemMessage,
+		RoomID:  customerServiceRoomID(session.RoomID),
+		Content: content,
+	}
+	if err := PublishChatControl(ctx, s.redis, event); err != nil {
+		log.Printf("Failed to publish system message for session %d: %v", session.ID, err)
+	}
+}
+
+// queuedSessions 排队中的会话：已升级的优先，其余按排队时间先后排列（旧数据没有排队时间，按创建时间）
+func queuedSessions(db *gorm.DB) *gorm.DB {
+	return db.Where("status = ? AND agent_id IS NULL", models.SessionStatusPending).
+		Order("escalated_at IS NULL ASC, COALESCE(queued_at, created_at) ASC, id ASC")
+}
+
+func lockSession(tx *gorm.DB, sessionID uint, session *models.CustomerSession) error {
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(session, sessionID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrSessionNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+// assignSession 把会话分配给客服并更新客服的最近分配时间
+func assignSession(tx *gorm.DB, session *models.CustomerSession, agentID uint) error {
+	now := time.Now()
+	session.Status = models.SessionStatusActive
+	session.AgentID = &agentID
+	session.AssignedAt = &now
+	session.ReleasedBy = nil
+	session.UpdatedAt = now
+	if err := tx.Model(session).Select("status", "agent_id", "assigned_at", "released_by", "updated_at").Updates(session).Error; err != nil {
+		return err
+	}
+	return tx.Model(&models.SupportAgent{UserID: agentID}).Update("last_assigned_at", now).Error
+}
+
+// compareAssignedAt 从未分配过的客服排在最前
+func compareAssignedAt(a, b *time.Time) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+	return a.Compare(*b)
+}
+
+func customerServiceRoomID(roomID uint) string {
+	return CustomerServiceRoomPrefix + strconv.FormatUint(uint64(roomID), 10)
+}
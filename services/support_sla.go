@@ -0,0 +1,248 @@
+package services
+
+import (
+	"LiteAdmin/models"
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	defaultFirstResponse = 5 * time.Minute
+	defaultResolution    = 24 * time.Hour
+	defaultAutoClose     = 12 * time.Hour
+
+	slaCheckInterval = time.Minute
+	slaBatchSize     = 100
+)
+
+// openSessionStatuses 未关闭的会话
+var openSessionStatuses = []string{models.SessionStatusPending, models.SessionStatusActive}
+
+// AgentSLAMetrics 客服在统计区间内的 SLA 指标
+type AgentSLAMetrics struct {
+	AgentID                 uint    `json:"agent_id"` // 0 表示排队期间超时、尚未分配客服的会话
+	Username                string  `json:"username,omitempty"`
+	Sessions                int     `json:"sessions"`  // 区间内分配给该客服的会话
+	Responded               int     `json:"responded"` // 其中已有客服回复的会话
+	AvgFirstResponseSeconds float64 `json:"avg_first_response_seconds"`
+	FirstResponseBreaches   int     `json:"first_response_breaches"`
+	ResolutionBreaches      int     `json:"resolution_breaches"`
+}
+
+// RunSLA 定期检查会话 SLA：首次响应超时的会话升级，超过解决目标的记为超时，长时间无活动的自动关闭；
+// 各节点都可以运行，会话按行加锁（SKIP LOCKED），不会被重复处理
+func (s *SupportService) RunSLA(ctx context.Context) {
+	ticker := time.NewTicker(slaCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.checkSLA(ctx)
+		}
+	}
+}
+
+func (s *SupportService) checkSLA(ctx context.Context) {
+	now := time.Now()
+
+	escalated, err := s.sweepSessions(ctx, func(db *gorm.DB) *gorm.DB {
+		return db.Where("first_response_at IS NULL AND escalated_at IS NULL").
+			Where("COALESCE(queued_at, created_at) < ?", now.Add(-s.firstResponse))
+	}, func(tx *gorm.DB, session *models.CustomerSession) error {
+		session.EscalatedAt = &now
+		session.FirstResponseBreached = true
+		if err := tx.Model(session).Select("escalated_at", "first_response_breached").Updates(session).Error; err != nil {
+			return err
+		}
+		if err := s.audit.RecordTx(ctx, tx, AuditEntry{
+			Action:     AuditSessionEscalate,
+			TargetType: "customer_session",
+			TargetID:   session.ID,
+			After:      map[string]interface{}{"agent_id": session.AgentID, "waited_seconds": int(now.Sub(slaStart(session)).Seconds())},
+		}); err != nil {
+			return err
+		}
+		return recordBreach(tx, session, models.SLAFirstResponse, s.firstResponse)
+	})
+	if err != nil {
+		log.Printf("Failed to escalate customer sessions: %v", err)
+	}
+	for i := range escalated {
+		s.announce(ctx, &escalated[i], "客服响应超时，您的会话已升级优先处理")
+	}
+
+	_, err = s.sweepSessions(ctx, func(db *gorm.DB) *gorm.DB {
+		return db.Where("resolution_breached = ?", false).
+			Where("COALESCE(queued_at, created_at) < ?", now.Add(-s.resolution))
+	}, func(tx *gorm.DB, session *models.CustomerSession) error {
+		session.ResolutionBreached = true
+		if err := tx.Model(session).Select("resolution_breached").Updates(session).Error; err != nil {
+			return err
+		}
+		return recordBreach(tx, session, models.SLAResolution, s.resolution)
+	})
+	if err != nil {
+		log.Printf("Failed to record resolution SLA breaches: %v", err)
+	}
+
+	closed, err := s.sweepSessions(ctx, func(db *gorm.DB) *gorm.DB {
+		return db.Where("COALESCE(last_activity_at, queued_at, created_at) < ?", now.Add(-s.autoClose))
+	}, func(tx *gorm.DB, session *models.CustomerSession) error {
+		before := *session
+		session.Status = models.SessionStatusClosed
+		session.ClosedAt = &now
+		session.UpdatedAt = now
+		if err := tx.Model(session).Select("status", "closed_at", "updated_at").Updates(session).Error; err != nil {
+			return err
+		}
+		return s.audit.RecordTx(ctx, tx, AuditEntry{
+			Action:     AuditSessionStatus,
+			TargetType: "customer_session",
+			TargetID:   session.ID,
+			Before:     before,
+			After:      session,
+		})
+	})
+	if err != nil {
+		log.Printf("Failed to auto-close customer sessions: %v", err)
+	}
+	for i := range closed {
+		s.announce(ctx, &closed[i], fmt.Sprintf("会话已超过 %d 小时没有新消息，已自动关闭", int(s.autoClose.Hours())))
+	}
+
+	// 升级改变了排队顺序，关闭腾出了客服名额
+	if len(escalated) > 0 || len(closed) > 0 {
+		s.Dispatch(ctx)
+	}
+}
+
+// sweepSessions 分批锁定符合条件的未关闭会话并逐个处理，返回处理过的会话
+func (s *SupportService) sweepSessions(ctx context.Context, filter func(*gorm.DB) *gorm.DB, apply func(*gorm.DB, *models.CustomerSession) error) ([]models.CustomerSession, error) {
+	var done []models.CustomerSession
+	for {
+		var batch []models.CustomerSession
+		err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			query := filter(tx.Where("status IN ?", openSessionStatuses))
+			if err := query.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+				Order("id ASC").Limit(slaBatchSize).Find(&batch).Error; err != nil {
+				return err
+			}
+			for i := range batch {
+				if err := apply(tx, &batch[i]); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return done, err
+		}
+		done = append(done, batch...)
+		if len(batch) < slaBatchSize {
+			return done, nil
+		}
+	}
+}
+
+// SLAMetrics 统计 [from, to) 区间内各客服的接待量、平均首次响应时间和 SLA 超时次数
+func (s *SupportService) SLAMetrics(from, to time.Time) ([]AgentSLAMetrics, error) {
+	metrics := make(map[uint]*AgentSLAMetrics)
+	entry := func(agentID uint) *AgentSLAMetrics {
+		if m, ok := metrics[agentID]; ok {
+			return m
+		}
+		m := &AgentSLAMetrics{AgentID: agentID}
+		metrics[agentID] = m
+		return m
+	}
+
+	var sessions []struct {
+		AgentID   uint
+		Sessions  int
+		Responded int
+		AvgFirst  float64
+	}
+	err := s.db.Model(&models.CustomerSession{}).
+		Select("agent_id, COUNT(*) AS sessions, COUNT(first_response_at) AS responded, "+
+			"COALESCE(AVG(EXTRACT(EPOCH FROM first_response_at - COALESCE(queued_at, created_at))), 0) AS avg_first").
+		Where("agent_id IS NOT NULL AND assigned_at >= ? AND assigned_at < ?", from, to).
+		Group("agent_id").
+		Scan(&sessions).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range sessions {
+		m := entry(row.AgentID)
+		m.Sessions, m.Responded, m.AvgFirstResponseSeconds = row.Sessions, row.Responded, row.AvgFirst
+	}
+
+	var breaches []struct {
+		AgentID uint
+		Type    string
+		Count   int
+	}
+	err = s.db.Model(&models.SLABreach{}).
+		Select("COALESCE(agent_id, 0) AS agent_id, type, COUNT(*) AS count").
+		Where("created_at >= ? AND created_at < ?", from, to).
+		Group("COALESCE(agent_id, 0), type").
+		Scan(&breaches).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range breaches {
+		m := entry(row.AgentID)
+		switch row.Type {
+		case models.SLAFirstResponse:
+			m.FirstResponseBreaches = row.Count
+		case models.SLAResolution:
+			m.ResolutionBreaches = row.Count
+		}
+	}
+
+	ids := make([]uint, 0, len(metrics))
+	for id := range metrics {
+		ids = append(ids, id)
+	}
+	var users []models.User
+	if err := s.db.Select("id", "username").Where("id IN ?", ids).Find(&users).Error; err != nil {
+		return nil, err
+	}
+	for _, user := range users {
+		metrics[user.ID].Username = user.Username
+	}
+
+	result := make([]AgentSLAMetrics, 0, len(metrics))
+	for _, m := range metrics {
+		result = append(result, *m)
+	}
+	slices.SortFunc(result, func(a, b AgentSLAMetrics) int {
+		return int(a.AgentID) - int(b.AgentID)
+	})
+	return result, nil
+}
+
+// recordBreach 记录一次 SLA 超时，计入当前接待的客服
+func recordBreach(tx *gorm.DB, session *models.CustomerSession, slaType string, target time.Duration) error {
+	return tx.Create(&models.SLABreach{
+		SessionID: session.ID,
+		AgentID:   session.AgentID,
+		Type:      slaType,
+		Target:    int(target.Seconds()),
+	}).Error
+}
+
+// slaStart SLA 计时起点
+func slaStart(session *models.CustomerSession) time.Time {
+	if session.QueuedAt != nil {
+		return *session.QueuedAt
+	}
+	return session.CreatedAt
+}
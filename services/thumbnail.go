@@ -0,0 +1,82 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	_ "image/gif" // 注册 GIF 解码
+	"image/jpeg"
+	_ "image/png" // 注册 PNG 解码
+)
+
+const (
+	thumbnailSize    = 320        // 缩略图最长边
+	maxDecodePixels  = 50_000_000 // 超过该像素数的图片不生成缩略图，防止解压炸弹
+	thumbnailQuality = 80
+)
+
+var errImageTooLarge = errors.New("image dimensions too large")
+
+// makeThumbnail 生成 JPEG 缩略图（透明部分填充白色），同时返回原图尺寸；
+// 不支持解码的格式（如 WebP）返回错误，调用方只保存原图
+func makeThumbnail(data []byte) (thumb []byte, width, height int, err error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	if cfg.Width*cfg.Height > maxDecodePixels {
+		return nil, cfg.Width, cfg.Height, errImageTooLarge
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, cfg.Width, cfg.Height, err
+	}
+
+	w, h := cfg.Width, cfg.Height
+	if w > thumbnailSize || h > thumbnailSize {
+		if w >= h {
+			w, h = thumbnailSize, max(1, h*thumbnailSize/w)
+		} else {
+			w, h = max(1, w*thumbnailSize/h), thumbnailSize
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, scaleDown(src, w, h), &jpeg.Options{Quality: thumbnailQuality}); err != nil {
+		return nil, cfg.Width, cfg.Height, err
+	}
+	return buf.Bytes(), cfg.Width, cfg.Height, nil
+}
+
+// scaleDown 区域平均缩小图片，并合成到白色背景上
+func scaleDown(src image.Image, w, h int) *image.RGBA {
+	b := src.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		y0 := b.Min.Y + y*sh/h
+		y1 := max(b.Min.Y+(y+1)*sh/h, y0+1)
+		for x := 0; x < w; x++ {
+			x0 := b.Min.X + x*sw/w
+			x1 := max(b.Min.X+(x+1)*sw/w, x0+1)
+			var r, g, bl, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					// 颜色已预乘 alpha，叠加白色背景
+					r += uint64(cr + 0xffff - ca)
+					g += uint64(cg + 0xffff - ca)
+					bl += uint64(cb + 0xffff - ca)
+					n++
+				}
+			}
+			dst.SetRGBA(x, y, color.RGBA{
+				R: uint8(r / n >> 8),
+				G: uint8(g / n >> 8),
+				B: uint8(bl / n >> 8),
+				A: 0xff,
+			})
+		}
+	}
+	return dst
+}
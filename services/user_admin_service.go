@@ -0,0 +1,251 @@
+package services
+
+import (
+	"LiteAdmin/models"
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrInvalidUserType = errors.New("invalid user type")
+	ErrCannotBanSelf   = errors.New("cannot ban or change your own account")
+	ErrAlreadyBanned   = errors.New("user is already banned")
+	ErrNotBanned       = errors.New("user is not banned")
+)
+
+// User.Type 允许的取值
+var userTypes = []string{models.RoleAdmin, models.RoleMerchant, models.RoleClient}
+
+const (
+	defaultUserPageSize = 20
+	maxUserPageSize     = 100
+)
+
+// UserFilter 管理后台用户搜索条件
+type UserFilter struct {
+	Keyword  string // 模糊匹配邮箱或用户名
+	Email    string
+	Username string
+	Provider string
+	Type     string
+	Banned   *bool
+	Page     int
+	PageSize int
+}
+
+// UserDetail 管理后台用户详情
+type UserDetail struct {
+	User             models.User              `json:"user"`
+	Roles            []string                 `json:"roles"`
+	Devices          []models.DeviceToken     `json:"devices"`           // 登录会话（记住我设备）
+	CustomerSessions []models.CustomerSession `json:"customer_sessions"` // 客服会话
+}
+
+// UserAdminService 管理员维护用户：搜索、类型调整、封禁与强制下线
+type UserAdminService struct {
+	db    *gorm.DB
+	auth  *AuthService
+	rbac  *RBACService
+	redis *redis.Client
+}
+
+func NewUserAdminService(db *gorm.DB, auth *AuthService, rbac *RBACService, redisClient *redis.Client) *UserAdminService {
+	return &UserAdminService{db: db, auth: auth, rbac: rbac, redis: redisClient}
+}
+
+// SearchUsers 分页搜索用户
+func (s *UserAdminService) SearchUsers(filter UserFilter) ([]models.User, int64, error) {
+	query := s.db.Model(&models.User{})
+	if kw := strings.TrimSpace(filter.Keyword); kw != "" {
+		like := "%" + strings.ToLower(kw) + "%"
+		query = query.Where("LOWER(email) LIKE ? OR LOWER(username) LIKE ?", like, like)
+	}
+	if filter.Email != "" {
+		query = query.Where("LOWER(email) = ?", strings.ToLower(filter.Email))
+	}
+	if filter.Username != "" {
+		query = query.Where("username = ?", filter.Username)
+	}
+	if filter.Provider != "" {
+		query = query.Where("provider = ?", filter.Provider)
+	}
+	if filter.Type != "" {
+		if filter.Type == models.RoleClient {
+			query = query.Where("type = ? OR type = '' OR type IS NULL", filter.Type)
+		} else {
+			query = query.Where("type = ?", filter.Type)
+		}
+	}
+	if filter.Banned != nil {
+		if *filter.Banned {
+			query = query.Where("banned_at IS NOT NULL")
+		} else {
+			query = query.Where("banned_at IS NULL")
+		}
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	page, pageSize := normalizePage(filter.Page, filter.PageSize)
+	var users []models.User
+	err := query.Order("id DESC").Offset((page - 1) * pageSize).Limit(pageSize).Find(&users).Error
+	return users, total, err
+}
+
+// GetUserDetail 用户详情：商家信息、角色、登录会话与客服会话
+func (s *UserAdminService) GetUserDetail(userID uint) (*UserDetail, error) {
+	var detail UserDetail
+	if err := s.db.Preload("MerchantInfo").First(&detail.User, userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+	roles, err := s.rbac.UserRoles(&detail.User)
+	if err != nil {
+		return nil, err
+	}
+	detail.Roles = make([]string, 0, len(roles))
+	for _, role := range roles {
+		detail.Roles = append(detail.Roles, role.Name)
+	}
+	if detail.Devices, err = s.auth.ListDevices(userID); err != nil {
+		return nil, err
+	}
+	if err := s.db.Where("user_id = ?", userID).Order("updated_at DESC").Find(&detail.CustomerSessions).Error; err != nil {
+		return nil, err
+	}
+	return &detail, nil
+}
+
+// ChangeType 修改用户类型，类型决定隐式角色，因此同时清除权限缓存
+func (s *UserAdminService) ChangeType(ctx context.Context, actor *models.User, userID uint, userType string) (*models.User, error) {
+	if !isUserType(userType) {
+		return nil, ErrInvalidUserType
+	}
+	if actor.ID == userID {
+		return nil, ErrCannotBanSelf
+	}
+	user, err := s.findUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.db.Model(user).Update("type", userType).Error; err != nil {
+		return nil, err
+	}
+	s.rbac.InvalidateUser(ctx, userID)
+	return user, nil
+}
+
+// BanUser 封禁用户：吊销全部凭据并断开聊天连接
+func (s *UserAdminService) BanUser(ctx context.Context, actor *models.User, userID uint, reason string) (*models.User, error) {
+	if actor.ID == userID {
+		return nil, ErrCannotBanSelf
+	}
+	user, err := s.findUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.BannedAt != nil {
+		return nil, ErrAlreadyBanned
+	}
+	now := time.Now()
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(user).Updates(map[string]interface{}{
+			"banned_at":  now,
+			"ban_reason": reason,
+		}).Error; err != nil {
+			return err
+		}
+		return s.auth.RevokeAllTokens(tx, userID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.disconnect(ctx, userID, "account banned")
+	return user, nil
+}
+
+// UnbanUser 解除封禁，用户需要重新登录
+func (s *UserAdminService) UnbanUser(userID uint) (*models.User, error) {
+	user, err := s.findUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.BannedAt == nil {
+		return nil, ErrNotBanned
+	}
+	if err := s.db.Model(user).Updates(map[string]interface{}{
+		"banned_at":  nil,
+		"ban_reason": "",
+	}).Error; err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// ForceLogout 强制用户在所有设备上下线
+func (s *UserAdminService) ForceLogout(ctx context.Context, userID uint) error {
+	if _, err := s.findUser(userID); err != nil {
+		return err
+	}
+	if err := s.db.Transaction(func(tx *gorm.DB) error {
+		return s.auth.RevokeAllTokens(tx, userID)
+	}); err != nil {
+		return err
+	}
+	s.disconnect(ctx, userID, "signed out by administrator")
+	return nil
+}
+
+func (s *UserAdminService) disconnect(ctx context.Context, userID uint, reason string) {
+	err := PublishChatControl(ctx, s.redis, ChatControlEvent{
+		Action: ChatControlDisconnectUser,
+		UserID: userID,
+		Reason: reason,
+	})
+	if err != nil {
+		log.Printf("Failed to publish chat disconnect for user %d: %v", userID, err)
+	}
+}
+
+func (s *UserAdminService) findUser(userID uint) (*models.User, error) {
+	var user models.User
+	if err := s.db.First(&user, userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
+func isUserType(userType string) bool {
+	for _, t := range userTypes {
+		if t == userType {
+			return true
+		}
+	}
+	return false
+}
+
+func normalizePage(page, pageSize int) (int, int) {
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 {
+		pageSize = defaultUserPageSize
+	}
+	if pageSize > maxUserPageSize {
+		pageSize = maxUserPageSize
+	}
+	return page, pageSize
+}
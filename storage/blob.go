@@ -0,0 +1,55 @@
+// Package storage 聊天附件等二进制对象的存储，支持本地磁盘和 S3 兼容服务
+package storage
+
+import (
+	"LiteAdmin/config"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+var (
+	ErrNotFound   = errors.New("object not found")
+	ErrInvalidKey = errors.New("invalid object key")
+)
+
+// Blob 对象存储，key 为 "/" 分隔的相对路径
+type Blob interface {
+	// Put 写入对象，size 为内容长度（S3 上传需要）
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Get 读取对象，对象不存在时返回 ErrNotFound
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete 删除对象，对象不存在时不报错
+	Delete(ctx context.Context, key string) error
+}
+
+// New 按配置创建存储，driver 为空时使用本地磁盘
+func New(cfg *config.StorageConfig) (Blob, error) {
+	switch cfg.Driver {
+	case "", "local":
+		dir := cfg.LocalDir
+		if dir == "" {
+			dir = "uploads"
+		}
+		return NewLocalBlob(dir)
+	case "s3":
+		return NewS3Blob(&cfg.S3)
+	default:
+		return nil, fmt.Errorf("unknown storage driver %q", cfg.Driver)
+	}
+}
+
+// validateKey 拒绝绝对路径、空段和 ".."，防止写出存储目录
+func validateKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return ErrInvalidKey
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return ErrInvalidKey
+		}
+	}
+	return nil
+}